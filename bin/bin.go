@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"os/signal"
 	"runtime/pprof"
 	"sync"
+	"syscall"
 	"time"
 
 	"fmt"
@@ -119,15 +121,22 @@ func ZGrab2Main() {
 	modTypes := []string{modType}
 	modFlags := []any{flag}
 
-	if m, ok := flag.(*zgrab2.MultipleCommand); ok {
+	var iniFile string
+	switch m := flag.(type) {
+	case *zgrab2.MultipleCommand:
+		iniFile = m.ConfigFileName
+	case *zgrab2.ReplayCommand:
+		iniFile = m.ConfigFileName
+	}
+	if iniFile != "" {
 		iniParser := zgrab2.NewIniParser()
-		if m.ConfigFileName == "-" {
+		if iniFile == "-" {
 			modTypes, modFlags, err = iniParser.Parse(os.Stdin)
 		} else {
-			modTypes, modFlags, err = iniParser.ParseFile(m.ConfigFileName)
+			modTypes, modFlags, err = iniParser.ParseFile(iniFile)
 		}
 		if err != nil {
-			log.Fatalf("could not parse multiple: %s", err)
+			log.Fatalf("could not parse %s: %s", modType, err)
 		}
 		if len(modTypes) != len(modFlags) {
 			log.Fatalf("error parsing flags")
@@ -142,8 +151,15 @@ func ZGrab2Main() {
 		mod := zgrab2.GetModule(modType)
 		f, _ := modFlags[i].(zgrab2.ScanFlags)
 		s := mod.NewScanner()
-		s.Init(f)
+		if err := s.Init(f); err != nil {
+			log.Fatalf("could not initialize %s: %s", modType, err)
+		}
 		zgrab2.RegisterScan(s.GetName(), s)
+		conditions, err := zgrab2.ConditionsFromFlags(f)
+		if err != nil {
+			log.Fatalf("could not parse conditions of %s: %s", s.GetName(), err)
+		}
+		zgrab2.SetScanConditions(s.GetName(), conditions)
 	}
 
 	wg := sync.WaitGroup{}
@@ -166,9 +182,24 @@ func ZGrab2Main() {
 		}
 	}()
 
+	// The first SIGINT or SIGTERM stops the scan gracefully, the second one
+	// exits immediately.
+	scanCtx, interrupt := context.WithCancel(context.Background())
+	defer interrupt()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		sig := <-signals
+		log.Warnf("received %s, finishing the scans in progress (send again to exit immediately)", sig)
+		interrupt()
+		sig = <-signals
+		log.Fatalf("received %s, exiting immediately", sig)
+	}()
+
 	start := time.Now()
 	log.Infof("started grab at %s", start.Format(time.RFC3339))
-	zgrab2.Process(monitor)
+	zgrab2.ProcessContext(scanCtx, monitor)
 	end := time.Now()
 	log.Infof("finished grab at %s", end.Format(time.RFC3339))
 	monitor.Stop()
@@ -179,6 +210,7 @@ func ZGrab2Main() {
 		StartTime:         start.Format(time.RFC3339),
 		EndTime:           end.Format(time.RFC3339),
 		Duration:          end.Sub(start).String(),
+		Interrupted:       scanCtx.Err() != nil,
 	}
 	enc := json.NewEncoder(zgrab2.GetMetaFile())
 	if err := enc.Encode(&s); err != nil {
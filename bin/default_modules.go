@@ -6,6 +6,7 @@ import (
 	"github.com/zmap/zgrab2/modules/bacnet"
 	"github.com/zmap/zgrab2/modules/banner"
 	"github.com/zmap/zgrab2/modules/dnp3"
+	"github.com/zmap/zgrab2/modules/dns"
 	"github.com/zmap/zgrab2/modules/fox"
 	"github.com/zmap/zgrab2/modules/ftp"
 	"github.com/zmap/zgrab2/modules/http"
@@ -13,17 +14,22 @@ import (
 	"github.com/zmap/zgrab2/modules/ipp"
 	"github.com/zmap/zgrab2/modules/modbus"
 	"github.com/zmap/zgrab2/modules/mongodb"
+	"github.com/zmap/zgrab2/modules/mqtt"
 	"github.com/zmap/zgrab2/modules/mssql"
 	"github.com/zmap/zgrab2/modules/mysql"
+	"github.com/zmap/zgrab2/modules/nmap"
 	"github.com/zmap/zgrab2/modules/ntp"
 	"github.com/zmap/zgrab2/modules/oracle"
 	"github.com/zmap/zgrab2/modules/pop3"
 	"github.com/zmap/zgrab2/modules/postgres"
 	"github.com/zmap/zgrab2/modules/rdp"
 	"github.com/zmap/zgrab2/modules/redis"
+	"github.com/zmap/zgrab2/modules/rtsp"
 	"github.com/zmap/zgrab2/modules/siemens"
+	"github.com/zmap/zgrab2/modules/sip"
 	"github.com/zmap/zgrab2/modules/smb"
 	"github.com/zmap/zgrab2/modules/smtp"
+	"github.com/zmap/zgrab2/modules/snmp"
 	"github.com/zmap/zgrab2/modules/telnet"
 )
 
@@ -34,6 +40,7 @@ func init() {
 		"bacnet":   &bacnet.Module{},
 		"banner":   &banner.Module{},
 		"dnp3":     &dnp3.Module{},
+		"dns":      &dns.Module{},
 		"fox":      &fox.Module{},
 		"ftp":      &ftp.Module{},
 		"http":     &http.Module{},
@@ -41,16 +48,21 @@ func init() {
 		"ipp":      &ipp.Module{},
 		"modbus":   &modbus.Module{},
 		"mongodb":  &mongodb.Module{},
+		"mqtt":     &mqtt.Module{},
 		"mssql":    &mssql.Module{},
 		"mysql":    &mysql.Module{},
+		"nmap":     &nmap.Module{},
 		"ntp":      &ntp.Module{},
 		"oracle":   &oracle.Module{},
 		"pop3":     &pop3.Module{},
 		"postgres": &postgres.Module{},
 		"redis":    &redis.Module{},
+		"rtsp":     &rtsp.Module{},
 		"siemens":  &siemens.Module{},
+		"sip":      &sip.Module{},
 		"smb":      &smb.Module{},
 		"smtp":     &smtp.Module{},
+		"snmp":     &snmp.Module{},
 		"ssh":      &modules.SSHModule{},
 		"telnet":   &telnet.Module{},
 		"tls":      &modules.TLSModule{},
@@ -8,4 +8,5 @@ type Summary struct {
 	StartTime         string                   `json:"start"`
 	EndTime           string                   `json:"end"`
 	Duration          string                   `json:"duration"`
+	Interrupted       bool                     `json:"interrupted"`
 }
@@ -0,0 +1,190 @@
+package zgrab2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrBlocklisted is returned when a target or the address being connected to
+// is excluded by --blocklist-file or --allowlist-file.
+var ErrBlocklisted = NewScanError(SCAN_BLOCKLISTED, errors.New("address excluded by the blocklist or allowlist"))
+
+// addressRange is an inclusive range of IPv6 (or IPv4-mapped) addresses.
+type addressRange struct {
+	first, last [16]byte
+}
+
+// AddressSet is a set of IP networks, such as a ZMap blocklist.
+type AddressSet struct {
+	// sorted, non-overlapping ranges
+	ranges []addressRange
+}
+
+// ParseAddressSet reads a list of networks in the ZMap blocklist format: one
+// IP address or CIDR block per line, followed by an optional comment. Lines
+// starting with # and empty lines are ignored.
+func ParseAddressSet(r io.Reader) (*AddressSet, error) {
+	var ranges []addressRange
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		var ipnet *net.IPNet
+		if ip := net.ParseIP(fields[0]); ip != nil {
+			ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))}
+		} else if _, cidr, err := net.ParseCIDR(fields[0]); err == nil {
+			ipnet = cidr
+		} else {
+			return nil, fmt.Errorf("line %d: can't parse %q as an IP address or CIDR block", line, fields[0])
+		}
+		ranges = append(ranges, networkRange(ipnet))
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].first[:], ranges[j].first[:]) < 0
+	})
+	set := &AddressSet{}
+	for _, r := range ranges {
+		n := len(set.ranges)
+		if n > 0 && bytes.Compare(r.first[:], set.ranges[n-1].last[:]) <= 0 {
+			// overlapping: extend the previous range
+			if bytes.Compare(r.last[:], set.ranges[n-1].last[:]) > 0 {
+				set.ranges[n-1].last = r.last
+			}
+			continue
+		}
+		set.ranges = append(set.ranges, r)
+	}
+	return set, nil
+}
+
+// LoadAddressSet reads an AddressSet from the file at path.
+func LoadAddressSet(path string) (*AddressSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseAddressSet(f)
+}
+
+// networkRange returns the first and last address of ipnet, as 16-byte
+// addresses.
+func networkRange(ipnet *net.IPNet) addressRange {
+	var r addressRange
+	ip := ipnet.IP.To16()
+	mask := ipnet.Mask
+	if len(mask) == net.IPv4len {
+		// align the mask with the IPv4-mapped address
+		mask = append(net.CIDRMask(96, 128)[:12:12], mask...)
+	}
+	for i := range ip {
+		r.first[i] = ip[i] & mask[i]
+		r.last[i] = ip[i] | ^mask[i]
+	}
+	return r
+}
+
+// Contains returns true if ip is in one of the networks of the set.
+func (set *AddressSet) Contains(ip net.IP) bool {
+	ip = ip.To16()
+	if ip == nil {
+		return false
+	}
+	i := sort.Search(len(set.ranges), func(i int) bool {
+		return bytes.Compare(set.ranges[i].last[:], ip) >= 0
+	})
+	return i < len(set.ranges) && bytes.Compare(set.ranges[i].first[:], ip) <= 0
+}
+
+// Len returns the number of disjoint ranges in the set.
+func (set *AddressSet) Len() int {
+	return len(set.ranges)
+}
+
+// addressLists is an allowlist and a blocklist, either of which may be nil.
+type addressLists struct {
+	allow *AddressSet
+	block *AddressSet
+}
+
+// allowed returns false if ip is outside the allowlist, or in the blocklist.
+func (lists *addressLists) allowed(ip net.IP) bool {
+	if lists.allow != nil && !lists.allow.Contains(ip) {
+		return false
+	}
+	return lists.block == nil || !lists.block.Contains(ip)
+}
+
+// checkDial is a net.Dialer Control function that refuses to connect to
+// excluded addresses. It sees the address after name resolution, so it also
+// catches domains that resolve into excluded networks.
+func (lists *addressLists) checkDial(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip != nil && !lists.allowed(ip) {
+		return ErrBlocklisted
+	}
+	return nil
+}
+
+// addressFilter is the --allowlist-file and --blocklist-file, if any.
+var addressFilter addressLists
+
+func loadAddressFilter() {
+	var err error
+	if config.AllowlistFile != "" {
+		if addressFilter.allow, err = LoadAddressSet(config.AllowlistFile); err != nil {
+			log.Fatalf("could not load allowlist from %s: %s", config.AllowlistFile, err)
+		}
+	}
+	if config.BlocklistFile != "" {
+		if addressFilter.block, err = LoadAddressSet(config.BlocklistFile); err != nil {
+			log.Fatalf("could not load blocklist from %s: %s", config.BlocklistFile, err)
+		}
+	}
+}
+
+// IsAddressAllowed returns false if ip is outside the --allowlist-file, or in
+// the --blocklist-file.
+func IsAddressAllowed(ip net.IP) bool {
+	return addressFilter.allowed(ip)
+}
+
+// IsAddressAllowedContext is IsAddressAllowed for a scan running with ctx,
+// which may carry the allowlist and blocklist of an Engine.
+func IsAddressAllowedContext(ctx context.Context, ip net.IP) bool {
+	return contextDialPolicy(ctx).addresses.allowed(ip)
+}
+
+// dialControl returns the net.Dialer Control function refusing to connect to
+// the addresses excluded for ctx, or nil if there is no allowlist or
+// blocklist.
+func dialControl(ctx context.Context) func(network, address string, c syscall.RawConn) error {
+	lists := contextDialPolicy(ctx).addresses
+	if lists.allow == nil && lists.block == nil {
+		return nil
+	}
+	return lists.checkDial
+}
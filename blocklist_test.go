@@ -0,0 +1,120 @@
+package zgrab2
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+const testBlocklist = `# ZMap-style blocklist
+10.0.0.0/8           # RFC1918
+192.168.1.1
+192.168.0.0/16 overlapping
+2001:db8::/32
+`
+
+func TestParseAddressSet(t *testing.T) {
+	set, err := ParseAddressSet(strings.NewReader(testBlocklist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.Len() != 3 {
+		t.Errorf("expected the overlapping networks to be merged, got %d ranges", set.Len())
+	}
+	tests := map[string]bool{
+		"10.0.0.0":        true,
+		"10.255.255.255":  true,
+		"11.0.0.0":        false,
+		"9.255.255.255":   false,
+		"192.168.1.1":     true,
+		"192.168.200.3":   true,
+		"::ffff:10.1.2.3": true,
+		"2001:db8:1::1":   true,
+		"2001:db9::1":     false,
+		"::1":             false,
+	}
+	for ip, expected := range tests {
+		if set.Contains(net.ParseIP(ip)) != expected {
+			t.Errorf("Contains(%s): expected %v", ip, expected)
+		}
+	}
+
+	if _, err := ParseAddressSet(strings.NewReader("10.0.0.0/8\nexample.com\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+}
+
+// withAddressFilter sets the allowlist and blocklist for the duration of the
+// test.
+func withAddressFilter(t *testing.T, allow, block string) {
+	saved := addressFilter
+	t.Cleanup(func() { addressFilter = saved })
+	var err error
+	addressFilter.allow, addressFilter.block = nil, nil
+	if allow != "" {
+		if addressFilter.allow, err = ParseAddressSet(strings.NewReader(allow)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if block != "" {
+		if addressFilter.block, err = ParseAddressSet(strings.NewReader(block)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIsAddressAllowed(t *testing.T) {
+	withAddressFilter(t, "10.0.0.0/8", "10.1.0.0/16")
+	tests := map[string]bool{
+		"10.0.0.1": true,
+		"10.1.0.1": false,
+		"11.0.0.1": false,
+	}
+	for ip, expected := range tests {
+		if IsAddressAllowed(net.ParseIP(ip)) != expected {
+			t.Errorf("IsAddressAllowed(%s): expected %v", ip, expected)
+		}
+	}
+}
+
+func TestExpandTargetsBlocklisted(t *testing.T) {
+	withAddressFilter(t, "", "2.2.2.2/31")
+	_, ipnet, _ := net.ParseCIDR("2.2.2.0/30")
+	ch := make(chan ScanTarget, 4)
+	expandTargets(ipnet, ScanTarget{}, nil, ch)
+	close(ch)
+	var total, blocked int
+	for target := range ch {
+		total++
+		if !IsAddressAllowed(target.IP) {
+			blocked++
+		}
+	}
+	if total != 4 || blocked != 2 {
+		t.Errorf("expected the 4 addresses with 2 blocked, got %d with %d blocked", total, blocked)
+	}
+}
+
+func TestDialBlocklisted(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	withAddressFilter(t, "", "127.0.0.0/8")
+
+	_, err = DialTimeoutConnection("tcp", listener.Addr().String(), 0, 0)
+	if status := TryGetScanStatus(err); status != SCAN_BLOCKLISTED {
+		t.Errorf("expected %s, got %s (%v)", SCAN_BLOCKLISTED, status, err)
+	}
+	// a domain resolving to a blocked address is refused too
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	_, err = GetTimeoutConnectionDialer(0).Dial("tcp", net.JoinHostPort("localhost", port))
+	if status := TryGetScanStatus(err); status != SCAN_BLOCKLISTED {
+		t.Errorf("expected %s for localhost, got %s (%v)", SCAN_BLOCKLISTED, status, err)
+	}
+	_, err = (&ScanTarget{IP: net.ParseIP("127.0.0.1")}).OpenUDP(&BaseFlags{Port: 53}, nil)
+	if status := TryGetScanStatus(err); status != SCAN_BLOCKLISTED {
+		t.Errorf("expected %s for UDP, got %s (%v)", SCAN_BLOCKLISTED, status, err)
+	}
+}
@@ -0,0 +1,230 @@
+package zgrab2
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// checkpointState is the content of the --checkpoint-file.
+//
+// Targets are numbered in the order they are read from the input, so resuming
+// requires the same input, in the same order.
+type checkpointState struct {
+	// Offset is the number of targets read from the input.
+	Offset uint64 `json:"offset"`
+
+	// InFlight lists the targets below Offset whose results were not written
+	// to the output yet.
+	InFlight []uint64 `json:"in_flight,omitempty"`
+
+	// Output gives the size of each output file at the time of the
+	// checkpoint; anything written after that is discarded when resuming.
+	Output map[string]int64 `json:"output"`
+}
+
+// checkpointSink is a Sink that can report how much of its output is
+// complete.
+type checkpointSink interface {
+	Sink
+
+	// Checkpoint writes out all the records received so far, and returns the
+	// size of each output file.
+	Checkpoint() (map[string]int64, error)
+}
+
+// checkpointRecord is an encoded Grab, along with the number of its target
+// and the number of grabs output for it (ConnectionsPerHost if 0).
+type checkpointRecord struct {
+	seq     uint64
+	outputs int
+	data    []byte
+}
+
+// checkpoint keeps track of the targets that have been read from the input
+// and written to the output, and periodically saves them to a file, along
+// with the state of the output.
+type checkpoint struct {
+	path     string
+	interval time.Duration
+	sink     checkpointSink
+
+	// previous is the state that the current run resumes from.
+	previous        checkpointState
+	previousPending map[uint64]bool
+
+	// partial holds the results of the targets scanned several times (with
+	// --connections-per-host) until all of them are available, so that a
+	// target is written out entirely between two checkpoints.
+	partial map[uint64][][]byte
+
+	mu   sync.Mutex
+	next uint64
+	// pending holds the targets read from the input whose results are not
+	// saved yet; it maps them to true once the results have been written.
+	pending map[uint64]bool
+}
+
+// newCheckpoint returns a checkpoint saving to path every interval. If resume
+// is set, the state saved in path is loaded, and the targets completed in that
+// run are skipped. A missing file is not an error, since the previous run may
+// have been stopped before its first checkpoint.
+func newCheckpoint(path string, interval time.Duration, resume bool) (*checkpoint, error) {
+	cp := &checkpoint{
+		path:            path,
+		interval:        interval,
+		previousPending: make(map[uint64]bool),
+		partial:         make(map[uint64][][]byte),
+		pending:         make(map[uint64]bool),
+	}
+	if !resume {
+		return cp, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		cp.previous.Output = make(map[string]int64)
+		return cp, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cp.previous); err != nil {
+		return nil, err
+	}
+	if cp.previous.Output == nil {
+		cp.previous.Output = make(map[string]int64)
+	}
+	for _, seq := range cp.previous.InFlight {
+		cp.previousPending[seq] = true
+	}
+	return cp, nil
+}
+
+// done returns true if the target seq was completed by the previous run.
+func (cp *checkpoint) done(seq uint64) bool {
+	return seq < cp.previous.Offset && !cp.previousPending[seq]
+}
+
+// input wraps an InputTargetsFunc to number the targets it generates, and to
+// skip those that were completed by the previous run.
+func (cp *checkpoint) input(inner InputTargetsFunc) InputTargetsFunc {
+	return func(ch chan<- ScanTarget) error {
+		targets := make(chan ScanTarget)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- inner(targets)
+			close(targets)
+		}()
+		for target := range targets {
+			cp.mu.Lock()
+			seq := cp.next
+			cp.next++
+			skip := cp.done(seq)
+			if !skip {
+				cp.pending[seq] = false
+			}
+			cp.mu.Unlock()
+			if skip {
+				continue
+			}
+			target.seq = seq
+			ch <- target
+		}
+		return <-errc
+	}
+}
+
+// output writes the records to the sink, and saves the checkpoint every
+// interval and once all records have been written.
+func (cp *checkpoint) output(records <-chan checkpointRecord) error {
+	ticker := time.NewTicker(cp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case record, ok := <-records:
+			if !ok {
+				if err := cp.save(); err != nil {
+					cp.sink.Close()
+					return err
+				}
+				return cp.sink.Close()
+			}
+			results := append(cp.partial[record.seq], record.data)
+			outputs := record.outputs
+			if outputs == 0 {
+				outputs = config.ConnectionsPerHost
+			}
+			if len(results) < outputs {
+				cp.partial[record.seq] = results
+				continue
+			}
+			delete(cp.partial, record.seq)
+			for _, data := range results {
+				if err := cp.sink.WriteRecord(data); err != nil {
+					cp.sink.Close()
+					return err
+				}
+			}
+			cp.mu.Lock()
+			cp.pending[record.seq] = true
+			cp.mu.Unlock()
+		case <-ticker.C:
+			if err := cp.save(); err != nil {
+				log.Errorf("could not save checkpoint: %s", err)
+			}
+		}
+	}
+}
+
+// save writes out the sink and atomically replaces the checkpoint file. It
+// must be called from the goroutine writing to the sink.
+func (cp *checkpoint) save() error {
+	current, err := cp.sink.Checkpoint()
+	if err != nil {
+		return err
+	}
+	// Keep the files of the previous run that were not written to since, such
+	// as those of scanners without new results.
+	output := make(map[string]int64, len(cp.previous.Output)+len(current))
+	for name, size := range cp.previous.Output {
+		output[name] = size
+	}
+	for name, size := range current {
+		output[name] = size
+	}
+	cp.mu.Lock()
+	state := checkpointState{Offset: cp.next, Output: output}
+	for seq, written := range cp.pending {
+		if written {
+			delete(cp.pending, seq)
+		} else {
+			state.InFlight = append(state.InFlight, seq)
+		}
+	}
+	// The targets of the previous run that have not been read again yet are
+	// in the same state as before.
+	if cp.previous.Offset > state.Offset {
+		for seq := range cp.previousPending {
+			if seq >= state.Offset {
+				state.InFlight = append(state.InFlight, seq)
+			}
+		}
+		state.Offset = cp.previous.Offset
+	}
+	cp.mu.Unlock()
+	sort.Slice(state.InFlight, func(i, j int) bool { return state.InFlight[i] < state.InFlight[j] })
+
+	data, err := json.Marshal(&state)
+	if err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
@@ -0,0 +1,151 @@
+package zgrab2
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// runCheckpointed reads the targets from a checkpointed input of n targets,
+// writes the results of those for which write returns true, and returns the
+// numbers of the targets read.
+func runCheckpointed(t *testing.T, cp *checkpoint, n int, write func(seq uint64) bool) []uint64 {
+	input := cp.input(func(ch chan<- ScanTarget) error {
+		for i := 0; i < n; i++ {
+			ch <- ScanTarget{Domain: fmt.Sprintf("%d.example.com", i)}
+		}
+		return nil
+	})
+	targets := make(chan ScanTarget)
+	go func() {
+		if err := input(targets); err != nil {
+			t.Error(err)
+		}
+		close(targets)
+	}()
+	records := make(chan checkpointRecord)
+	done := make(chan error)
+	go func() {
+		done <- cp.output(records)
+	}()
+	var read []uint64
+	for target := range targets {
+		read = append(read, target.seq)
+		if write(target.seq) {
+			records <- checkpointRecord{seq: target.seq, data: []byte(target.Domain)}
+		}
+	}
+	close(records)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return read
+}
+
+func TestCheckpointResume(t *testing.T) {
+	config.ConnectionsPerHost = 1
+	for _, compression := range []string{CompressionNone, CompressionGzip} {
+		t.Run(compression, func(t *testing.T) {
+			dir := t.TempDir()
+			cpFile := filepath.Join(dir, "checkpoint.json")
+			output := filepath.Join(dir, "out.json")
+			opts := SinkOptions{Compression: compression}
+
+			// The first run reads all targets, but only completes some of them
+			// before the final checkpoint.
+			cp, err := newCheckpoint(cpFile, time.Hour, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sink, err := NewSink(output, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cp.sink = sink.(checkpointSink)
+			runCheckpointed(t, cp, 10, func(seq uint64) bool {
+				return seq < 5 && seq != 2
+			})
+			name := sinkFileName(output, "", 0, &opts)
+
+			// Simulate results written after the last checkpoint.
+			f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString("garbage\n")
+			f.Close()
+
+			cp, err = newCheckpoint(cpFile, time.Hour, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cp.previous.InFlight, []uint64{2, 5, 6, 7, 8, 9}) {
+				t.Errorf("wrong in-flight targets: %v", cp.previous.InFlight)
+			}
+			opts.Resume = cp.previous.Output
+			if sink, err = NewSink(output, opts); err != nil {
+				t.Fatal(err)
+			}
+			cp.sink = sink.(checkpointSink)
+			read := runCheckpointed(t, cp, 10, func(uint64) bool { return true })
+			if !reflect.DeepEqual(read, []uint64{2, 5, 6, 7, 8, 9}) {
+				t.Errorf("wrong targets scanned on resume: %v", read)
+			}
+
+			var expected []string
+			for _, i := range []int{0, 1, 3, 4, 2, 5, 6, 7, 8, 9} {
+				expected = append(expected, fmt.Sprintf("%d.example.com", i))
+			}
+			if got := readLines(t, name); !reflect.DeepEqual(got, expected) {
+				t.Errorf("wrong output after resume: %v", got)
+			}
+
+			// Everything is done: resuming again scans nothing.
+			if cp, err = newCheckpoint(cpFile, time.Hour, true); err != nil {
+				t.Fatal(err)
+			}
+			if cp.previous.Offset != 10 || len(cp.previous.InFlight) != 0 {
+				t.Errorf("wrong final checkpoint: %+v", cp.previous)
+			}
+		})
+	}
+}
+
+func TestCheckpointResumeRotated(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.json")
+	opts := SinkOptions{RotateSize: 1}
+	sink, err := NewSink(output, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []string{"a", "b", "c"} {
+		if err := sink.WriteRecord([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, err := sink.(checkpointSink).Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// d goes to a file started after the checkpoint
+	if err := sink.WriteRecord([]byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	opts.Resume = state
+	if sink, err = NewSink(output, opts); err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, sink, "e")
+	if files := listDir(t, dir); !reflect.DeepEqual(files, []string{"out.00000.json", "out.00001.json", "out.00002.json", "out.00003.json"}) {
+		t.Errorf("wrong files: %v", files)
+	}
+	if got := readLines(t, filepath.Join(dir, "out.00003.json")); !reflect.DeepEqual(got, []string{"e"}) {
+		t.Errorf("wrong records after resume: %v", got)
+	}
+}
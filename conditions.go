@@ -0,0 +1,92 @@
+package zgrab2
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ScanCondition makes a scanner depend on the outcome of an earlier scanner
+// on the same target.
+type ScanCondition struct {
+	// Scanner is the name of the earlier scanner.
+	Scanner string
+
+	// Match, if set, must match the banner of the earlier scanner's result:
+	// the data returned by its ServiceBanner, or else its JSON encoding.
+	// Otherwise, the earlier scanner must have succeeded.
+	Match *regexp.Regexp
+}
+
+// Holds returns true if the condition is met by the responses of the
+// scanners that already ran on the target.
+func (c ScanCondition) Holds(responses map[string]ScanResponse) bool {
+	res, ok := responses[c.Scanner]
+	if !ok {
+		return false
+	}
+	if c.Match == nil {
+		return res.Status == SCAN_SUCCESS
+	}
+	if res.Result == nil {
+		return false
+	}
+	if banner, ok := res.Result.(ServiceBanner); ok {
+		data, _ := banner.ServiceBanner()
+		return c.Match.Match(data)
+	}
+	data, err := json.Marshal(res.Result)
+	return err == nil && c.Match.Match(data)
+}
+
+// GetConditions parses --if-success and --if-match.
+func (b *BaseFlags) GetConditions() ([]ScanCondition, error) {
+	var conditions []ScanCondition
+	for _, name := range b.IfSuccess {
+		conditions = append(conditions, ScanCondition{Scanner: name})
+	}
+	for _, arg := range b.IfMatch {
+		i := strings.IndexByte(arg, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid --if-match %q: expected NAME:REGEXP", arg)
+		}
+		re, err := regexp.Compile(arg[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid --if-match %q: %s", arg, err)
+		}
+		conditions = append(conditions, ScanCondition{Scanner: arg[:i], Match: re})
+	}
+	return conditions, nil
+}
+
+// ConditionsFromFlags returns the conditions set in the flags of a module,
+// if they embed BaseFlags.
+func ConditionsFromFlags(flags ScanFlags) ([]ScanCondition, error) {
+	if f, ok := flags.(interface {
+		GetConditions() ([]ScanCondition, error)
+	}); ok {
+		return f.GetConditions()
+	}
+	return nil, nil
+}
+
+// scanConditions holds the conditions of the registered scanners.
+var scanConditions = make(map[string][]ScanCondition)
+
+// SetScanConditions sets the conditions under which the registered scanner
+// runs: all of them must hold.
+func SetScanConditions(name string, conditions []ScanCondition) {
+	if len(conditions) == 0 {
+		delete(scanConditions, name)
+	} else {
+		scanConditions[name] = conditions
+	}
+}
+
+// Response returns the response of a scanner that already ran on the target,
+// when called during a scan.
+func (target *ScanTarget) Response(scanner string) (ScanResponse, bool) {
+	res, ok := target.responses[scanner]
+	return res, ok
+}
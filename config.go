@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
@@ -15,6 +16,14 @@ import (
 type Config struct {
 	OutputFileName     string          `short:"o" long:"output-file" default:"-" description:"Output filename, use - for stdout"`
 	InputFileName      string          `short:"f" long:"input-file" default:"-" description:"Input filename, use - for stdin"`
+	OutputRotateSize   int64           `long:"output-rotate-size" default:"0" description:"Start a new output file after this many megabytes (after compression), 0 to disable"`
+	OutputRotateEvery  time.Duration   `long:"output-rotate-interval" default:"0" description:"Start a new output file after this much time (e.g. 1h), 0 to disable"`
+	OutputCompression  string          `long:"output-compression" default:"none" choice:"none" choice:"gzip" choice:"zstd" description:"Compress the output files"`
+	OutputSplit        bool            `long:"output-split" description:"Write the results of each scanner to its own file, named after --output-file and the scanner name"`
+	CheckpointFile     string          `long:"checkpoint-file" description:"Periodically record the progress of the scan in this file, so that it can be resumed with --resume"`
+	CheckpointInterval time.Duration   `long:"checkpoint-interval" default:"30s" description:"Time between two checkpoints"`
+	Resume             bool            `long:"resume" description:"Resume the scan recorded in --checkpoint-file: skip the targets already done and append to the output"`
+	InputFormat        string          `long:"input-format" default:"csv" choice:"csv" choice:"jsonl" description:"Format of the input file: csv (IP, DOMAIN, TAG, PORT) or jsonl (one JSON object per line, allowing per-target options)"`
 	MetaFileName       string          `short:"m" long:"metadata-file" default:"-" description:"Metadata filename, use - for stderr"`
 	LogFileName        string          `short:"l" long:"log-file" default:"-" description:"Log filename, use - for stderr"`
 	NmapServiceProbes  string          `long:"nmap-service-probes" description:"Path to nmap-service-probes file. If empty, version scanning won't be used."`
@@ -25,15 +34,35 @@ type Config struct {
 	GOMAXPROCS         int             `long:"gomaxprocs" default:"0" description:"Set GOMAXPROCS"`
 	ConnectionsPerHost int             `long:"connections-per-host" default:"1" description:"Number of times to connect to each host (results in more output)"`
 	ReadLimitPerHost   int             `long:"read-limit-per-host" default:"96" description:"Maximum total kilobytes to read for a single host (default 96kb)"`
+	BlocklistFile      string          `long:"blocklist-file" description:"File of IP addresses and CIDR blocks (in the ZMap format) that must not be scanned, checked again when connecting"`
+	AllowlistFile      string          `long:"allowlist-file" description:"File of IP addresses and CIDR blocks (in the ZMap format) outside of which nothing is scanned"`
+	Rate               float64         `long:"rate" default:"0" description:"Maximum number of connection attempts per second, 0 for no limit"`
+	SubnetRate         float64         `long:"subnet-rate" default:"0" description:"Maximum number of connection attempts per second to each /24 IPv4 or /48 IPv6 network, 0 for no limit"`
+	SourceIP           string          `long:"source-ip" description:"Local addresses to connect from, as a comma-separated list of IP addresses and CIDR blocks"`
+	SourceIPSelection  string          `long:"source-ip-selection" default:"round-robin" choice:"round-robin" choice:"hash" description:"How the source address of each connection is chosen: in turn, or by a hash of the destination to keep it stable"`
+	SourcePortRange    string          `long:"source-port-range" description:"Local ports to connect from, as FIRST-LAST, used in turn"`
+	DNSResolvers       string          `long:"dns-resolvers" description:"DNS servers resolving the domains of the targets, as a comma-separated list of IP addresses with optional ports, queried in turn (default: the system resolver)"`
+	DNSCacheTTL        time.Duration   `long:"dns-cache-ttl" default:"5m" description:"How long the addresses of a domain are cached, 0 to disable"`
+	PreferIPv6         bool            `long:"prefer-ipv6" description:"Scan the IPv6 address of the domains that have both IPv4 and IPv6 addresses"`
+	IPv4Only           bool            `long:"ipv4-only" description:"Ignore the IPv6 addresses of the domains"`
+	AllAddresses       bool            `long:"all-addresses" description:"Scan each address of a domain, rather than only the first one (results in more output)"`
+	Retries            int             `long:"retries" default:"0" description:"Number of times to retry a scan that failed with a connection timeout, I/O timeout or closed connection"`
+	RetryBackoff       time.Duration   `long:"retry-backoff" default:"1s" description:"Time to wait before the first retry, doubled for each following one"`
+	TargetTimeout      time.Duration   `long:"target-timeout" default:"0" description:"Maximum time spent on each target, over all scanners and connections (e.g. 30s), 0 for no limit"`
+	ShutdownTimeout    time.Duration   `long:"shutdown-timeout" default:"5s" description:"On SIGINT or SIGTERM, time to wait for the scans in progress before writing the output and exiting, 0 to wait for all of them"`
+	Transcript         bool            `long:"transcript" description:"Record the data read and written on each connection, output as a debug field of each response (see --debug)"`
+	TranscriptLimit    int             `long:"transcript-limit" default:"64" description:"Maximum kilobytes of data recorded in the transcript of each response"`
+	PcapFile           string          `long:"pcap-file" description:"Write the connections of each scan to this PCAP-NG file, as packets synthesized from the data sent and received"`
 	Prometheus         string          `long:"prometheus" description:"Address to use for Prometheus server (e.g. localhost:8080). If empty, Prometheus is disabled."`
 	Multiple           MultipleCommand `command:"multiple" description:"Multiple module actions"`
+	Replay             ReplayCommand   `command:"replay" description:"Replay the transcripts recorded by a previous scan"`
 	inputFile          *os.File
 	outputFile         *os.File
 	metaFile           *os.File
 	logFile            *os.File
 	inputTargets       InputTargetsFunc
 	outputResults      OutputResultsFunc
-	localAddr          *net.TCPAddr
+	checkpoint         *checkpoint
 }
 
 // SetInputFunc sets the target input function to the provided function.
@@ -64,7 +93,14 @@ func validateFrameworkConfiguration() {
 		}
 		log.SetOutput(config.logFile)
 	}
-	SetInputFunc(InputTargetsCSV)
+	switch {
+	case config.Replay.active:
+		SetInputFunc(InputReplayTargets)
+	case config.InputFormat == "jsonl":
+		SetInputFunc(InputTargetsJSONL)
+	default:
+		SetInputFunc(InputTargetsCSV)
+	}
 
 	if config.InputFileName == "-" {
 		config.inputFile = os.Stdin
@@ -75,16 +111,57 @@ func validateFrameworkConfiguration() {
 		}
 	}
 
-	if config.OutputFileName == "-" {
-		config.outputFile = os.Stdout
+	if config.OutputRotateSize < 0 || config.OutputRotateEvery < 0 {
+		log.Fatal("output rotation thresholds must not be negative")
+	}
+	sinkOptions := SinkOptions{
+		RotateSize:     config.OutputRotateSize * 1024 * 1024,
+		RotateInterval: config.OutputRotateEvery,
+		Compression:    config.OutputCompression,
+		SplitByModule:  config.OutputSplit,
+	}
+	if config.Resume && config.CheckpointFile == "" {
+		log.Fatal("--resume requires --checkpoint-file")
+	}
+	if config.CheckpointFile != "" {
+		// validate/load the checkpoint, and resume the output from it
+		if config.OutputFileName == "-" {
+			log.Fatal("--checkpoint-file requires --output-file")
+		}
+		if config.CheckpointInterval <= 0 {
+			log.Fatalf("invalid --checkpoint-interval (must be positive, given %s)", config.CheckpointInterval)
+		}
+		cp, err := newCheckpoint(config.CheckpointFile, config.CheckpointInterval, config.Resume)
+		if err != nil {
+			log.Fatalf("could not load checkpoint from %s: %s", config.CheckpointFile, err)
+		}
+		if config.Resume {
+			sinkOptions.Resume = cp.previous.Output
+		}
+		sink, err := NewSink(config.OutputFileName, sinkOptions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cp.sink = sink.(checkpointSink)
+		config.checkpoint = cp
+		SetInputFunc(cp.input(config.inputTargets))
+	} else if !sinkOptions.rotating() && !sinkOptions.SplitByModule && sinkOptions.Compression == CompressionNone {
+		if config.OutputFileName == "-" {
+			config.outputFile = os.Stdout
+		} else {
+			var err error
+			if config.outputFile, err = os.Create(config.OutputFileName); err != nil {
+				log.Fatal(err)
+			}
+		}
+		SetOutputFunc(OutputResultsWriterFunc(config.outputFile))
 	} else {
-		var err error
-		if config.outputFile, err = os.Create(config.OutputFileName); err != nil {
+		sink, err := NewSink(config.OutputFileName, sinkOptions)
+		if err != nil {
 			log.Fatal(err)
 		}
+		SetOutputFunc(OutputResultsSinkFunc(sink))
 	}
-	outputFunc := OutputResultsWriterFunc(config.outputFile)
-	SetOutputFunc(outputFunc)
 
 	if config.MetaFileName == "-" {
 		config.metaFile = os.Stderr
@@ -104,7 +181,7 @@ func validateFrameworkConfiguration() {
 	//validate/start prometheus
 	if config.Prometheus != "" {
 		go func() {
-			http.Handle("metrics", promhttp.Handler())
+			http.Handle("/metrics", promhttp.Handler())
 			if err := http.ListenAndServe(config.Prometheus, nil); err != nil {
 				log.Fatalf("could not run prometheus server: %s", err.Error())
 			}
@@ -116,6 +193,91 @@ func validateFrameworkConfiguration() {
 		log.Fatalf("need at least one sender, given %d", config.Senders)
 	}
 
+	// validate matchers and load the version scanning database
+	if config.NmapServiceProbes != "" && config.NmapMatchers <= 0 {
+		log.Fatalf("need at least one matcher, given %d", config.NmapMatchers)
+	}
+	loadServiceProbes()
+
+	// load the address allowlist and blocklist
+	loadAddressFilter()
+
+	// validate rate limits
+	if config.Rate < 0 || config.SubnetRate < 0 {
+		log.Fatal("rate limits must not be negative")
+	}
+	if config.Rate > 0 || config.SubnetRate > 0 {
+		connectLimit = newConnectLimiter(config.Rate, config.SubnetRate)
+	}
+
+	// load the source addresses and ports
+	var sourceIPs []net.IP
+	var minPort, maxPort uint16
+	if config.SourceIP != "" {
+		var err error
+		if sourceIPs, err = ParseSourceIPs(config.SourceIP); err != nil {
+			log.Fatalf("invalid --source-ip: %s", err)
+		}
+	}
+	if config.SourcePortRange != "" {
+		var err error
+		if minPort, maxPort, err = ParsePortRange(config.SourcePortRange); err != nil {
+			log.Fatalf("invalid --source-port-range: %s", err)
+		}
+	}
+	sourceAddresses = newSourcePool(sourceIPs, config.SourceIPSelection == "hash", minPort, maxPort)
+
+	// set up the resolution of the domains
+	resolverConfig := ResolverConfig{
+		CacheTTL:     config.DNSCacheTTL,
+		PreferIPv6:   config.PreferIPv6,
+		IPv4Only:     config.IPv4Only,
+		AllAddresses: config.AllAddresses,
+	}
+	if config.DNSResolvers != "" {
+		var err error
+		if resolverConfig.Servers, err = ParseResolvers(config.DNSResolvers); err != nil {
+			log.Fatalf("invalid --dns-resolvers: %s", err)
+		}
+	}
+	if config.PreferIPv6 && config.IPv4Only {
+		log.Fatal("--prefer-ipv6 and --ipv4-only cannot be used together")
+	}
+	if config.DNSCacheTTL < 0 {
+		log.Fatalf("invalid --dns-cache-ttl (must not be negative, given %s)", config.DNSCacheTTL)
+	}
+	var err error
+	if dnsResolver, err = NewResolver(resolverConfig); err != nil {
+		log.Fatal(err)
+	}
+
+	// validate the retry policy
+	if config.Retries < 0 {
+		log.Fatalf("invalid --retries (must not be negative, given %d)", config.Retries)
+	}
+	if config.RetryBackoff < 0 {
+		log.Fatalf("invalid --retry-backoff (must not be negative, given %s)", config.RetryBackoff)
+	}
+
+	if config.TargetTimeout < 0 {
+		log.Fatalf("invalid --target-timeout (must not be negative, given %s)", config.TargetTimeout)
+	}
+	if config.TranscriptLimit <= 0 {
+		log.Fatalf("invalid --transcript-limit (must be positive, given %d)", config.TranscriptLimit)
+	}
+	if config.PcapFile != "" {
+		f, err := os.Create(config.PcapFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if pcapOutput, err = NewPcapWriter(f); err != nil {
+			log.Fatalf("could not write to %s: %s", config.PcapFile, err)
+		}
+	}
+	if config.ShutdownTimeout < 0 {
+		log.Fatalf("invalid --shutdown-timeout (must not be negative, given %s)", config.ShutdownTimeout)
+	}
+
 	// validate connections per host
 	if config.ConnectionsPerHost <= 0 {
 		log.Fatalf("need at least one connection, given %d", config.ConnectionsPerHost)
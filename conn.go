@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -60,6 +61,14 @@ type TimeoutConnection struct {
 	explicitReadDeadline    bool
 	explicitWriteDeadline   bool
 	explicitDeadline        bool
+
+	// stats, if set, receives BytesRead and BytesWritten on Close.
+	stats atomic.Pointer[scanStats]
+
+	// transcript, if set, records the reads and writes, as the connection
+	// transcriptID.
+	transcript   *transcriptRecorder
+	transcriptID int
 }
 
 // TimeoutConnection.Read calls Read() on the underlying connection, using any configured deadlines
@@ -81,6 +90,9 @@ func (c *TimeoutConnection) Read(b []byte) (n int, err error) {
 	}
 	n, err = c.Conn.Read(b)
 	c.BytesRead += n
+	if c.transcript != nil {
+		c.transcript.record(c.transcriptID, "read", b[:n], err)
+	}
 	if err == nil && origSize != len(b) && n == len(b) {
 		// we had to shrink the output buffer AND we used up the whole shrunk size, AND we're not at EOF
 		switch c.ReadLimitExceededAction {
@@ -113,6 +125,9 @@ func (c *TimeoutConnection) Write(b []byte) (n int, err error) {
 	}
 	n, err = c.Conn.Write(b)
 	c.BytesWritten += n
+	if c.transcript != nil {
+		c.transcript.record(c.transcriptID, "write", b[:n], err)
+	}
 	return n, err
 }
 
@@ -173,6 +188,12 @@ func GetTimeoutDialFunc(timeout time.Duration) func(string, string) (net.Conn, e
 
 // Close the underlying connection.
 func (c *TimeoutConnection) Close() error {
+	if stats := c.stats.Swap(nil); stats != nil {
+		stats.add(c.BytesRead, c.BytesWritten)
+	}
+	if c.Cancel != nil {
+		c.Cancel()
+	}
 	return c.Conn.Close()
 }
 
@@ -228,26 +249,58 @@ func NewTimeoutConnection(ctx context.Context, conn net.Conn, timeout, readTimeo
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	if ret.transcript = contextTranscript(ctx); ret.transcript != nil {
+		ret.transcriptID = ret.transcript.open(conn)
+	}
+	interruptible := ctx.Done() != nil
 	ret.ctx, ret.Cancel = context.WithTimeout(ctx, timeout)
+	if interruptible {
+		// Unblock pending operations when the parent context is cancelled or
+		// reaches its deadline, rather than at their next call.
+		go func() {
+			<-ret.ctx.Done()
+			if ctx.Err() != nil {
+				conn.SetDeadline(time.Now())
+			}
+		}()
+	}
 	return ret
 }
 
 // DialTimeoutConnectionEx dials the target and returns a net.Conn that uses the configured timeouts for Read/Write operations.
 func DialTimeoutConnectionEx(proto string, target string, dialTimeout, sessionTimeout, readTimeout, writeTimeout time.Duration, bytesReadLimit int) (net.Conn, error) {
-	var conn net.Conn
-	var err error
+	return dialTimeoutConnectionContext(context.Background(), proto, target, dialTimeout, sessionTimeout, readTimeout, writeTimeout, bytesReadLimit)
+}
+
+// dialTimeoutConnectionContext is DialTimeoutConnectionEx, giving up on the
+// connection (and later operations on it) when ctx is done.
+func dialTimeoutConnectionContext(ctx context.Context, proto string, target string, dialTimeout, sessionTimeout, readTimeout, writeTimeout time.Duration, bytesReadLimit int) (net.Conn, error) {
+	if r := contextReplay(ctx); r != nil {
+		conn, err := r.dial(proto)
+		if err != nil {
+			return nil, err
+		}
+		return NewTimeoutConnection(ctx, conn, sessionTimeout, readTimeout, writeTimeout, bytesReadLimit), nil
+	}
+	if err := waitToConnect(ctx, target); err != nil {
+		return nil, err
+	}
+	policy := contextDialPolicy(ctx)
+	dialer := net.Dialer{Timeout: sessionTimeout, Control: dialControl(ctx), Resolver: policy.resolver.netResolver()}
 	if dialTimeout > 0 {
-		conn, err = net.DialTimeout(proto, target, dialTimeout)
-	} else {
-		conn, err = net.DialTimeout(proto, target, sessionTimeout)
+		dialer.Timeout = dialTimeout
 	}
+	conn, err := policy.source.dial(proto, target, func(local net.Addr) (net.Conn, error) {
+		dialer.LocalAddr = local
+		return dialer.DialContext(ctx, proto, target)
+	})
 	if err != nil {
 		if conn != nil {
 			conn.Close()
 		}
 		return nil, err
 	}
-	return NewTimeoutConnection(context.Background(), conn, sessionTimeout, readTimeout, writeTimeout, bytesReadLimit), nil
+	return NewTimeoutConnection(ctx, conn, sessionTimeout, readTimeout, writeTimeout, bytesReadLimit), nil
 }
 
 // DialTimeoutConnection dials the target and returns a net.Conn that uses the configured single timeout for all operations.
@@ -299,12 +352,31 @@ func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.
 	d.Dialer.Timeout = d.getTimeout(d.ConnectTimeout)
 	d.Dialer.KeepAlive = d.Timeout
 
-	// Copy over the source IP if set, or nil
-	d.Dialer.LocalAddr = config.localAddr
+	// Refuse to connect to excluded addresses, once resolved
+	d.Dialer.Control = dialControl(ctx)
 
-	dialContext, cancelDial := context.WithTimeout(ctx, d.Dialer.Timeout)
-	defer cancelDial()
-	conn, err := d.Dialer.DialContext(dialContext, network, address)
+	var conn net.Conn
+	var err error
+	if r := contextReplay(ctx); r != nil {
+		conn, err = r.dial(network)
+	} else {
+		if err := waitToConnect(ctx, address); err != nil {
+			return nil, err
+		}
+		dialContext, cancelDial := context.WithTimeout(ctx, d.Dialer.Timeout)
+		defer cancelDial()
+		// Connect from the configured source addresses, if any, resolving
+		// with the configured DNS servers unless a resolver is set
+		policy := contextDialPolicy(ctx)
+		conn, err = policy.source.dial(network, address, func(local net.Addr) (net.Conn, error) {
+			dialer := *d.Dialer
+			dialer.LocalAddr = local
+			if dialer.Resolver == nil {
+				dialer.Resolver = policy.resolver.netResolver()
+			}
+			return dialer.DialContext(dialContext, network, address)
+		})
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,608 @@
+package zgrab2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EngineConfig holds the options of an Engine. The zero value scans one
+// target at a time, once, with no limits.
+type EngineConfig struct {
+	// Senders is the number of targets scanned concurrently (default 1).
+	Senders int
+
+	// ConnectionsPerHost is the number of times each target is scanned
+	// (default 1).
+	ConnectionsPerHost int
+
+	// TargetTimeout, if positive, bounds the time spent on each target, over
+	// all the scanners and connections.
+	TargetTimeout time.Duration
+
+	// ShutdownTimeout is how long Run waits for the scans in progress once
+	// its context is cancelled, before abandoning them; 0 for no limit.
+	ShutdownTimeout time.Duration
+
+	// Retries is the number of times a scan failing with a connection
+	// timeout, I/O timeout or closed connection is retried, waiting
+	// RetryBackoff before the first retry and doubling it each time.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// ContinueOnError runs the next scanners on a target after one fails,
+	// and BreakOnSuccess skips them after one succeeds.
+	ContinueOnError bool
+	BreakOnSuccess  bool
+
+	// TranscriptLimit, if positive, adds to each ScanResponse the Transcript
+	// of its connections, up to TranscriptLimit bytes of data.
+	TranscriptLimit int
+
+	// Pcap, if set, receives packets synthesized from the connections of
+	// each scan.
+	Pcap *PcapWriter
+
+	// ScannersPerTarget is the number of scanners run concurrently on each
+	// target (default 1, in order). A scanner still waits for those named in
+	// its conditions; the results of the scanners after one that stops the
+	// target, on error or on success, are dropped, and these scans are not
+	// reported to the Monitor, the metrics or Pcap.
+	ScannersPerTarget int
+
+	// Allowlist and Blocklist, if set, restrict the targets and the
+	// addresses the scanners connect to.
+	Allowlist *AddressSet
+	Blocklist *AddressSet
+
+	// Rate and SubnetRate, if positive, limit the connection attempts per
+	// second, overall and to each /24 IPv4 or /48 IPv6 network.
+	Rate       float64
+	SubnetRate float64
+
+	// SourceIPs, if set, are the local addresses the connections are made
+	// from: one of the family of the destination, in turn, or chosen by a
+	// hash of the destination if HashSourceIP is set.
+	SourceIPs    []net.IP
+	HashSourceIP bool
+
+	// SourcePortMin and SourcePortMax, if set, bound the local ports of the
+	// connections, used in turn.
+	SourcePortMin uint16
+	SourcePortMax uint16
+
+	// Resolver, if set, resolves the domains of the targets without an IP
+	// address, and of the connections of the scanners. Otherwise, the
+	// scanners connect to the domains with the system resolver.
+	Resolver *Resolver
+
+	// Conditions, by scanner name, restrict the scanners to the targets on
+	// which earlier scanners had some outcome.
+	Conditions map[string][]ScanCondition
+
+	// Monitor, if set, counts the successes and failures of each scanner.
+	Monitor *Monitor
+}
+
+// TargetIterator returns the targets to scan, one per call, then io.EOF.
+type TargetIterator func() (ScanTarget, error)
+
+// IterateTargets returns a TargetIterator over targets.
+func IterateTargets(targets []ScanTarget) TargetIterator {
+	i := 0
+	return func() (ScanTarget, error) {
+		if i == len(targets) {
+			return ScanTarget{}, io.EOF
+		}
+		i++
+		return targets[i-1], nil
+	}
+}
+
+// IterateInput returns a TargetIterator over the targets sent by an
+// InputTargetsFunc, such as InputTargetsCSV, which is run in the background
+// on the first call.
+func IterateInput(input InputTargetsFunc) TargetIterator {
+	var once sync.Once
+	ch := make(chan ScanTarget)
+	var err error
+	return func() (ScanTarget, error) {
+		once.Do(func() {
+			go func() {
+				err = input(ch)
+				close(ch)
+			}()
+		})
+		if target, ok := <-ch; ok {
+			return target, nil
+		}
+		if err != nil {
+			return ScanTarget{}, err
+		}
+		return ScanTarget{}, io.EOF
+	}
+}
+
+// dialPolicy holds the limits and source addresses of the connections of a
+// scan. Engines carry theirs in the context of their scans; connections made
+// without one use the configured --allowlist-file, --blocklist-file, --rate,
+// --subnet-rate, --source-ip, --source-port-range and --dns-resolvers.
+type dialPolicy struct {
+	addresses *addressLists
+	limiter   *connectLimiter
+	source    *sourcePool
+	resolver  *Resolver
+}
+
+type dialPolicyKey struct{}
+
+// contextDialPolicy returns the dialPolicy of ctx, or the configured one.
+func contextDialPolicy(ctx context.Context) dialPolicy {
+	if ctx != nil {
+		if p, ok := ctx.Value(dialPolicyKey{}).(*dialPolicy); ok {
+			return *p
+		}
+	}
+	return dialPolicy{addresses: &addressFilter, limiter: connectLimit, source: sourceAddresses, resolver: dnsResolver}
+}
+
+// Engine runs a set of scanners on targets, independently of the command
+// line configuration, so that several engines can run in the same process.
+type Engine struct {
+	config   EngineConfig
+	scanners []Scanner
+	scan     scanOptions
+	policy   dialPolicy
+
+	// deps holds, for each scanner, the indexes of the scanners it waits for
+	// when run concurrently.
+	deps [][]int
+}
+
+// NewEngine returns an Engine running the given scanners on each target, in
+// order. The scanners must be initialized; the engine calls InitPerSender.
+func NewEngine(config EngineConfig, scanners ...Scanner) (*Engine, error) {
+	if len(scanners) == 0 {
+		return nil, errors.New("no scanners")
+	}
+	names := make(map[string]bool)
+	for _, s := range scanners {
+		if s == nil {
+			return nil, errors.New("nil scanner")
+		}
+		if names[s.GetName()] {
+			return nil, fmt.Errorf("name: %s already used", s.GetName())
+		}
+		for _, c := range config.Conditions[s.GetName()] {
+			if !names[c.Scanner] {
+				return nil, fmt.Errorf("%s depends on %s, which does not run before it", s.GetName(), c.Scanner)
+			}
+		}
+		names[s.GetName()] = true
+	}
+	for name := range config.Conditions {
+		if !names[name] {
+			return nil, fmt.Errorf("conditions set for unknown scanner %s", name)
+		}
+	}
+	if config.Senders < 0 || config.ConnectionsPerHost < 0 || config.ScannersPerTarget < 0 {
+		return nil, errors.New("senders, connections and scanners per target must not be negative")
+	}
+	if config.TargetTimeout < 0 || config.ShutdownTimeout < 0 || config.Retries < 0 || config.RetryBackoff < 0 {
+		return nil, errors.New("timeouts and retries must not be negative")
+	}
+	if config.Rate < 0 || config.SubnetRate < 0 {
+		return nil, errors.New("rate limits must not be negative")
+	}
+	if config.SourcePortMin > config.SourcePortMax || (config.SourcePortMin == 0) != (config.SourcePortMax == 0) {
+		return nil, errors.New("invalid source port range")
+	}
+	if config.TranscriptLimit < 0 {
+		return nil, errors.New("transcript limit must not be negative")
+	}
+	if config.Senders == 0 {
+		config.Senders = 1
+	}
+	if config.ConnectionsPerHost == 0 {
+		config.ConnectionsPerHost = 1
+	}
+	if config.ScannersPerTarget == 0 {
+		config.ScannersPerTarget = 1
+	}
+	e := &Engine{
+		config:   config,
+		scanners: scanners,
+		scan: scanOptions{
+			retry:           retryPolicy{retries: config.Retries, backoff: config.RetryBackoff},
+			transcriptLimit: config.TranscriptLimit,
+			pcap:            config.Pcap,
+		},
+		policy: dialPolicy{
+			addresses: &addressLists{allow: config.Allowlist, block: config.Blocklist},
+			source:    newSourcePool(config.SourceIPs, config.HashSourceIP, config.SourcePortMin, config.SourcePortMax),
+			resolver:  config.Resolver,
+		},
+	}
+	if config.Rate > 0 || config.SubnetRate > 0 {
+		e.policy.limiter = newConnectLimiter(config.Rate, config.SubnetRate)
+	}
+	if config.ScannersPerTarget > 1 {
+		e.deps = scannerDependencies(config, scanners)
+	}
+	return e, nil
+}
+
+// scannerDependencies returns, for each scanner, the indexes of the earlier
+// scanners named in its conditions. The scanners that stop the target, on
+// error or on success, are not dependencies: the later scanners start
+// without waiting for them, and their results are dropped after the fact.
+func scannerDependencies(config EngineConfig, scanners []Scanner) [][]int {
+	index := make(map[string]int, len(scanners))
+	deps := make([][]int, len(scanners))
+	for i, s := range scanners {
+		for _, c := range config.Conditions[s.GetName()] {
+			deps[i] = append(deps[i], index[c.Scanner])
+		}
+		index[s.GetName()] = i
+	}
+	return deps
+}
+
+// configEngine returns the Engine of the command line: the registered
+// scanners, with the framework options.
+func configEngine(mon *Monitor) (*Engine, error) {
+	list := make([]Scanner, 0, len(orderedScanners))
+	for _, name := range orderedScanners {
+		list = append(list, *scanners[name])
+	}
+	e, err := NewEngine(EngineConfig{
+		Senders:            config.Senders,
+		ConnectionsPerHost: config.ConnectionsPerHost,
+		TargetTimeout:      config.TargetTimeout,
+		ShutdownTimeout:    config.ShutdownTimeout,
+		Retries:            config.Retries,
+		RetryBackoff:       config.RetryBackoff,
+		ContinueOnError:    config.Multiple.ContinueOnError,
+		BreakOnSuccess:     config.Multiple.BreakOnSuccess,
+		ScannersPerTarget:  config.Multiple.ScannersPerTarget,
+		TranscriptLimit:    configTranscriptLimit(),
+		Pcap:               pcapOutput,
+		Resolver:           dnsResolver,
+		Conditions:         scanConditions,
+		Monitor:            mon,
+	}, list...)
+	if err != nil {
+		return nil, err
+	}
+	// share the limits loaded from the command line with the connections
+	// made outside of the engine
+	e.policy = dialPolicy{addresses: &addressFilter, limiter: connectLimit, source: sourceAddresses, resolver: dnsResolver}
+	return e, nil
+}
+
+// Run scans the targets until the iterator returns io.EOF, passing each
+// result to the results callback. The callback is called concurrently from
+// the sender goroutines, and never after Run returns; if it returns an error,
+// the scan stops.
+//
+// When ctx is cancelled, Run stops reading targets, waits up to
+// ShutdownTimeout for the scans in progress, cancels the context of those
+// still running, and returns ctx.Err(). Otherwise, it returns the first error
+// of the iterator, the callback or the scanners' InitPerSender, if any.
+func (e *Engine) Run(ctx context.Context, targets TargetIterator, results func(*Grab) error) error {
+	workers := e.config.Senders
+	processQueue := make(chan ScanTarget, workers*4)
+	defer addQueueLength(queueProcess, func() int { return len(processQueue) })()
+
+	// runCtx stops reading targets, on cancellation or error.
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	var runErr error
+	var failOnce sync.Once
+	fail := func(err error) {
+		failOnce.Do(func() {
+			runErr = err
+			stop()
+		})
+	}
+
+	// The scans in progress are cancelled once abandoned.
+	scanCtx, abandon := context.WithCancel(context.WithValue(context.Background(), dialPolicyKey{}, &e.policy))
+	defer abandon()
+
+	// Once Run returns, the results of abandoned scans are dropped.
+	var output struct {
+		sync.RWMutex
+		closed bool
+	}
+	deliver := func(result *Grab) {
+		output.RLock()
+		defer output.RUnlock()
+		if output.closed {
+			return
+		}
+		if err := results(result); err != nil {
+			fail(err)
+		}
+	}
+
+	var workerDone sync.WaitGroup
+	workerDone.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(i int) {
+			defer workerDone.Done()
+			for _, s := range e.scanners {
+				if err := s.InitPerSender(i); err != nil {
+					fail(fmt.Errorf("could not initialize scanner %s: %w", s.GetName(), err))
+					return
+				}
+			}
+			for input := range processQueue {
+				targets, err := resolveTargets(scanCtx, e.config.Resolver, input)
+				if err != nil {
+					result := BuildGrabFromInputResponse(&input, nil)
+					result.ResolveError = err.Error()
+					result.outputs = 1
+					deliver(result)
+					continue
+				}
+				for _, target := range targets {
+					for run := 0; run < e.config.ConnectionsPerHost; run++ {
+						workersBusy.Inc()
+						result := e.grab(scanCtx, target)
+						workersBusy.Dec()
+						result.outputs = len(targets) * e.config.ConnectionsPerHost
+						deliver(result)
+					}
+				}
+			}
+		}(i)
+	}
+
+	// Read the targets in the background, so that a blocked iterator can be
+	// abandoned when interrupted.
+	inputQueue := make(chan ScanTarget)
+	inputDone := make(chan error, 1)
+	go func() {
+		defer close(inputQueue)
+		for {
+			target, err := targets()
+			if err != nil {
+				if err != io.EOF {
+					inputDone <- err
+				}
+				return
+			}
+			select {
+			case inputQueue <- target:
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+feed:
+	for {
+		select {
+		case target, ok := <-inputQueue:
+			if !ok {
+				select {
+				case err := <-inputDone:
+					fail(err)
+				default:
+				}
+				break feed
+			}
+			select {
+			case processQueue <- target:
+			case <-runCtx.Done():
+				break feed
+			}
+		case <-runCtx.Done():
+			break feed
+		}
+	}
+	if ctx.Err() != nil {
+		log.Warn("interrupted: no longer reading input")
+	}
+	close(processQueue)
+
+	scansDone := make(chan struct{})
+	go func() {
+		workerDone.Wait()
+		close(scansDone)
+	}()
+	select {
+	case <-scansDone:
+	case <-runCtx.Done():
+		var timeout <-chan time.Time
+		if e.config.ShutdownTimeout > 0 {
+			timer := time.NewTimer(e.config.ShutdownTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-scansDone:
+		case <-timeout:
+			log.Warnf("abandoning the scans still in progress after %s", e.config.ShutdownTimeout)
+		}
+	}
+
+	output.Lock()
+	output.closed = true
+	output.Unlock()
+	abandon()
+	// errors of abandoned scanners come too late
+	failOnce.Do(func() {})
+	if runErr != nil {
+		return runErr
+	}
+	return ctx.Err()
+}
+
+// grab runs the scanners on a target.
+func (e *Engine) grab(ctx context.Context, input ScanTarget) *Grab {
+	if e.config.TargetTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.config.TargetTimeout)
+		defer cancel()
+	}
+	if e.config.ScannersPerTarget > 1 {
+		return e.grabConcurrently(ctx, input)
+	}
+	moduleResult := make(map[string]ScanResponse)
+	for _, scanner := range e.scanners {
+		if !e.runs(scanner, input, moduleResult) {
+			continue
+		}
+		defer func(name string) {
+			if e := recover(); e != nil {
+				log.Errorf("Panic on scanner %s when scanning target %s: %#v", name, input.String(), e)
+				// Bubble out original error (with original stack) in lieu of explicitly logging the stack / error
+				panic(e)
+			}
+		}(scanner.GetName())
+
+		target := input
+		target.responses = make(map[string]ScanResponse, len(moduleResult))
+		for name, res := range moduleResult {
+			target.responses[name] = res
+		}
+		res := runScanner(ctx, scanner, e.config.Monitor, target, e.scan)
+		moduleResult[scanner.GetName()] = res
+		if res.Error != nil && !e.config.ContinueOnError {
+			break
+		}
+		if res.Status == SCAN_SUCCESS && e.config.BreakOnSuccess {
+			break
+		}
+	}
+	return BuildGrabFromInputResponse(&input, moduleResult)
+}
+
+// scannerState is the progress of a scanner on a target.
+type scannerState int
+
+const (
+	scannerPending scannerState = iota
+	scannerRunning
+	scannerDone
+)
+
+// grabConcurrently runs up to ScannersPerTarget scanners on a target at once,
+// starting each in order as soon as the scanners it depends on are done.
+func (e *Engine) grabConcurrently(ctx context.Context, input ScanTarget) *Grab {
+	type finishedScan struct {
+		index  int
+		res    ScanResponse
+		report func()
+	}
+	state := make([]scannerState, len(e.scanners))
+	cancels := make([]context.CancelFunc, len(e.scanners))
+	// the scans are reported once it is known that their response is kept
+	reports := make([]func(), len(e.scanners))
+	finished := make(chan finishedScan)
+	moduleResult := make(map[string]ScanResponse)
+	// scanners after stopAfter are skipped, or cancelled and dropped if
+	// they already started, as if run in order
+	stopAfter := len(e.scanners)
+	inFlight := 0
+	for {
+		for i, scanner := range e.scanners {
+			if state[i] != scannerPending || inFlight == e.config.ScannersPerTarget {
+				continue
+			}
+			if !e.depsDone(i, state) {
+				continue
+			}
+			if i > stopAfter || !e.runs(scanner, input, moduleResult) {
+				state[i] = scannerDone
+				continue
+			}
+			target := input
+			target.responses = make(map[string]ScanResponse, len(moduleResult))
+			for name, res := range moduleResult {
+				target.responses[name] = res
+			}
+			state[i] = scannerRunning
+			inFlight++
+			scanCtx, cancel := context.WithCancel(ctx)
+			cancels[i] = cancel
+			go func(i int, scanner Scanner, target ScanTarget) {
+				defer func() {
+					if r := recover(); r != nil {
+						log.Errorf("Panic on scanner %s when scanning target %s: %#v", scanner.GetName(), target.String(), r)
+						panic(r)
+					}
+				}()
+				res, report := runScannerDeferred(scanCtx, scanner, e.config.Monitor, target, e.scan)
+				finished <- finishedScan{index: i, res: res, report: report}
+			}(i, scanner, target)
+		}
+		if inFlight == 0 {
+			break
+		}
+		d := <-finished
+		inFlight--
+		state[d.index] = scannerDone
+		cancels[d.index]()
+		if d.index > stopAfter {
+			continue
+		}
+		moduleResult[e.scanners[d.index].GetName()] = d.res
+		reports[d.index] = d.report
+		if (d.res.Error != nil && !e.config.ContinueOnError) || (d.res.Status == SCAN_SUCCESS && e.config.BreakOnSuccess) {
+			stopAfter = d.index
+			for j := stopAfter + 1; j < len(e.scanners); j++ {
+				if state[j] == scannerRunning {
+					cancels[j]()
+				}
+				delete(moduleResult, e.scanners[j].GetName())
+				reports[j] = nil
+			}
+		}
+	}
+	for _, report := range reports {
+		if report != nil {
+			report()
+		}
+	}
+	return BuildGrabFromInputResponse(&input, moduleResult)
+}
+
+// depsDone returns true if the scanners the i-th one depends on are done.
+func (e *Engine) depsDone(i int, state []scannerState) bool {
+	for _, j := range e.deps[i] {
+		if state[j] != scannerDone {
+			return false
+		}
+	}
+	return true
+}
+
+// runs returns true if the scanner runs on the target, given the responses of
+// the scanners that already ran on it. When replaying a scan, the scanners
+// that have a recorded response run.
+func (e *Engine) runs(scanner Scanner, target ScanTarget, responses map[string]ScanResponse) bool {
+	if target.replay != nil {
+		_, ok := target.replay[scanner.GetName()]
+		return ok
+	}
+	return target.Tag == scanner.GetTrigger() && e.conditionsHold(scanner, responses)
+}
+
+// conditionsHold returns true if the conditions of the scanner hold, given
+// the responses of the scanners that already ran on the target.
+func (e *Engine) conditionsHold(scanner Scanner, responses map[string]ScanResponse) bool {
+	for _, c := range e.config.Conditions[scanner.GetName()] {
+		if !c.Holds(responses) {
+			return false
+		}
+	}
+	return true
+}
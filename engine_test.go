@@ -0,0 +1,312 @@
+package zgrab2
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitScanner is a ContextScanner waiting for its context to be done.
+type waitScanner struct {
+	echoScanner
+}
+
+func (s *waitScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	panic("Scan called instead of ScanContext")
+}
+
+func (s *waitScanner) ScanContext(ctx context.Context, t ScanTarget) (ScanStatus, interface{}, error) {
+	<-ctx.Done()
+	return TryGetScanStatus(ctx.Err()), nil, ctx.Err()
+}
+
+// silentListener accepts connections and never replies.
+func silentListener(t *testing.T) uint {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	return uint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// collect returns a results callback appending to grabs.
+func collect(grabs *[]*Grab) func(*Grab) error {
+	var mu sync.Mutex
+	return func(g *Grab) error {
+		mu.Lock()
+		defer mu.Unlock()
+		*grabs = append(*grabs, g)
+		return nil
+	}
+}
+
+func TestEngineTargetTimeout(t *testing.T) {
+	port := silentListener(t)
+	for _, scanner := range []Scanner{&waitScanner{echoScanner{name: "wait-test"}}, &echoScanner{name: "echo-test"}} {
+		engine, err := NewEngine(EngineConfig{TargetTimeout: 100 * time.Millisecond}, scanner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var grabs []*Grab
+		start := time.Now()
+		targets := IterateTargets([]ScanTarget{{IP: net.ParseIP("127.0.0.1"), Port: &port}})
+		if err := engine.Run(context.Background(), targets, collect(&grabs)); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Errorf("%s: scan took %s", scanner.GetName(), d)
+		}
+		if len(grabs) != 1 {
+			t.Fatalf("%s: expected 1 result, got %d", scanner.GetName(), len(grabs))
+		}
+		if status := grabs[0].Data[scanner.GetName()].Status; status != SCAN_IO_TIMEOUT {
+			t.Errorf("%s: expected %s, got %s", scanner.GetName(), SCAN_IO_TIMEOUT, status)
+		}
+	}
+}
+
+func TestEnginesConcurrent(t *testing.T) {
+	port := silentListener(t)
+	blocklist, err := ParseAddressSet(strings.NewReader("127.0.0.0/8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first engine may connect, the second one may not
+	configs := []EngineConfig{
+		{Senders: 4, TargetTimeout: 50 * time.Millisecond},
+		{Senders: 4, TargetTimeout: 50 * time.Millisecond, Blocklist: blocklist},
+	}
+	expected := []ScanStatus{SCAN_IO_TIMEOUT, SCAN_BLOCKLISTED}
+	targets := make([]ScanTarget, 20)
+	for i := range targets {
+		// blocked at dial time, as the IP is only known once resolved
+		targets[i] = ScanTarget{Domain: "localhost", Port: &port}
+	}
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		engine, err := NewEngine(config, &echoScanner{name: "echo"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int, engine *Engine) {
+			defer wg.Done()
+			var grabs []*Grab
+			if err := engine.Run(context.Background(), IterateTargets(targets), collect(&grabs)); err != nil {
+				t.Error(err)
+			}
+			if len(grabs) != len(targets) {
+				t.Errorf("engine %d: expected %d results, got %d", i, len(targets), len(grabs))
+			}
+			for _, grab := range grabs {
+				if status := grab.Data["echo"].Status; status != expected[i] {
+					t.Errorf("engine %d: expected %s, got %s", i, expected[i], status)
+				}
+			}
+		}(i, engine)
+	}
+	wg.Wait()
+}
+
+func TestEngineErrors(t *testing.T) {
+	if _, err := NewEngine(EngineConfig{}); err == nil {
+		t.Error("expected an error without scanners")
+	}
+	if _, err := NewEngine(EngineConfig{}, &echoScanner{name: "a"}, &echoScanner{name: "a"}); err == nil {
+		t.Error("expected an error for duplicate scanner names")
+	}
+	engine, err := NewEngine(EngineConfig{}, &flakyScanner{echoScanner: echoScanner{name: "ok"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputErr := errors.New("bad input")
+	n := 0
+	targets := func() (ScanTarget, error) {
+		if n++; n > 3 {
+			return ScanTarget{}, inputErr
+		}
+		return ScanTarget{Domain: "example.com"}, nil
+	}
+	var grabs []*Grab
+	if err := engine.Run(context.Background(), targets, collect(&grabs)); err != inputErr {
+		t.Errorf("expected the input error, got %v", err)
+	}
+
+	outputErr := errors.New("bad output")
+	err = engine.Run(context.Background(), IterateInput(func(ch chan<- ScanTarget) error {
+		for {
+			ch <- ScanTarget{Domain: "example.com"}
+		}
+	}), func(*Grab) error { return outputErr })
+	if err != outputErr {
+		t.Errorf("expected the output error, got %v", err)
+	}
+}
+
+// fixedScanner returns a fixed status and result, and records the response
+// of the scanner named by peek, if any.
+type fixedScanner struct {
+	echoScanner
+	status ScanStatus
+	result interface{}
+	peek   string
+	peeked *ScanResponse
+}
+
+func (s *fixedScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	if s.peek != "" {
+		if res, ok := t.Response(s.peek); ok {
+			s.peeked = &res
+		}
+	}
+	if s.status != SCAN_SUCCESS {
+		return s.status, s.result, errors.New("failed")
+	}
+	return s.status, s.result, nil
+}
+
+func TestEngineConditions(t *testing.T) {
+	ssh := &fixedScanner{echoScanner: echoScanner{name: "ssh"}, status: SCAN_SUCCESS, result: map[string]string{"banner": "SSH-2.0-OpenSSH"}}
+	tls := &fixedScanner{echoScanner: echoScanner{name: "tls"}, status: SCAN_PROTOCOL_ERROR}
+	afterSSH := &fixedScanner{echoScanner: echoScanner{name: "after-ssh"}, status: SCAN_SUCCESS, peek: "ssh"}
+	afterTLS := &fixedScanner{echoScanner: echoScanner{name: "after-tls"}, status: SCAN_SUCCESS}
+	noMatch := &fixedScanner{echoScanner: echoScanner{name: "no-match"}, status: SCAN_SUCCESS}
+	conditions := map[string][]ScanCondition{
+		"after-ssh": {{Scanner: "ssh", Match: regexp.MustCompile(`SSH-2\.0`)}},
+		"after-tls": {{Scanner: "tls"}},
+		"no-match":  {{Scanner: "ssh"}, {Scanner: "ssh", Match: regexp.MustCompile(`^HTTP`)}},
+	}
+	engine, err := NewEngine(EngineConfig{ContinueOnError: true, Conditions: conditions}, ssh, tls, afterSSH, afterTLS, noMatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var grabs []*Grab
+	if err := engine.Run(context.Background(), IterateTargets([]ScanTarget{{Domain: "example.com"}}), collect(&grabs)); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{"ssh": true, "tls": true, "after-ssh": true, "after-tls": false, "no-match": false} {
+		if _, ok := grabs[0].Data[name]; ok != expected {
+			t.Errorf("%s: expected to run = %v", name, expected)
+		}
+	}
+	if afterSSH.peeked == nil || afterSSH.peeked.Status != SCAN_SUCCESS {
+		t.Errorf("expected after-ssh to see the ssh response, got %v", afterSSH.peeked)
+	}
+
+	if _, err := NewEngine(EngineConfig{Conditions: conditions}, afterSSH, ssh); err == nil {
+		t.Error("expected an error for a condition on a later scanner")
+	}
+}
+
+func TestGetConditions(t *testing.T) {
+	flags := BaseFlags{IfSuccess: []string{"tls"}, IfMatch: []string{"banner:^SSH-[0-9.]+:"}}
+	conditions, err := flags.GetConditions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conditions) != 2 || conditions[0].Scanner != "tls" || conditions[0].Match != nil ||
+		conditions[1].Scanner != "banner" || conditions[1].Match.String() != "^SSH-[0-9.]+:" {
+		t.Errorf("unexpected conditions %+v", conditions)
+	}
+	for _, arg := range []string{"banner", ":SSH", "banner:("} {
+		flags := BaseFlags{IfMatch: []string{arg}}
+		if _, err := flags.GetConditions(); err == nil {
+			t.Errorf("expected an error for %q", arg)
+		}
+	}
+}
+
+// sleepScanner succeeds after delay.
+type sleepScanner struct {
+	echoScanner
+	delay time.Duration
+}
+
+func (s *sleepScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	time.Sleep(s.delay)
+	return SCAN_SUCCESS, s.name, nil
+}
+
+func TestEngineScannersPerTarget(t *testing.T) {
+	var slow []Scanner
+	for _, name := range []string{"slow1", "slow2", "slow3"} {
+		slow = append(slow, &sleepScanner{echoScanner: echoScanner{name: name}, delay: 200 * time.Millisecond})
+	}
+	after := &fixedScanner{echoScanner: echoScanner{name: "after"}, status: SCAN_SUCCESS, peek: "slow1"}
+	conditions := map[string][]ScanCondition{"after": {{Scanner: "slow1"}}}
+	engine, err := NewEngine(EngineConfig{ContinueOnError: true, ScannersPerTarget: 3, Conditions: conditions}, append(slow, after)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var grabs []*Grab
+	start := time.Now()
+	if err := engine.Run(context.Background(), IterateTargets([]ScanTarget{{Domain: "example.com"}}), collect(&grabs)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("expected the slow scanners to run concurrently, took %s", d)
+	}
+	if len(grabs[0].Data) != 4 {
+		t.Errorf("expected the 4 responses, got %v", grabs[0].Data)
+	}
+	if after.peeked == nil || after.peeked.Status != SCAN_SUCCESS {
+		t.Errorf("expected after to wait for slow1, saw %v", after.peeked)
+	}
+
+	// without --continue-on-error, the scanners still start at once, and
+	// those after a failed one are dropped
+	failed := &fixedScanner{echoScanner: echoScanner{name: "failed"}, status: SCAN_PROTOCOL_ERROR}
+	engine, err = NewEngine(EngineConfig{ScannersPerTarget: 4}, append(append([]Scanner{}, slow...), failed, after)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grabs = nil
+	start = time.Now()
+	if err := engine.Run(context.Background(), IterateTargets([]ScanTarget{{Domain: "example.com"}}), collect(&grabs)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("expected the slow scanners to run concurrently without --continue-on-error, took %s", d)
+	}
+	if _, ok := grabs[0].Data["after"]; ok || len(grabs[0].Data) != 4 {
+		t.Errorf("expected the responses up to failed, got %v", grabs[0].Data)
+	}
+
+	// with --break-on-success, the results after the first success are
+	// dropped, and not reported to the monitor
+	first := &fixedScanner{echoScanner: echoScanner{name: "first"}, status: SCAN_SUCCESS}
+	var wg sync.WaitGroup
+	mon := MakeMonitor(1, &wg)
+	engine, err = NewEngine(EngineConfig{ContinueOnError: true, BreakOnSuccess: true, ScannersPerTarget: 3, Monitor: mon}, append([]Scanner{first}, slow...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grabs = nil
+	if err := engine.Run(context.Background(), IterateTargets([]ScanTarget{{Domain: "example.com"}}), collect(&grabs)); err != nil {
+		t.Fatal(err)
+	}
+	mon.Stop()
+	wg.Wait()
+	if len(grabs[0].Data) != 1 {
+		t.Errorf("expected only the first response, got %v", grabs[0].Data)
+	}
+	if statuses := mon.GetStatuses(); len(statuses) != 1 || statuses["first"] == nil || statuses["first"].Successes != 1 {
+		t.Errorf("expected only first to be reported, got %v", statuses)
+	}
+}
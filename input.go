@@ -2,22 +2,24 @@ package zgrab2
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
 
 // ParseCSVTarget takes a record from a CSV-format input file and
-// returns the specified ipnet, domain, and tag, or an error.
+// returns the specified ipnet, domain, tag and ports, or an error.
 //
-// ZGrab2 input files have three fields:
+// ZGrab2 input files have four fields:
 //
-//	IP, DOMAIN, TAG
+//	IP, DOMAIN, TAG, PORT
 //
 // Each line specifies a target to scan by its IP address, domain
 // name, or both, as well as an optional tag used to determine which
@@ -27,9 +29,15 @@ import (
 // framework expands the record into targets for every address in the
 // block.
 //
+// The IP field may also carry a port, as in 1.2.3.4:8443 or
+// [2001:db8::1]:443. Alternatively, the PORT field may list ports and
+// port ranges, e.g. "80,443,8000-8100" (quoted, since it contains
+// commas). The record is expanded into one target per port; records
+// without a port use the port given in each scanner's flags.
+//
 // Trailing empty fields may be omitted.
 // Comment lines begin with #, and empty lines are ignored.
-func ParseCSVTarget(fields []string) (ipnet *net.IPNet, domain string, tag string, err error) {
+func ParseCSVTarget(fields []string) (ipnet *net.IPNet, domain string, tag string, ports []uint, err error) {
 	for i := range fields {
 		fields[i] = strings.TrimSpace(fields[i])
 	}
@@ -38,6 +46,11 @@ func ParseCSVTarget(fields []string) (ipnet *net.IPNet, domain string, tag strin
 			ipnet = &net.IPNet{IP: ip}
 		} else if _, cidr, er := net.ParseCIDR(fields[0]); er == nil {
 			ipnet = cidr
+		} else if host, port, er := net.SplitHostPort(fields[0]); er == nil && net.ParseIP(host) != nil {
+			ipnet = &net.IPNet{IP: net.ParseIP(host)}
+			if ports, err = ParsePortList(port); err != nil {
+				return
+			}
 		} else if len(fields) != 1 {
 			err = fmt.Errorf("can't parse %q as an IP address or CIDR block", fields[0])
 			return
@@ -49,7 +62,16 @@ func ParseCSVTarget(fields []string) (ipnet *net.IPNet, domain string, tag strin
 	if len(fields) > 2 {
 		tag = fields[2]
 	}
-	if len(fields) > 3 {
+	if len(fields) > 3 && fields[3] != "" {
+		if ports != nil {
+			err = fmt.Errorf("port given both in the IP and PORT fields: %q", fields)
+			return
+		}
+		if ports, err = ParsePortList(fields[3]); err != nil {
+			return
+		}
+	}
+	if len(fields) > 4 {
 		err = fmt.Errorf("too many fields: %q", fields)
 		return
 	}
@@ -67,6 +89,42 @@ func ParseCSVTarget(fields []string) (ipnet *net.IPNet, domain string, tag strin
 	return
 }
 
+// ParsePortList parses a comma-separated list of ports and port ranges,
+// such as "80,443,8000-8100", into the list of ports it contains. Duplicates
+// are dropped, and the order of first appearance is kept.
+func ParsePortList(s string) ([]uint, error) {
+	var ret []uint
+	seen := make(map[uint]bool)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		low, high := item, item
+		if i := strings.IndexByte(item, '-'); i >= 0 {
+			low, high = item[:i], item[i+1:]
+		}
+		l, err := strconv.ParseUint(strings.TrimSpace(low), 10, 16)
+		if err != nil || l == 0 {
+			return nil, fmt.Errorf("invalid port %q", item)
+		}
+		h, err := strconv.ParseUint(strings.TrimSpace(high), 10, 16)
+		if err != nil || h < l {
+			return nil, fmt.Errorf("invalid port range %q", item)
+		}
+		for p := uint(l); p <= uint(h); p++ {
+			if !seen[p] {
+				seen[p] = true
+				ret = append(ret, p)
+			}
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no ports in %q", s)
+	}
+	return ret, nil
+}
+
 func incrementIP(ip net.IP) {
 	for j := len(ip) - 1; j >= 0; j-- {
 		ip[j]++
@@ -91,7 +149,6 @@ func InputTargetsCSV(ch chan<- ScanTarget) error {
 // GetTargetsCSV reads targets from a CSV source, generates ScanTargets,
 // and delivers them to the provided channel.
 func GetTargetsCSV(source io.Reader, ch chan<- ScanTarget) error {
-	var rTotal int
 	csvreader := csv.NewReader(source)
 	csvreader.Comment = '#'
 	csvreader.FieldsPerRecord = -1 // variable
@@ -106,31 +163,123 @@ func GetTargetsCSV(source io.Reader, ch chan<- ScanTarget) error {
 		if len(fields) == 0 {
 			continue
 		}
-		ipnet, domain, tag, err := ParseCSVTarget(fields)
+		ipnet, domain, tag, ports, err := ParseCSVTarget(fields)
 		if err != nil {
 			log.Errorf("parse error, skipping: %v", err)
 			continue
 		}
-		var ip net.IP
-		if ipnet != nil {
-			if ipnet.Mask != nil {
-				// expand CIDR block into one target for each IP
-				for ip = ipnet.IP.Mask(ipnet.Mask); ipnet.Contains(ip); incrementIP(ip) {
-					ch <- ScanTarget{IP: duplicateIP(ip), Domain: domain, Tag: tag}
-					rTotal++
-					log.Info("pass info")
-				}
-				continue
-			} else {
-				ip = ipnet.IP
-			}
-		}
-		ch <- ScanTarget{IP: ip, Domain: domain, Tag: tag}
-		rTotal++
+		expandTargets(ipnet, ScanTarget{Domain: domain, Tag: tag}, ports, ch)
 	}
 	return nil
 }
 
+// expandTargets delivers a copy of template for each IP in ipnet (or a single
+// one if ipnet is nil or a bare IP), and for each of the given ports, or a
+// single one using the scanners' configured port if there are none.
+func expandTargets(ipnet *net.IPNet, template ScanTarget, ports []uint, ch chan<- ScanTarget) {
+	emit := func(ip net.IP) {
+		target := template
+		target.IP = ip
+		if ports == nil {
+			ch <- target
+			return
+		}
+		for _, port := range ports {
+			port := port
+			target.Port = &port
+			ch <- target
+		}
+	}
+	if ipnet == nil {
+		emit(nil)
+	} else if ipnet.Mask != nil {
+		// expand CIDR block into one target for each IP, including the
+		// excluded ones, which are reported as blocklisted when scanned
+		for ip := ipnet.IP.Mask(ipnet.Mask); ipnet.Contains(ip); incrementIP(ip) {
+			emit(duplicateIP(ip))
+		}
+	} else {
+		emit(ipnet.IP)
+	}
+}
+
+// JSONTarget is a line of JSON Lines input. IP, Domain, Tag and Port have the
+// same meaning as the fields of CSV input; Port may be a number or a string
+// listing ports and port ranges. The other fields override the corresponding
+// scanner flags for this target only.
+type JSONTarget struct {
+	IP     string          `json:"ip,omitempty"`
+	Domain string          `json:"domain,omitempty"`
+	Tag    string          `json:"tag,omitempty"`
+	Port   json.RawMessage `json:"port,omitempty"`
+
+	// SNI overrides --server-name.
+	SNI string `json:"sni,omitempty"`
+
+	// Endpoint overrides the --endpoint of the http module.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// ParseJSONTarget takes a line from a JSON Lines input file and returns the
+// specified ipnet and ports, along with the ScanTarget to use as a template
+// for each of the targets it expands to, or an error. Unknown fields are an
+// error.
+func ParseJSONTarget(line []byte) (ipnet *net.IPNet, template ScanTarget, ports []uint, err error) {
+	var t JSONTarget
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&t); err != nil {
+		return
+	}
+	port := string(t.Port)
+	if port == "null" {
+		port = ""
+	} else if len(t.Port) > 0 && t.Port[0] == '"' {
+		if err = json.Unmarshal(t.Port, &port); err != nil {
+			return
+		}
+	}
+	var domain, tag string
+	ipnet, domain, tag, ports, err = ParseCSVTarget([]string{t.IP, t.Domain, t.Tag, port})
+	if err != nil {
+		return
+	}
+	template = ScanTarget{
+		Domain:     domain,
+		Tag:        tag,
+		ServerName: t.SNI,
+		Endpoint:   t.Endpoint,
+	}
+	return
+}
+
+// InputTargetsJSONL is an InputTargetsFunc that calls GetTargetsJSONL with
+// the JSON Lines file provided on the command line.
+func InputTargetsJSONL(ch chan<- ScanTarget) error {
+	return GetTargetsJSONL(config.inputFile, ch)
+}
+
+// GetTargetsJSONL reads targets from a JSON Lines source, one JSONTarget per
+// line, generates ScanTargets, and delivers them to the provided channel.
+// As with CSV input, lines beginning with # and empty lines are ignored.
+func GetTargetsJSONL(source io.Reader, ch chan<- ScanTarget) error {
+	s := bufio.NewScanner(source)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		ipnet, template, ports, err := ParseJSONTarget(line)
+		if err != nil {
+			log.Errorf("parse error, skipping: %v", err)
+			continue
+		}
+		expandTargets(ipnet, template, ports, ch)
+	}
+	return s.Err()
+}
+
 func GetBanners(source io.Reader, ch chan<- Grab) error {
 	s := bufio.NewScanner(source)
 	for s.Scan() {
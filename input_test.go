@@ -2,6 +2,7 @@ package zgrab2
 
 import (
 	"net"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -39,6 +40,7 @@ func TestParseCSVTarget(t *testing.T) {
 		ipnet   *net.IPNet
 		domain  string
 		tag     string
+		ports   []uint
 		success bool
 	}{
 		// IP DOMAIN TAG
@@ -116,9 +118,65 @@ func TestParseCSVTarget(t *testing.T) {
 			fields:  []string{"", "", "tag"},
 			success: false,
 		},
+		// IP:PORT
+		{
+			fields:  []string{"10.0.0.1:8443", "example.com"},
+			ipnet:   parseIP("10.0.0.1"),
+			domain:  "example.com",
+			ports:   []uint{8443},
+			success: true,
+		},
+		// [IPv6]:PORT
+		{
+			fields:  []string{"[2001:db8::1]:443"},
+			ipnet:   parseIP("2001:db8::1"),
+			ports:   []uint{443},
+			success: true,
+		},
+		// IP DOMAIN TAG PORT
+		{
+			fields:  []string{"10.0.0.1", "", "tag", "80, 443,8000-8002,443"},
+			ipnet:   parseIP("10.0.0.1"),
+			tag:     "tag",
+			ports:   []uint{80, 443, 8000, 8001, 8002},
+			success: true,
+		},
+		// CIDR PORT
+		{
+			fields:  []string{"10.0.0.1/8", "", "", "22"},
+			ipnet:   parseCIDR("10.0.0.1/8"),
+			ports:   []uint{22},
+			success: true,
+		},
+		// Empty PORT field
+		{
+			fields:  []string{"10.0.0.1", "", "", ""},
+			ipnet:   parseIP("10.0.0.1"),
+			success: true,
+		},
+		// Error: Port in both IP and PORT fields
+		{
+			fields:  []string{"10.0.0.1:80", "", "", "443"},
+			success: false,
+		},
+		// Error: Invalid port
+		{
+			fields:  []string{"10.0.0.1", "", "", "70000"},
+			success: false,
+		},
+		// Error: Invalid port range
+		{
+			fields:  []string{"10.0.0.1", "", "", "90-80"},
+			success: false,
+		},
+		// Error: Invalid port in IP field
+		{
+			fields:  []string{"10.0.0.1:http", "example.com"},
+			success: false,
+		},
 		// Error: Too many fields
 		{
-			fields:  []string{"", "", "", ""},
+			fields:  []string{"", "", "", "", ""},
 			success: false,
 		},
 		// Error: IP and domain reversed
@@ -129,14 +187,14 @@ func TestParseCSVTarget(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		ipnet, domain, tag, err := ParseCSVTarget(test.fields)
+		ipnet, domain, tag, ports, err := ParseCSVTarget(test.fields)
 		if (err == nil) != test.success {
 			t.Errorf("wrong error status (got err=%v, success should be %v): %q", err, test.success, test.fields)
 			return
 		}
 		if err == nil {
-			if ipnetString(ipnet) != ipnetString(test.ipnet) || domain != test.domain || tag != test.tag {
-				t.Errorf("wrong result (got %v,%v,%v; expected %v,%v,%v): %q", ipnetString(ipnet), domain, tag, ipnetString(test.ipnet), test.domain, test.tag, test.fields)
+			if ipnetString(ipnet) != ipnetString(test.ipnet) || domain != test.domain || tag != test.tag || !reflect.DeepEqual(ports, test.ports) {
+				t.Errorf("wrong result (got %v,%v,%v,%v; expected %v,%v,%v,%v): %q", ipnetString(ipnet), domain, tag, ports, ipnetString(test.ipnet), test.domain, test.tag, test.ports, test.fields)
 				return
 			}
 		}
@@ -144,13 +202,19 @@ func TestParseCSVTarget(t *testing.T) {
 }
 
 func TestGetTargetsCSV(t *testing.T) {
+	port := func(p uint) *uint {
+		return &p
+	}
 	input := `# Comment
 10.0.0.1,example.com,tag
  10.0.0.1 ,"example.com"
 10.0.0.1
 ,example.com
 example.com
-2.2.2.2/30,, tag`
+2.2.2.2/30,, tag
+10.0.0.2:8080
+10.0.0.3,,,"80,443"
+2.2.2.2/31,,,22-23`
 
 	expected := []ScanTarget{
 		ScanTarget{IP: net.ParseIP("10.0.0.1"), Domain: "example.com", Tag: "tag"},
@@ -162,6 +226,13 @@ example.com
 		ScanTarget{IP: net.ParseIP("2.2.2.1"), Tag: "tag"},
 		ScanTarget{IP: net.ParseIP("2.2.2.2"), Tag: "tag"},
 		ScanTarget{IP: net.ParseIP("2.2.2.3"), Tag: "tag"},
+		ScanTarget{IP: net.ParseIP("10.0.0.2"), Port: port(8080)},
+		ScanTarget{IP: net.ParseIP("10.0.0.3"), Port: port(80)},
+		ScanTarget{IP: net.ParseIP("10.0.0.3"), Port: port(443)},
+		ScanTarget{IP: net.ParseIP("2.2.2.2"), Port: port(22)},
+		ScanTarget{IP: net.ParseIP("2.2.2.2"), Port: port(23)},
+		ScanTarget{IP: net.ParseIP("2.2.2.3"), Port: port(22)},
+		ScanTarget{IP: net.ParseIP("2.2.2.3"), Port: port(23)},
 	}
 
 	ch := make(chan ScanTarget, 0)
@@ -184,7 +255,61 @@ example.com
 	for i := range expected {
 		if res[i].IP.String() != expected[i].IP.String() ||
 			res[i].Domain != expected[i].Domain ||
-			res[i].Tag != expected[i].Tag {
+			res[i].Tag != expected[i].Tag ||
+			!reflect.DeepEqual(res[i].Port, expected[i].Port) {
+			t.Errorf("wrong data in ScanTarget %d (got %v; expected %v)", i, res[i], expected[i])
+		}
+	}
+}
+
+func TestGetTargetsJSONL(t *testing.T) {
+	port := func(p uint) *uint {
+		return &p
+	}
+	input := `# Comment
+{"ip": "10.0.0.1", "domain": "example.com", "tag": "tag"}
+
+{"domain": "example.com", "port": 8443, "sni": "www.example.com", "endpoint": "/status"}
+{"ip": "10.0.0.2:8080"}
+{"ip": "2.2.2.2/31", "port": "22-23"}
+{"ip": "10.0.0.3", "port": null}
+{"ip": "10.0.0.4", "unknown": true}
+{"ip": "not an ip"}
+{"ip": "10.0.0.5:80", "port": 443}
+not json`
+
+	expected := []ScanTarget{
+		ScanTarget{IP: net.ParseIP("10.0.0.1"), Domain: "example.com", Tag: "tag"},
+		ScanTarget{Domain: "example.com", Port: port(8443), ServerName: "www.example.com", Endpoint: "/status"},
+		ScanTarget{IP: net.ParseIP("10.0.0.2"), Port: port(8080)},
+		ScanTarget{IP: net.ParseIP("2.2.2.2"), Port: port(22)},
+		ScanTarget{IP: net.ParseIP("2.2.2.2"), Port: port(23)},
+		ScanTarget{IP: net.ParseIP("2.2.2.3"), Port: port(22)},
+		ScanTarget{IP: net.ParseIP("2.2.2.3"), Port: port(23)},
+		ScanTarget{IP: net.ParseIP("10.0.0.3")},
+	}
+
+	ch := make(chan ScanTarget, 0)
+	go func() {
+		err := GetTargetsJSONL(strings.NewReader(input), ch)
+		if err != nil {
+			t.Errorf("GetTargets error: %v", err)
+		}
+		close(ch)
+	}()
+	res := []ScanTarget{}
+	for r := range ch {
+		res = append(res, r)
+	}
+
+	if len(res) != len(expected) {
+		t.Errorf("wrong number of results (got %d; expected %d)", len(res), len(expected))
+		return
+	}
+	for i := range expected {
+		got, want := res[i], expected[i]
+		got.IP, want.IP = nil, nil
+		if res[i].IP.String() != expected[i].IP.String() || !reflect.DeepEqual(got, want) {
 			t.Errorf("wrong data in ScanTarget %d (got %v; expected %v)", i, res[i], expected[i])
 		}
 	}
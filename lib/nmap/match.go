@@ -0,0 +1,409 @@
+package nmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dlclark/regexp2"
+)
+
+// MatchTimeout bounds the time a single match expression may take to run
+// against a response, guarding against catastrophic backtracking.
+var MatchTimeout = 100 * time.Millisecond
+
+// Match is a single match or softmatch directive.
+type Match struct {
+	// Probe is the probe this match belongs to.
+	Probe *Probe
+
+	// Service is the service name reported on a match.
+	Service string
+
+	// Pattern is the source of the match expression.
+	Pattern string
+
+	// Soft is set for softmatch directives.
+	Soft bool
+
+	// CaseInsensitive and DotAll are the i and s options of the expression.
+	CaseInsensitive bool
+	DotAll          bool
+
+	// Templates for the version information fields; these may reference
+	// capture groups of the expression (e.g. "$1", "$P(2)").
+	Product    string
+	Version    string
+	Info       string
+	Hostname   string
+	OS         string
+	DeviceType string
+	CPE        []string
+
+	re *regexp2.Regexp
+}
+
+// ServiceInfo is the version information extracted from a successful match.
+type ServiceInfo struct {
+	Service    string   `json:"service,omitempty"`
+	Product    string   `json:"product,omitempty"`
+	Version    string   `json:"version,omitempty"`
+	Info       string   `json:"info,omitempty"`
+	Hostname   string   `json:"hostname,omitempty"`
+	OS         string   `json:"os,omitempty"`
+	DeviceType string   `json:"device_type,omitempty"`
+	CPE        []string `json:"cpe,omitempty"`
+
+	// Probe is the name of the probe whose match directive matched.
+	Probe string `json:"probe,omitempty"`
+
+	// SoftMatch is true if only a softmatch directive matched, in which case
+	// only Service is reliable.
+	SoftMatch bool `json:"softmatch,omitempty"`
+}
+
+// parseMatch parses the arguments of a match/softmatch directive:
+//
+//	<service> m|<pattern>|[<options>] [<versioninfo>]
+func parseMatch(rest string, soft bool) (*Match, error) {
+	service, spec := splitDirective(rest)
+	if service == "" || len(spec) < 3 || spec[0] != 'm' {
+		return nil, fmt.Errorf("malformed match directive: %q", rest)
+	}
+	pattern, tail, err := splitDelimited(spec[1:])
+	if err != nil {
+		return nil, err
+	}
+	match := &Match{Service: service, Pattern: pattern, Soft: soft}
+	for len(tail) > 0 && tail[0] != ' ' {
+		switch tail[0] {
+		case 'i':
+			match.CaseInsensitive = true
+		case 's':
+			match.DotAll = true
+		default:
+			return nil, fmt.Errorf("unknown match option %q", tail[0])
+		}
+		tail = tail[1:]
+	}
+	for tail = strings.TrimSpace(tail); tail != ""; tail = strings.TrimSpace(tail) {
+		var field, value string
+		if strings.HasPrefix(tail, "cpe:") {
+			field = "cpe"
+			tail = tail[len("cpe:"):]
+		} else {
+			field = tail[:1]
+			tail = tail[1:]
+		}
+		if value, tail, err = splitDelimited(tail); err != nil {
+			return nil, err
+		}
+		switch field {
+		case "p":
+			match.Product = value
+		case "v":
+			match.Version = value
+		case "i":
+			match.Info = value
+		case "h":
+			match.Hostname = value
+		case "o":
+			match.OS = value
+		case "d":
+			match.DeviceType = value
+		case "cpe":
+			match.CPE = append(match.CPE, "cpe:/"+value)
+			// An optional trailing "a" flag is accepted and ignored.
+			tail = strings.TrimPrefix(tail, "a")
+		default:
+			return nil, fmt.Errorf("unknown version info field %q", field)
+		}
+	}
+	return match, nil
+}
+
+func (match *Match) compile() error {
+	opts := regexp2.None
+	if match.CaseInsensitive {
+		opts |= regexp2.IgnoreCase
+	}
+	if match.DotAll {
+		opts |= regexp2.Singleline
+	}
+	re, err := regexp2.Compile(match.Pattern, opts)
+	if err != nil {
+		return err
+	}
+	re.MatchTimeout = MatchTimeout
+	match.re = re
+	return nil
+}
+
+// bytesToRunes maps each byte to the rune with the same value, so that \xHH
+// escapes in the expressions match raw bytes rather than UTF-8 sequences.
+func bytesToRunes(b []byte) []rune {
+	ret := make([]rune, len(b))
+	for i, c := range b {
+		ret[i] = rune(c)
+	}
+	return ret
+}
+
+func runesToBytes(r []rune) []byte {
+	ret := make([]byte, len(r))
+	for i, c := range r {
+		ret[i] = byte(c)
+	}
+	return ret
+}
+
+// Match runs the expression against the response and, if it matches, returns
+// the version information with any capture group references substituted.
+func (match *Match) Match(response []byte) (*ServiceInfo, bool) {
+	return match.matchRunes(bytesToRunes(response))
+}
+
+func (match *Match) matchRunes(response []rune) (*ServiceInfo, bool) {
+	m, err := match.re.FindRunesMatch(response)
+	if err != nil || m == nil {
+		return nil, false
+	}
+	groups := make([][]byte, m.GroupCount())
+	for i := range groups {
+		if g := m.GroupByNumber(i); g != nil && len(g.Captures) > 0 {
+			groups[i] = runesToBytes(g.Runes())
+		}
+	}
+	info := &ServiceInfo{
+		Service:    match.Service,
+		Product:    substitute(match.Product, groups),
+		Version:    substitute(match.Version, groups),
+		Info:       substitute(match.Info, groups),
+		Hostname:   substitute(match.Hostname, groups),
+		OS:         substitute(match.OS, groups),
+		DeviceType: substitute(match.DeviceType, groups),
+		SoftMatch:  match.Soft,
+	}
+	for _, cpe := range match.CPE {
+		info.CPE = append(info.CPE, substitute(cpe, groups))
+	}
+	if match.Probe != nil {
+		info.Probe = match.Probe.Name
+	}
+	return info, true
+}
+
+var errBadTemplate = errors.New("bad template")
+
+// substitute expands the capture group references in a version info
+// template: $1..$9, $P(n), $SUBST(n,"from","to") and $I(n,"<"|">").
+func substitute(template string, groups [][]byte) string {
+	if !strings.Contains(template, "$") {
+		return template
+	}
+	var out strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '$' || i+1 >= len(template) {
+			out.WriteByte(template[i])
+			continue
+		}
+		rest := template[i+1:]
+		var (
+			value    string
+			consumed int
+			err      error
+		)
+		switch {
+		case rest[0] >= '0' && rest[0] <= '9':
+			value, consumed = string(group(groups, int(rest[0]-'0'))), 1
+		case strings.HasPrefix(rest, "P("):
+			value, consumed, err = substituteFunc(rest[2:], groups, printable)
+			consumed += 2
+		case strings.HasPrefix(rest, "SUBST("):
+			value, consumed, err = substituteFunc(rest[6:], groups, subst)
+			consumed += 6
+		case strings.HasPrefix(rest, "I("):
+			value, consumed, err = substituteFunc(rest[2:], groups, unpackInt)
+			consumed += 2
+		default:
+			err = errBadTemplate
+		}
+		if err != nil {
+			out.WriteByte('$')
+			continue
+		}
+		out.WriteString(value)
+		i += consumed
+	}
+	return strings.TrimSpace(out.String())
+}
+
+func group(groups [][]byte, n int) []byte {
+	if n < len(groups) {
+		return groups[n]
+	}
+	return nil
+}
+
+// substituteFunc parses the argument list of a template function up to the
+// closing parenthesis, and applies fn to the referenced group. The arguments
+// are separated by the commas outside of double quotes, and unquoted.
+func substituteFunc(s string, groups [][]byte, fn func([]byte, []string) (string, error)) (string, int, error) {
+	end := -1
+	inQuote := false
+	var args []string
+	start := 0
+	for i := 0; i < len(s) && end < 0; i++ {
+		switch {
+		case s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == ',' && !inQuote:
+			args = append(args, s[start:i])
+			start = i + 1
+		case s[i] == ')' && !inQuote:
+			args = append(args, s[start:i])
+			end = i
+		}
+	}
+	if end < 0 {
+		return "", 0, errBadTemplate
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(args[0]))
+	if err != nil {
+		return "", 0, errBadTemplate
+	}
+	for i := range args[1:] {
+		arg := strings.TrimSpace(args[i+1])
+		if len(arg) >= 2 && arg[0] == '"' && arg[len(arg)-1] == '"' {
+			arg = arg[1 : len(arg)-1]
+		}
+		args[i+1] = arg
+	}
+	value, err := fn(group(groups, n), args[1:])
+	return value, end + 1, err
+}
+
+// printable keeps only the printable ASCII characters of the group.
+func printable(b []byte, _ []string) (string, error) {
+	var out strings.Builder
+	for _, c := range b {
+		if c >= 0x20 && c < 0x7f {
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), nil
+}
+
+// subst replaces every occurrence of args[0] with args[1] in the group.
+func subst(b []byte, args []string) (string, error) {
+	if len(args) != 2 {
+		return "", errBadTemplate
+	}
+	return strings.Replace(string(b), args[0], args[1], -1), nil
+}
+
+// unpackInt decodes the group as an unsigned big- (">") or little- ("<")
+// endian integer of up to 8 bytes.
+func unpackInt(b []byte, args []string) (string, error) {
+	if len(args) != 1 || len(b) == 0 || len(b) > 8 {
+		return "", errBadTemplate
+	}
+	buf := make([]byte, 8)
+	switch args[0] {
+	case ">":
+		copy(buf[8-len(b):], b)
+		return strconv.FormatUint(binary.BigEndian.Uint64(buf), 10), nil
+	case "<":
+		copy(buf, b)
+		return strconv.FormatUint(binary.LittleEndian.Uint64(buf), 10), nil
+	default:
+		return "", errBadTemplate
+	}
+}
+
+// MatchResponse finds the service that sent response in reply to probe. The
+// matches of the probe itself are tried first, followed by those of its
+// fallback probes (see Fallbacks). As in nmap, a softmatch is remembered,
+// and only the hard matches for the same service are tried afterwards; if
+// none of them matches, the softmatch is returned.
+func MatchResponse(probe *Probe, response []byte) *ServiceInfo {
+	runes := bytesToRunes(response)
+	var soft *ServiceInfo
+	seen := make(map[*Probe]bool)
+	for _, p := range append([]*Probe{probe}, probe.Fallbacks()...) {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		if info := matchProbe(p, runes, &soft); info != nil {
+			return info
+		}
+	}
+	return soft
+}
+
+func matchProbe(probe *Probe, runes []rune, soft **ServiceInfo) *ServiceInfo {
+	for _, match := range probe.Matches {
+		if *soft != nil && match.Service != (*soft).Service {
+			continue
+		}
+		info, ok := match.matchRunes(runes)
+		if !ok {
+			continue
+		}
+		if !match.Soft {
+			return info
+		}
+		if *soft == nil {
+			*soft = info
+		}
+	}
+	return nil
+}
+
+// MatchBanner fingerprints a response that was not necessarily elicited by a
+// known probe, such as a banner collected by another module. The probe named
+// hint (if any) is tried first, then the NULL probe, then every other probe
+// of the given protocol in file order.
+func (sp *ServiceProbes) MatchBanner(protocol Protocol, hint string, response []byte) *ServiceInfo {
+	if len(response) == 0 {
+		return nil
+	}
+	runes := bytesToRunes(response)
+	var soft *ServiceInfo
+	seen := make(map[*Probe]bool)
+	try := func(probe *Probe) *ServiceInfo {
+		if probe == nil || seen[probe] {
+			return nil
+		}
+		seen[probe] = true
+		return matchProbe(probe, runes, &soft)
+	}
+	if hint != "" {
+		if probe := sp.Probe(protocol, hint); probe != nil {
+			if info := try(probe); info != nil {
+				return info
+			}
+			for _, fb := range probe.Fallbacks() {
+				if info := try(fb); info != nil {
+					return info
+				}
+			}
+		}
+	}
+	if info := try(sp.Probe(protocol, "NULL")); info != nil {
+		return info
+	}
+	for _, probe := range sp.Probes {
+		if probe.Protocol != protocol {
+			continue
+		}
+		if info := try(probe); info != nil {
+			return info
+		}
+	}
+	return soft
+}
@@ -0,0 +1,423 @@
+// Package nmap implements a parser and matcher for the nmap-service-probes
+// database used by nmap's version detection.
+//
+// The file format is described at https://nmap.org/book/vscan-fileformat.html.
+// Probe strings and match expressions are parsed into Probe and Match values;
+// match expressions are compiled with regexp2, since the database relies on
+// Perl regular expression features (lookarounds, backreferences, etc.) that
+// the standard library does not support.
+package nmap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Protocol is the transport protocol a probe is sent over.
+type Protocol string
+
+const (
+	// TCP probes are sent over a TCP connection.
+	TCP = Protocol("TCP")
+
+	// UDP probes are sent as a single UDP datagram.
+	UDP = Protocol("UDP")
+)
+
+// DefaultRarity is the rarity of probes that do not specify one.
+const DefaultRarity = 5
+
+// DefaultTotalWait is the time to wait for a response to a probe that does
+// not specify totalwaitms.
+const DefaultTotalWait = 5 * time.Second
+
+// Probe is a single Probe directive, along with the directives that follow it.
+type Probe struct {
+	// Protocol is the transport protocol the probe is sent over.
+	Protocol Protocol
+
+	// Name is the name of the probe, e.g. "NULL" or "GetRequest".
+	Name string
+
+	// Data is the payload sent to the server; it is empty for the NULL probe.
+	Data []byte
+
+	// NoPayload is set if the probe was marked with the no-payload option.
+	NoPayload bool
+
+	// Ports is the set of ports this probe is commonly useful on.
+	Ports PortSet
+
+	// SSLPorts is the set of ports this probe is useful on when tunneled over SSL/TLS.
+	SSLPorts PortSet
+
+	// TotalWait is the time to wait for a response before giving up.
+	TotalWait time.Duration
+
+	// TCPWrapped is the period during which a closed connection is treated as
+	// "tcpwrapped" rather than as a normal response.
+	TCPWrapped time.Duration
+
+	// Rarity ranges from 1 (common) to 9 (rarely useful).
+	Rarity int
+
+	// Fallback lists the names of probes whose matches should also be tried
+	// against responses to this probe.
+	Fallback []string
+
+	// Matches are the match and softmatch directives for this probe, in the
+	// order they appear in the file.
+	Matches []*Match
+
+	// fallback holds the resolved Fallback probes.
+	fallback []*Probe
+}
+
+// Fallbacks returns the probes named in the fallback directive, in order,
+// followed by the NULL probe for TCP probes. Unknown names are omitted.
+func (probe *Probe) Fallbacks() []*Probe {
+	return probe.fallback
+}
+
+// ServiceProbes is a parsed nmap-service-probes database.
+type ServiceProbes struct {
+	// Probes are all probes, in the order they appear in the file.
+	Probes []*Probe
+
+	// Exclude is the set of ports excluded from version scanning.
+	Exclude PortSet
+
+	// UDPExclude is the set of UDP ports excluded from version scanning.
+	UDPExclude PortSet
+
+	// Skipped counts the match directives whose expression could not be
+	// compiled, and which are therefore never tried.
+	Skipped int
+
+	byName map[string]*Probe
+}
+
+// Probe returns the probe with the given name and protocol, or nil.
+func (sp *ServiceProbes) Probe(protocol Protocol, name string) *Probe {
+	return sp.byName[string(protocol)+"/"+name]
+}
+
+// ProbesByRarity returns the probes of the given protocol whose rarity does
+// not exceed intensity, ordered the way nmap sends them: the NULL probe first,
+// then probes that list port among their (ssl)ports, then the rest, each group
+// sorted by rarity. Probes are otherwise kept in file order.
+func (sp *ServiceProbes) ProbesByRarity(protocol Protocol, port uint, tls bool, intensity int) []*Probe {
+	var ret []*Probe
+	for _, probe := range sp.Probes {
+		if probe.Protocol != protocol {
+			continue
+		}
+		if probe.Rarity > intensity && !probe.hasPort(port, tls) && len(probe.Data) != 0 {
+			continue
+		}
+		ret = append(ret, probe)
+	}
+	rank := func(probe *Probe) int {
+		switch {
+		case len(probe.Data) == 0:
+			return 0
+		case probe.hasPort(port, tls):
+			return 1
+		default:
+			return 2
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		ri, rj := rank(ret[i]), rank(ret[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return ret[i].Rarity < ret[j].Rarity
+	})
+	return ret
+}
+
+func (probe *Probe) hasPort(port uint, tls bool) bool {
+	if tls {
+		return probe.SSLPorts.Contains(port)
+	}
+	return probe.Ports.Contains(port)
+}
+
+// LoadServiceProbes reads and parses the nmap-service-probes file at path.
+func LoadServiceProbes(path string) (*ServiceProbes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseServiceProbes(f)
+}
+
+// ParseServiceProbes parses an nmap-service-probes database. Syntax errors
+// are fatal; match expressions that regexp2 cannot compile are logged at
+// debug level, counted in Skipped and otherwise ignored.
+func ParseServiceProbes(r io.Reader) (*ServiceProbes, error) {
+	sp := &ServiceProbes{byName: make(map[string]*Probe)}
+	var current *Probe
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		directive, rest := splitDirective(line)
+		if directive != "Probe" && directive != "Exclude" && current == nil {
+			return nil, fmt.Errorf("line %d: %s directive before first Probe", lineNo, directive)
+		}
+		var err error
+		switch directive {
+		case "Exclude":
+			err = sp.parseExclude(rest)
+		case "Probe":
+			current, err = parseProbe(rest)
+			if err == nil {
+				sp.Probes = append(sp.Probes, current)
+				sp.byName[string(current.Protocol)+"/"+current.Name] = current
+			}
+		case "match", "softmatch":
+			var match *Match
+			match, err = parseMatch(rest, directive == "softmatch")
+			if err == nil {
+				match.Probe = current
+				if cerr := match.compile(); cerr != nil {
+					log.Debugf("nmap-service-probes line %d: skipping match for %s: %v", lineNo, match.Service, cerr)
+					sp.Skipped++
+				} else {
+					current.Matches = append(current.Matches, match)
+				}
+			}
+		case "ports":
+			current.Ports, err = ParsePortSet(rest)
+		case "sslports":
+			current.SSLPorts, err = ParsePortSet(rest)
+		case "totalwaitms":
+			current.TotalWait, err = parseMillis(rest)
+		case "tcpwrappedms":
+			current.TCPWrapped, err = parseMillis(rest)
+		case "rarity":
+			current.Rarity, err = strconv.Atoi(rest)
+			if err == nil && (current.Rarity < 1 || current.Rarity > 9) {
+				err = fmt.Errorf("rarity %d out of range [1,9]", current.Rarity)
+			}
+		case "fallback":
+			for _, name := range strings.Split(rest, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					current.Fallback = append(current.Fallback, name)
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown directive %q", directive)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	null := sp.Probe(TCP, "NULL")
+	for _, probe := range sp.Probes {
+		for _, name := range probe.Fallback {
+			if fb := sp.Probe(probe.Protocol, name); fb != nil {
+				probe.fallback = append(probe.fallback, fb)
+			}
+		}
+		// TCP probes implicitly fall back to the NULL probe last, since the
+		// server may have sent its banner before reading the probe.
+		if probe.Protocol == TCP && null != nil && probe != null {
+			probe.fallback = append(probe.fallback, null)
+		}
+	}
+	return sp, nil
+}
+
+func splitDirective(line string) (string, string) {
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i+1:])
+}
+
+func (sp *ServiceProbes) parseExclude(rest string) error {
+	var tcp, udp []string
+	for _, item := range strings.Split(rest, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case strings.HasPrefix(item, "T:"):
+			tcp = append(tcp, item[2:])
+		case strings.HasPrefix(item, "U:"):
+			udp = append(udp, item[2:])
+		default:
+			tcp = append(tcp, item)
+			udp = append(udp, item)
+		}
+	}
+	var err error
+	if sp.Exclude, err = ParsePortSet(strings.Join(tcp, ",")); err != nil {
+		return err
+	}
+	sp.UDPExclude, err = ParsePortSet(strings.Join(udp, ","))
+	return err
+}
+
+func parseMillis(s string) (time.Duration, error) {
+	ms, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// parseProbe parses the arguments of a Probe directive:
+//
+//	<protocol> <probename> q|<probestring>| [no-payload]
+func parseProbe(rest string) (*Probe, error) {
+	fields := strings.SplitN(rest, " ", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("malformed Probe directive: %q", rest)
+	}
+	probe := &Probe{
+		Protocol:  Protocol(fields[0]),
+		Name:      fields[1],
+		Rarity:    DefaultRarity,
+		TotalWait: DefaultTotalWait,
+	}
+	if probe.Protocol != TCP && probe.Protocol != UDP {
+		return nil, fmt.Errorf("unknown probe protocol %q", fields[0])
+	}
+	spec := fields[2]
+	if len(spec) < 3 || spec[0] != 'q' {
+		return nil, fmt.Errorf("malformed probe string: %q", spec)
+	}
+	body, tail, err := splitDelimited(spec[1:])
+	if err != nil {
+		return nil, err
+	}
+	if probe.Data, err = unescape(body); err != nil {
+		return nil, err
+	}
+	probe.NoPayload = strings.TrimSpace(tail) == "no-payload"
+	return probe, nil
+}
+
+// splitDelimited takes a string whose first character is a delimiter, and
+// returns the text up to the next occurrence of that delimiter along with the
+// remainder of the string following it.
+func splitDelimited(s string) (string, string, error) {
+	if len(s) < 2 {
+		return "", "", fmt.Errorf("unterminated delimited string: %q", s)
+	}
+	end := strings.IndexByte(s[1:], s[0])
+	if end < 0 {
+		return "", "", fmt.Errorf("unterminated delimited string: %q", s)
+	}
+	return s[1 : end+1], s[end+2:], nil
+}
+
+// unescape decodes the C-style escapes used in probe strings.
+func unescape(s string) ([]byte, error) {
+	ret := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			ret = append(ret, s[i])
+			continue
+		}
+		i++
+		if i >= len(s) {
+			return nil, fmt.Errorf("trailing backslash in %q", s)
+		}
+		switch s[i] {
+		case '0':
+			ret = append(ret, 0)
+		case 'a':
+			ret = append(ret, '\a')
+		case 'b':
+			ret = append(ret, '\b')
+		case 'f':
+			ret = append(ret, '\f')
+		case 'n':
+			ret = append(ret, '\n')
+		case 'r':
+			ret = append(ret, '\r')
+		case 't':
+			ret = append(ret, '\t')
+		case 'v':
+			ret = append(ret, '\v')
+		case 'x':
+			if i+2 >= len(s) {
+				return nil, fmt.Errorf("truncated \\x escape in %q", s)
+			}
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("bad \\x escape in %q: %v", s, err)
+			}
+			ret = append(ret, byte(v))
+			i += 2
+		default:
+			ret = append(ret, s[i])
+		}
+	}
+	return ret, nil
+}
+
+// PortSet is a set of ports, as given in ports/sslports/Exclude directives.
+type PortSet []PortRange
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	Low, High uint
+}
+
+// ParsePortSet parses a comma-separated list of ports and port ranges, e.g.
+// "21,80-90,443".
+func ParsePortSet(s string) (PortSet, error) {
+	var ret PortSet
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		low, high := item, item
+		if i := strings.IndexByte(item, '-'); i >= 0 {
+			low, high = item[:i], item[i+1:]
+		}
+		l, err := strconv.ParseUint(low, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad port %q", item)
+		}
+		h, err := strconv.ParseUint(high, 10, 16)
+		if err != nil || h < l {
+			return nil, fmt.Errorf("bad port range %q", item)
+		}
+		ret = append(ret, PortRange{Low: uint(l), High: uint(h)})
+	}
+	return ret, nil
+}
+
+// Contains returns true if port is in the set.
+func (ps PortSet) Contains(port uint) bool {
+	for _, r := range ps {
+		if port >= r.Low && port <= r.High {
+			return true
+		}
+	}
+	return false
+}
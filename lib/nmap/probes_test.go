@@ -0,0 +1,210 @@
+package nmap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testProbes = `# Test database
+Exclude T:9100-9107,U:30000
+
+Probe TCP NULL q||
+totalwaitms 6000
+tcpwrappedms 3000
+match ftp m|^220 ProFTPD (\d[-.\w]+) Server| p/ProFTPD/ v/$1/ cpe:/a:proftpd:proftpd:$1/a
+match ssh m|^SSH-([\d.]+)-OpenSSH_([\w._-]+)[ -]| p/OpenSSH/ v/$SUBST(2,"_"," ")/ i/protocol $1/ cpe:/a:openbsd:openssh:$2/
+softmatch ftp m|^220[- ]|
+match bin m|^\x01\x02(..)|s p/Binary/ v/$I(1,">")/
+match broken m|(?<!|
+
+Probe TCP GetRequest q|GET / HTTP/1.0\r\n\r\n|
+rarity 1
+ports 80,8000-8100
+sslports 443
+match http m|^HTTP/1\.[01] \d\d\d .*\r\nServer: nginx/([\d.]+)|s p/nginx/ v/$1/ h/$P(1)/
+
+Probe TCP Rare q|\x00\xff|
+rarity 8
+fallback GetRequest
+
+Probe UDP DNSVersionBindReq q|\0\x06\x01\0\0\x01\0\0\0\0\0\0\x07version\x04bind\0\0\x10\0\x03|
+rarity 1
+ports 53
+match domain m|^\0\x06\x81\x80|
+`
+
+func loadTestProbes(t *testing.T) *ServiceProbes {
+	sp, err := ParseServiceProbes(strings.NewReader(testProbes))
+	if err != nil {
+		t.Fatalf("ParseServiceProbes: %v", err)
+	}
+	return sp
+}
+
+func TestParseServiceProbes(t *testing.T) {
+	sp := loadTestProbes(t)
+	if len(sp.Probes) != 4 {
+		t.Fatalf("expected 4 probes, got %d", len(sp.Probes))
+	}
+	if sp.Skipped != 1 {
+		t.Errorf("expected 1 skipped match, got %d", sp.Skipped)
+	}
+	if !sp.Exclude.Contains(9102) || sp.Exclude.Contains(30000) || !sp.UDPExclude.Contains(30000) {
+		t.Errorf("wrong Exclude sets: %v / %v", sp.Exclude, sp.UDPExclude)
+	}
+
+	null := sp.Probe(TCP, "NULL")
+	if null == nil || len(null.Data) != 0 || null.TotalWait != 6*time.Second || null.TCPWrapped != 3*time.Second {
+		t.Fatalf("bad NULL probe: %+v", null)
+	}
+	if len(null.Matches) != 4 || !null.Matches[2].Soft {
+		t.Errorf("bad NULL matches: %d", len(null.Matches))
+	}
+
+	get := sp.Probe(TCP, "GetRequest")
+	if string(get.Data) != "GET / HTTP/1.0\r\n\r\n" || get.Rarity != 1 || !get.Ports.Contains(8050) || !get.SSLPorts.Contains(443) {
+		t.Errorf("bad GetRequest probe: %+v", get)
+	}
+
+	dns := sp.Probe(UDP, "DNSVersionBindReq")
+	if len(dns.Data) != 30 || dns.Data[2] != 1 || dns.TotalWait != DefaultTotalWait {
+		t.Errorf("bad DNSVersionBindReq probe: %q", dns.Data)
+	}
+	if len(dns.Fallbacks()) != 0 {
+		t.Errorf("UDP probes must not fall back to NULL")
+	}
+
+	rare := sp.Probe(TCP, "Rare")
+	if fb := rare.Fallbacks(); len(fb) != 2 || fb[0] != get || fb[1] != null {
+		t.Errorf("bad fallbacks for Rare: %v", fb)
+	}
+}
+
+func TestParseServiceProbesErrors(t *testing.T) {
+	bad := []string{
+		"match ftp m|^220|",
+		"Probe SCTP NULL q||",
+		"Probe TCP NULL q|",
+		"Probe TCP NULL q||\nrarity 10",
+		"Probe TCP NULL q||\nports 80-",
+		"Probe TCP NULL q||\nfrobnicate 1",
+		"Probe TCP NULL q||\nmatch ftp m|^220| x/foo/",
+	}
+	for _, input := range bad {
+		if _, err := ParseServiceProbes(strings.NewReader(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestMatchBanner(t *testing.T) {
+	sp := loadTestProbes(t)
+	tests := []struct {
+		hint     string
+		protocol Protocol
+		banner   string
+		expected *ServiceInfo
+	}{
+		{
+			banner:   "220 ProFTPD 1.3.5e Server (Debian) [::ffff:10.0.0.1]\r\n",
+			expected: &ServiceInfo{Service: "ftp", Product: "ProFTPD", Version: "1.3.5e", CPE: []string{"cpe:/a:proftpd:proftpd:1.3.5e"}, Probe: "NULL"},
+		},
+		{
+			banner:   "220 Welcome to some FTP\r\n",
+			expected: &ServiceInfo{Service: "ftp", Probe: "NULL", SoftMatch: true},
+		},
+		{
+			banner:   "SSH-2.0-OpenSSH_7.4p1_Debian-10 \r\n",
+			expected: &ServiceInfo{Service: "ssh", Product: "OpenSSH", Version: "7.4p1 Debian-10", Info: "protocol 2.0", CPE: []string{"cpe:/a:openbsd:openssh:7.4p1_Debian-10"}, Probe: "NULL"},
+		},
+		{
+			banner:   "\x01\x02\x01\x00",
+			expected: &ServiceInfo{Service: "bin", Product: "Binary", Version: "256", Probe: "NULL"},
+		},
+		{
+			hint:     "GetRequest",
+			banner:   "HTTP/1.1 200 OK\r\nServer: nginx/1.18.0\r\n\r\n",
+			expected: &ServiceInfo{Service: "http", Product: "nginx", Version: "1.18.0", Hostname: "1.18.0", Probe: "GetRequest"},
+		},
+		{
+			// Without a hint, every probe is eventually tried.
+			banner:   "HTTP/1.1 200 OK\r\nServer: nginx/1.18.0\r\n\r\n",
+			expected: &ServiceInfo{Service: "http", Product: "nginx", Version: "1.18.0", Hostname: "1.18.0", Probe: "GetRequest"},
+		},
+		{
+			protocol: UDP,
+			banner:   "\x00\x06\x81\x80\x00\x01",
+			expected: &ServiceInfo{Service: "domain", Probe: "DNSVersionBindReq"},
+		},
+		{
+			banner:   "nothing to see here",
+			expected: nil,
+		},
+	}
+	for _, test := range tests {
+		protocol := test.protocol
+		if protocol == "" {
+			protocol = TCP
+		}
+		info := sp.MatchBanner(protocol, test.hint, []byte(test.banner))
+		if !reflect.DeepEqual(info, test.expected) {
+			t.Errorf("MatchBanner(%q): got %+v, expected %+v", test.banner, info, test.expected)
+		}
+	}
+}
+
+func TestMatchResponseFallback(t *testing.T) {
+	sp := loadTestProbes(t)
+	rare := sp.Probe(TCP, "Rare")
+	info := MatchResponse(rare, []byte("HTTP/1.0 404 Not Found\r\nServer: nginx/1.2.3\r\n\r\n"))
+	if info == nil || info.Service != "http" || info.Version != "1.2.3" {
+		t.Errorf("expected fallback match to GetRequest, got %+v", info)
+	}
+	info = MatchResponse(rare, []byte("220 ProFTPD 1.3.5 Server\r\n"))
+	if info == nil || info.Service != "ftp" || info.Probe != "NULL" {
+		t.Errorf("expected implicit fallback to NULL, got %+v", info)
+	}
+}
+
+func TestProbesByRarity(t *testing.T) {
+	sp := loadTestProbes(t)
+	names := func(probes []*Probe) []string {
+		var ret []string
+		for _, p := range probes {
+			ret = append(ret, p.Name)
+		}
+		return ret
+	}
+	if got := names(sp.ProbesByRarity(TCP, 22, false, 7)); !reflect.DeepEqual(got, []string{"NULL", "GetRequest"}) {
+		t.Errorf("wrong probes for 22/tcp: %v", got)
+	}
+	if got := names(sp.ProbesByRarity(TCP, 8080, false, 9)); !reflect.DeepEqual(got, []string{"NULL", "GetRequest", "Rare"}) {
+		t.Errorf("wrong probes for 8080/tcp: %v", got)
+	}
+	if got := names(sp.ProbesByRarity(UDP, 53, false, 0)); !reflect.DeepEqual(got, []string{"DNSVersionBindReq"}) {
+		t.Errorf("wrong probes for 53/udp: %v", got)
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	groups := [][]byte{[]byte("all"), []byte("a\x01b"), []byte("\x01\x00"), []byte("1_2_3"), []byte("4,5,6")}
+	tests := map[string]string{
+		"plain":                          "plain",
+		"v$1":                            "va\x01b",
+		"$P(1)":                          "ab",
+		"$I(2,\"<\")":                    "1",
+		"$I(2,\">\")":                    "256",
+		"$SUBST(3,\"_\",\".\")":          "1.2.3",
+		"$SUBST(4,\",\",\".\")":          "4.5.6",
+		"$P(1) $SUBST(4, \",\", \", \")": "ab 4, 5, 6",
+		"$9":                             "",
+		"$X":                             "$X",
+	}
+	for template, expected := range tests {
+		if got := substitute(template, groups); got != expected {
+			t.Errorf("substitute(%q) = %q, expected %q", template, got, expected)
+		}
+	}
+}
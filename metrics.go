@@ -0,0 +1,132 @@
+package zgrab2
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics, served on --prometheus.
+var (
+	scansTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "zgrab2",
+		Name:      "scans_total",
+		Help:      "Number of scans, by scanner name and status.",
+	}, []string{"scanner", "status"})
+
+	scanDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "zgrab2",
+		Name:      "scan_duration_seconds",
+		Help:      "Duration of the Scan call of each scanner.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"scanner"})
+
+	scanBytesRead = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "zgrab2",
+		Name:      "scan_bytes_read",
+		Help:      "Bytes read in a scan, over the connections opened through the ScanTarget.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"scanner"})
+
+	scanBytesWritten = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "zgrab2",
+		Name:      "scan_bytes_written",
+		Help:      "Bytes written in a scan, over the connections opened through the ScanTarget.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"scanner"})
+
+	workersBusy = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "zgrab2",
+		Name:      "workers_busy",
+		Help:      "Number of workers scanning a target.",
+	})
+)
+
+// Names of the queues whose length is exported.
+const (
+	queueProcess = "process"
+	queueMatch   = "match"
+	queueOutput  = "output"
+)
+
+// queueLength is the length function of one instance of a queue.
+type queueLength struct {
+	length func() int
+}
+
+// queueLengths holds the functions returning the length of the queues of the
+// running engines, by queue name.
+var queueLengths struct {
+	sync.Mutex
+	funcs map[string]map[*queueLength]struct{}
+}
+
+// addQueueLength adds the length of a queue to the total of the named queue,
+// until the returned function is called.
+func addQueueLength(name string, length func() int) (remove func()) {
+	entry := &queueLength{length: length}
+	queueLengths.Lock()
+	defer queueLengths.Unlock()
+	if queueLengths.funcs[name] == nil {
+		queueLengths.funcs[name] = make(map[*queueLength]struct{})
+	}
+	queueLengths.funcs[name][entry] = struct{}{}
+	return func() {
+		queueLengths.Lock()
+		defer queueLengths.Unlock()
+		delete(queueLengths.funcs[name], entry)
+	}
+}
+
+func newQueueLengthGauge(name string) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   "zgrab2",
+		Name:        "queue_length",
+		Help:        "Number of items waiting in each queue.",
+		ConstLabels: prometheus.Labels{"queue": name},
+	}, func() float64 {
+		queueLengths.Lock()
+		defer queueLengths.Unlock()
+		total := 0
+		for entry := range queueLengths.funcs[name] {
+			total += entry.length()
+		}
+		return float64(total)
+	})
+}
+
+func init() {
+	queueLengths.funcs = make(map[string]map[*queueLength]struct{})
+	prometheus.MustRegister(
+		scansTotal,
+		scanDuration,
+		scanBytesRead,
+		scanBytesWritten,
+		workersBusy,
+		newQueueLengthGauge(queueProcess),
+		newQueueLengthGauge(queueMatch),
+		newQueueLengthGauge(queueOutput),
+	)
+}
+
+// scanStats accumulates the traffic of the connections opened for a scan.
+type scanStats struct {
+	bytesRead    int64
+	bytesWritten int64
+}
+
+// add records the traffic of a closed connection.
+func (s *scanStats) add(read, written int) {
+	atomic.AddInt64(&s.bytesRead, int64(read))
+	atomic.AddInt64(&s.bytesWritten, int64(written))
+}
+
+// observeScan records the outcome of a scan in the metrics.
+func observeScan(scanner string, status ScanStatus, duration time.Duration, stats *scanStats) {
+	scansTotal.WithLabelValues(scanner, string(status)).Inc()
+	scanDuration.WithLabelValues(scanner).Observe(duration.Seconds())
+	scanBytesRead.WithLabelValues(scanner).Observe(float64(atomic.LoadInt64(&stats.bytesRead)))
+	scanBytesWritten.WithLabelValues(scanner).Observe(float64(atomic.LoadInt64(&stats.bytesWritten)))
+}
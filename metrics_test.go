@@ -0,0 +1,93 @@
+package zgrab2
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// echoScanner writes a greeting to the target and reads the reply.
+type echoScanner struct {
+	name string
+}
+
+func (s *echoScanner) Init(flags ScanFlags) error       { return nil }
+func (s *echoScanner) InitPerSender(senderID int) error { return nil }
+func (s *echoScanner) GetName() string                  { return s.name }
+func (s *echoScanner) GetTrigger() string               { return "" }
+func (s *echoScanner) Protocol() string                 { return "echo" }
+func (s *echoScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	conn, err := t.Open(&BaseFlags{Timeout: time.Second})
+	if err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	return SCAN_SUCCESS, string(buf[:n]), nil
+}
+
+// histogramSum returns the sample sum of the histogram for scanner.
+func histogramSum(t *testing.T, vec *prometheus.HistogramVec, scanner string) float64 {
+	var m dto.Metric
+	if err := vec.WithLabelValues(scanner).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleSum()
+}
+
+func TestRunScannerMetrics(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 16)
+			n, _ := conn.Read(buf)
+			conn.Write(append([]byte("reply to "), buf[:n]...))
+			conn.Close()
+		}
+	}()
+	port := uint(listener.Addr().(*net.TCPAddr).Port)
+
+	var wg sync.WaitGroup
+	mon := MakeMonitor(10, &wg)
+	defer func() {
+		mon.Stop()
+		wg.Wait()
+	}()
+	scanner := &echoScanner{name: "metrics-test"}
+	RunScanner(scanner, mon, ScanTarget{IP: net.ParseIP("127.0.0.1"), Port: &port})
+	listener.Close()
+	RunScanner(scanner, mon, ScanTarget{IP: net.ParseIP("127.0.0.1"), Port: &port})
+
+	if n := testutil.ToFloat64(scansTotal.WithLabelValues("metrics-test", string(SCAN_SUCCESS))); n != 1 {
+		t.Errorf("expected 1 successful scan, got %v", n)
+	}
+	if n := testutil.ToFloat64(scansTotal.WithLabelValues("metrics-test", string(SCAN_CONNECTION_TIMEOUT))); n != 1 {
+		t.Errorf("expected 1 failed scan, got %v", n)
+	}
+	if n := histogramSum(t, scanBytesWritten, "metrics-test"); n != 5 {
+		t.Errorf("expected 5 bytes written, got %v", n)
+	}
+	if n := histogramSum(t, scanBytesRead, "metrics-test"); n != 14 {
+		t.Errorf("expected 14 bytes read, got %v", n)
+	}
+}
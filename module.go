@@ -1,7 +1,10 @@
 package zgrab2
 
 import (
+	"context"
 	"time"
+
+	"github.com/zmap/zgrab2/lib/nmap"
 )
 
 // Scanner is an interface that represents all functions necessary to run a scan
@@ -26,6 +29,17 @@ type Scanner interface {
 	Scan(t ScanTarget) (ScanStatus, interface{}, error)
 }
 
+// ContextScanner is a Scanner that can be cancelled. When a scanner
+// implements it, the framework calls ScanContext instead of Scan, with a
+// context that is done when the scan is abandoned or runs out of
+// --target-timeout. The scanner should stop and return promptly then.
+type ContextScanner interface {
+	Scanner
+
+	// ScanContext is Scan, bound to ctx.
+	ScanContext(ctx context.Context, t ScanTarget) (ScanStatus, interface{}, error)
+}
+
 // ScanResponse is the result of a scan on a single host
 type ScanResponse struct {
 	// Status is required for all responses.
@@ -38,6 +52,18 @@ type ScanResponse struct {
 	Result    interface{} `json:"result,omitempty"`
 	Timestamp string      `json:"timestamp,omitempty"`
 	Error     *string     `json:"error,omitempty"`
+
+	// Attempts is the number of times the scan was tried, if --retries is
+	// enabled for the scanner.
+	Attempts int `json:"attempts,omitempty"`
+
+	// Transcript holds the data read and written on the connections of the
+	// scan, with --transcript.
+	Transcript *Transcript `json:"transcript,omitempty" zgrab:"debug"`
+
+	// Service is the service / version detected by matching the result
+	// against the nmap-service-probes database, if enabled.
+	Service *nmap.ServiceInfo `json:"service,omitempty"`
 }
 
 // ScanModule is an interface which represents a module that the framework can
@@ -74,6 +100,8 @@ type BaseFlags struct {
 	Timeout        time.Duration `short:"t" long:"timeout" description:"Set connection timeout (0 = no timeout)" default:"10s"`
 	Trigger        string        `short:"g" long:"trigger" description:"Invoke only on targets with specified tag"`
 	BytesReadLimit int           `short:"m" long:"maxbytes" description:"Maximum byte read limit per scan (0 = defaults)"`
+	IfSuccess      []string      `long:"if-success" description:"Invoke only on targets where the named scanner, run before this one, succeeded (can be repeated)"`
+	IfMatch        []string      `long:"if-match" description:"Invoke only on targets where the banner of a scanner run before this one matches a regular expression, as NAME:REGEXP (can be repeated)"`
 }
 
 // UDPFlags contains the common options used for all UDP scans
@@ -45,6 +45,22 @@ type Scanner struct {
 type Results struct {
 	Banner string `json:"banner,omitempty"`
 	Length int    `json:"length,omitempty"`
+
+	// raw is the undecoded banner, for service matching when --hex is set.
+	raw   []byte
+	probe string
+}
+
+// ServiceBanner implements zgrab2.ServiceBanner. An empty probe corresponds
+// to nmap's NULL probe; anything else has no known equivalent.
+func (results *Results) ServiceBanner() ([]byte, string) {
+	if results == nil {
+		return nil, ""
+	}
+	if len(results.probe) == 0 {
+		return results.raw, "NULL"
+	}
+	return results.raw, ""
 }
 
 // RegisterModule is called by modules/banner.go to register the scanner.
@@ -76,6 +92,12 @@ func (scanner *Scanner) Protocol() string {
 	return "banner"
 }
 
+// NoRetry disables the framework retries when --max-tries is set, as the
+// scanner retries failed connections itself.
+func (scanner *Scanner) NoRetry() bool {
+	return scanner.config.MaxTries > 1
+}
+
 // InitPerSender initializes the scanner for a given sender.
 func (scanner *Scanner) InitPerSender(senderID int) error {
 	return nil
@@ -186,6 +208,8 @@ func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, inter
 	results := Results{
 		Banner: string(ret),
 		Length: len(ret),
+		raw:    ret,
+		probe:  string(scanner.probe),
 	}
 	if scanner.config.Hex {
 		results.Banner = hex.EncodeToString(ret)
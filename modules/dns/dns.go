@@ -0,0 +1,328 @@
+package dns
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ErrMismatchedResponse is returned when the response does not answer the
+// question asked.
+var ErrMismatchedResponse = errors.New("response does not match the query")
+
+// optionNSID is the EDNS0 option code of the name server identifier (RFC 5001).
+const optionNSID = 3
+
+// maxMessageSize is the largest DNS message, over TCP.
+const maxMessageSize = 65535
+
+// Response is a parsed DNS response.
+type Response struct {
+	// Name, Type and Class are those of the question.
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+
+	// RCode is the response code, such as NOERROR, REFUSED or NXDOMAIN,
+	// including its EDNS0 extension.
+	RCode string `json:"rcode,omitempty"`
+
+	Authoritative      bool `json:"authoritative"`
+	Truncated          bool `json:"truncated"`
+	RecursionAvailable bool `json:"recursion_available"`
+	AuthenticData      bool `json:"authentic_data"`
+
+	Answers     []Record `json:"answers,omitempty"`
+	Authorities []Record `json:"authorities,omitempty"`
+	Additionals []Record `json:"additionals,omitempty"`
+
+	// Error is the reason the query failed, if it did.
+	Error string `json:"error,omitempty"`
+
+	// opt is the OPT pseudo-record of the response, if any.
+	opt *dnsmessage.Resource
+}
+
+// Record is a resource record, with the fields of its type.
+type Record struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Class string `json:"class"`
+	TTL   uint32 `json:"ttl"`
+
+	// Address is the address of an A or AAAA record.
+	Address string `json:"address,omitempty"`
+
+	// Target is the name a CNAME, NS, PTR, MX or SRV record points to.
+	Target string `json:"target,omitempty"`
+
+	// Preference is the preference of an MX record, or the priority of an
+	// SRV record.
+	Preference uint16 `json:"preference,omitempty"`
+
+	// TXT holds the strings of a TXT record.
+	TXT []string `json:"txt,omitempty"`
+
+	// SOA holds the fields of an SOA record.
+	SOA *SOA `json:"soa,omitempty"`
+
+	// Data is the raw data of the records of other types.
+	Data []byte `json:"data,omitempty"`
+}
+
+// SOA is the start of authority of a zone.
+type SOA struct {
+	NS      string `json:"ns"`
+	MBox    string `json:"mbox"`
+	Serial  uint32 `json:"serial"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	MinTTL  uint32 `json:"min_ttl"`
+}
+
+// query describes a query to send.
+type query struct {
+	name         string
+	qtype        dnsmessage.Type
+	class        dnsmessage.Class
+	recursion    bool
+	edns         bool
+	bufferSize   uint16
+	dnssec, nsid bool
+}
+
+// encode returns the query message with the given ID.
+func (q *query) encode(id uint16) ([]byte, error) {
+	name, err := dnsmessage.NewName(fqdn(q.name))
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: q.recursion},
+		Questions: []dnsmessage.Question{{Name: name, Type: q.qtype, Class: q.class}},
+	}
+	if q.edns {
+		var opt dnsmessage.ResourceHeader
+		if err := opt.SetEDNS0(int(q.bufferSize), dnsmessage.RCodeSuccess, q.dnssec); err != nil {
+			return nil, err
+		}
+		body := &dnsmessage.OPTResource{}
+		if q.nsid {
+			body.Options = append(body.Options, dnsmessage.Option{Code: optionNSID})
+		}
+		msg.Additionals = append(msg.Additionals, dnsmessage.Resource{Header: opt, Body: body})
+	}
+	return msg.Pack()
+}
+
+// exchange sends the query on conn and returns its response, skipping the
+// messages with other IDs. Over TCP, the messages are prefixed by their
+// length.
+func (q *query) exchange(conn net.Conn, id uint16, tcp bool) (*Response, error) {
+	packed, err := q.encode(id)
+	if err != nil {
+		return nil, err
+	}
+	if tcp {
+		packed = append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...)
+	}
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		var n int
+		if tcp {
+			if _, err = io.ReadFull(conn, buf[:2]); err == nil {
+				n = int(binary.BigEndian.Uint16(buf))
+				_, err = io.ReadFull(conn, buf[:n])
+			}
+		} else {
+			n, err = conn.Read(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n < 2 || binary.BigEndian.Uint16(buf) != id {
+			continue
+		}
+		return q.decode(buf[:n])
+	}
+}
+
+// decode parses the response to the query.
+func (q *query) decode(b []byte) (*Response, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(b); err != nil {
+		return nil, err
+	}
+	res := &Response{
+		Name:               q.name,
+		Type:               typeName(q.qtype),
+		Class:              className(q.class),
+		Authoritative:      msg.Authoritative,
+		Truncated:          msg.Truncated,
+		RecursionAvailable: msg.RecursionAvailable,
+		AuthenticData:      msg.AuthenticData,
+		Answers:            records(msg.Answers),
+		Authorities:        records(msg.Authorities),
+	}
+	rcode := msg.RCode
+	for i, r := range msg.Additionals {
+		if r.Header.Type == dnsmessage.TypeOPT {
+			res.opt = &msg.Additionals[i]
+			rcode = r.Header.ExtendedRCode(rcode)
+			continue
+		}
+		res.Additionals = append(res.Additionals, record(r))
+	}
+	res.RCode = rcodeName(rcode)
+	if !msg.Response || len(msg.Questions) != 1 || msg.Questions[0].Type != q.qtype ||
+		!strings.EqualFold(msg.Questions[0].Name.String(), fqdn(q.name)) {
+		return res, ErrMismatchedResponse
+	}
+	return res, nil
+}
+
+// records returns the records of a section.
+func records(resources []dnsmessage.Resource) []Record {
+	var ret []Record
+	for _, r := range resources {
+		ret = append(ret, record(r))
+	}
+	return ret
+}
+
+// record returns the fields of a resource record.
+func record(r dnsmessage.Resource) Record {
+	ret := Record{
+		Name:  r.Header.Name.String(),
+		Type:  typeName(r.Header.Type),
+		Class: className(r.Header.Class),
+		TTL:   r.Header.TTL,
+	}
+	switch body := r.Body.(type) {
+	case *dnsmessage.AResource:
+		ret.Address = net.IP(body.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		ret.Address = net.IP(body.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		ret.Target = body.CNAME.String()
+	case *dnsmessage.NSResource:
+		ret.Target = body.NS.String()
+	case *dnsmessage.PTRResource:
+		ret.Target = body.PTR.String()
+	case *dnsmessage.MXResource:
+		ret.Target, ret.Preference = body.MX.String(), body.Pref
+	case *dnsmessage.SRVResource:
+		ret.Target, ret.Preference = body.Target.String(), body.Priority
+	case *dnsmessage.TXTResource:
+		ret.TXT = body.TXT
+	case *dnsmessage.SOAResource:
+		ret.SOA = &SOA{
+			NS:      body.NS.String(),
+			MBox:    body.MBox.String(),
+			Serial:  body.Serial,
+			Refresh: body.Refresh,
+			Retry:   body.Retry,
+			Expire:  body.Expire,
+			MinTTL:  body.MinTTL,
+		}
+	case *dnsmessage.UnknownResource:
+		ret.Data = body.Data
+	}
+	return ret
+}
+
+// fqdn returns name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// typeNames are the mnemonics of the DNSSEC and other record types unknown
+// to dnsmessage.
+var typeNames = map[dnsmessage.Type]string{
+	43:  "DS",
+	46:  "RRSIG",
+	47:  "NSEC",
+	48:  "DNSKEY",
+	50:  "NSEC3",
+	51:  "NSEC3PARAM",
+	52:  "TLSA",
+	64:  "SVCB",
+	65:  "HTTPS",
+	257: "CAA",
+}
+
+// typeName returns the mnemonic of a record type, such as AAAA.
+func typeName(t dnsmessage.Type) string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	if name := t.String(); strings.HasPrefix(name, "Type") {
+		return strings.TrimPrefix(name, "Type")
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
+
+// className returns the mnemonic of a class, such as IN or CH.
+func className(c dnsmessage.Class) string {
+	switch c {
+	case dnsmessage.ClassINET:
+		return "IN"
+	case dnsmessage.ClassCHAOS:
+		return "CH"
+	case dnsmessage.ClassHESIOD:
+		return "HS"
+	case dnsmessage.ClassANY:
+		return "ANY"
+	}
+	return fmt.Sprintf("CLASS%d", c)
+}
+
+// rcodeNames are the mnemonics of the response codes (RFC 6895).
+var rcodeNames = map[dnsmessage.RCode]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+	16: "BADVERS",
+	23: "BADCOOKIE",
+}
+
+// rcodeName returns the mnemonic of a response code.
+func rcodeName(rcode dnsmessage.RCode) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// optionText returns the data of an option as text if it is printable, or
+// in hex otherwise.
+func optionText(data []byte) string {
+	for _, r := range string(data) {
+		if r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return hex.EncodeToString(data)
+		}
+	}
+	return string(data)
+}
@@ -0,0 +1,317 @@
+// Package dns provides a zgrab2 module that probes DNS servers.
+// NOTE: by default, this scans on UDP; --tcp sends the queries over TCP.
+//
+// Each query is sent over its own connection:
+//  1. A recursive query for --recursion-name, to find open resolvers.
+//  2. An EDNS0 query for the NS records of --edns-name with the DO bit and
+//     the NSID option, reporting the advertised UDP payload size, the name
+//     server identifier and whether DNSSEC records are returned.
+//  3. CHAOS TXT queries for each of --chaos-names (version.bind,
+//     hostname.bind and id.server by default).
+//
+// The output holds the parsed responses, with their response codes.
+package dns
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Flags holds the command-line flags for the scanner.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.UDPFlags
+
+	UseTCP        bool   `long:"tcp" description:"Send the queries over TCP instead of UDP"`
+	RecursionName string `long:"recursion-name" default:"example.com" description:"Name queried with recursion desired, to detect open resolvers"`
+	EDNSName      string `long:"edns-name" default:"." description:"Name whose NS records are queried with EDNS0"`
+	BufferSize    uint16 `long:"edns-buffer-size" default:"4096" description:"UDP payload size advertised in the EDNS0 query"`
+	ChaosNames    string `long:"chaos-names" default:"version.bind,hostname.bind,id.server" description:"Comma-separated names queried as CHAOS TXT records"`
+	SkipRecursion bool   `long:"skip-recursion" description:"If set, don't send the recursive query"`
+	SkipEDNS      bool   `long:"skip-edns" description:"If set, don't send the EDNS0 query"`
+	SkipChaos     bool   `long:"skip-chaos" description:"If set, don't send the CHAOS queries"`
+}
+
+// Results is the output of the scan.
+type Results struct {
+	// Recursion is the response to the recursive query.
+	Recursion *Response `json:"recursion,omitempty"`
+
+	// OpenResolver is set if the server answered the recursive query with
+	// recursion available.
+	OpenResolver bool `json:"open_resolver"`
+
+	// EDNS is the result of the EDNS0 query.
+	EDNS *EDNS `json:"edns,omitempty"`
+
+	// Chaos holds the responses to the CHAOS TXT queries.
+	Chaos []*Response `json:"chaos,omitempty"`
+
+	// Version is the answer to version.bind, and Hostname the answer to
+	// hostname.bind or, failing that, id.server.
+	Version  string `json:"version,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// EDNS is the result of the EDNS0 query.
+type EDNS struct {
+	// Response is the response to the query.
+	Response *Response `json:"response,omitempty"`
+
+	// Supported is set if the response has an OPT record, whose fields
+	// follow.
+	Supported bool `json:"supported"`
+
+	// Version is the EDNS version of the response.
+	Version uint8 `json:"version"`
+
+	// UDPSize is the UDP payload size advertised by the server.
+	UDPSize uint16 `json:"udp_size,omitempty"`
+
+	// DO is set if the server set the DNSSEC OK bit in its response.
+	DO bool `json:"do"`
+
+	// NSID is the name server identifier, as text if it is printable, or in
+	// hex otherwise.
+	NSID string `json:"nsid,omitempty"`
+
+	// DNSSEC is set if the response contains RRSIG records.
+	DNSSEC bool `json:"dnssec"`
+}
+
+// Module is the zgrab2 module implementation
+type Module struct {
+}
+
+// Scanner holds the state for a single scan
+type Scanner struct {
+	config *Flags
+}
+
+// RegisterModule registers the module with zgrab2
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("dns", "DNS", module.Description(), 53, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a flags instant to be populated with the command line args
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new DNS scanner instance
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Description returns an overview of this module.
+func (module *Module) Description() string {
+	return "Probe DNS servers for their version, open recursion and EDNS0 support"
+}
+
+// Validate checks that the flags are valid
+func (cfg *Flags) Validate(args []string) error {
+	if cfg.SkipRecursion && cfg.SkipEDNS && cfg.SkipChaos {
+		log.Errorln("All the queries are skipped")
+		return zgrab2.ErrInvalidArguments
+	}
+	if cfg.BufferSize < 512 {
+		log.Errorf("--edns-buffer-size must be at least 512, given %d", cfg.BufferSize)
+		return zgrab2.ErrInvalidArguments
+	}
+	for _, name := range append(cfg.chaosNames(), cfg.RecursionName, cfg.EDNSName) {
+		if _, err := dnsmessage.NewName(fqdn(name)); err != nil {
+			log.Errorf("invalid name %q: %s", name, err)
+			return zgrab2.ErrInvalidArguments
+		}
+	}
+	return nil
+}
+
+// Help returns the module's help string
+func (cfg *Flags) Help() string {
+	return ""
+}
+
+// chaosNames returns the names of the CHAOS queries.
+func (cfg *Flags) chaosNames() []string {
+	var names []string
+	if cfg.SkipChaos {
+		return nil
+	}
+	for _, name := range strings.Split(cfg.ChaosNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Init initialized the scanner
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// Protocol returns the protocol identifer for the scanner.
+func (scanner *Scanner) Protocol() string {
+	return "dns"
+}
+
+// GetName returns the module's name
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// send sends a query over a new connection and returns the response. The
+// failures after connecting are recorded in the response.
+func (scanner *Scanner) send(target *zgrab2.ScanTarget, q *query) (*Response, error) {
+	var conn net.Conn
+	var err error
+	if scanner.config.UseTCP {
+		conn, err = target.Open(&scanner.config.BaseFlags)
+	} else {
+		conn, err = target.OpenUDP(&scanner.config.BaseFlags, &scanner.config.UDPFlags)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	res, err := q.exchange(conn, uint16(rand.Intn(1<<16)), scanner.config.UseTCP)
+	if res == nil {
+		res = &Response{Name: q.name, Type: typeName(q.qtype), Class: className(q.class)}
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res, err
+}
+
+// Scan sends the queries to the target, each over its own connection. It
+// succeeds if any of them is answered; otherwise, it fails with the error of
+// the first one.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	result := &Results{}
+	var firstErr error
+	answered := false
+	send := func(q *query) *Response {
+		res, err := scanner.send(&target, q)
+		if err == nil {
+			answered = true
+		} else if firstErr == nil {
+			firstErr = err
+		}
+		return res
+	}
+
+	if !scanner.config.SkipRecursion {
+		result.Recursion = send(&query{
+			name:      scanner.config.RecursionName,
+			qtype:     dnsmessage.TypeA,
+			class:     dnsmessage.ClassINET,
+			recursion: true,
+		})
+		if r := result.Recursion; r != nil && r.Error == "" {
+			result.OpenResolver = r.RecursionAvailable && r.RCode == "NOERROR" && len(r.Answers) > 0
+		}
+	}
+
+	if !scanner.config.SkipEDNS {
+		res := send(&query{
+			name:       scanner.config.EDNSName,
+			qtype:      dnsmessage.TypeNS,
+			class:      dnsmessage.ClassINET,
+			edns:       true,
+			bufferSize: scanner.config.BufferSize,
+			dnssec:     true,
+			nsid:       true,
+		})
+		if res != nil {
+			result.EDNS = newEDNS(res)
+		}
+	}
+
+	for _, name := range scanner.config.chaosNames() {
+		res := send(&query{name: name, qtype: dnsmessage.TypeTXT, class: dnsmessage.ClassCHAOS})
+		if res == nil {
+			continue
+		}
+		result.Chaos = append(result.Chaos, res)
+		text := chaosText(res)
+		switch strings.ToLower(strings.TrimSuffix(name, ".")) {
+		case "version.bind":
+			result.Version = text
+		case "hostname.bind", "id.server":
+			if result.Hostname == "" {
+				result.Hostname = text
+			}
+		}
+	}
+
+	if !answered {
+		if errors.Is(firstErr, ErrMismatchedResponse) {
+			return zgrab2.SCAN_PROTOCOL_ERROR, result, firstErr
+		}
+		if result.Recursion == nil && result.EDNS == nil && len(result.Chaos) == 0 {
+			return zgrab2.TryGetScanStatus(firstErr), nil, firstErr
+		}
+		return zgrab2.TryGetScanStatus(firstErr), result, firstErr
+	}
+	return zgrab2.SCAN_SUCCESS, result, nil
+}
+
+// newEDNS returns the EDNS0 support shown by a response. RRSIG records in its
+// answers or authorities show DNSSEC support.
+func newEDNS(res *Response) *EDNS {
+	ret := &EDNS{Response: res}
+	for _, r := range append(append([]Record(nil), res.Answers...), res.Authorities...) {
+		if r.Type == "RRSIG" {
+			ret.DNSSEC = true
+		}
+	}
+	if res.opt == nil {
+		return ret
+	}
+	ret.Supported = true
+	ret.UDPSize = uint16(res.opt.Header.Class)
+	ret.Version = uint8(res.opt.Header.TTL >> 16)
+	ret.DO = res.opt.Header.TTL&0x8000 != 0
+	if body, ok := res.opt.Body.(*dnsmessage.OPTResource); ok {
+		for _, option := range body.Options {
+			if option.Code == optionNSID && len(option.Data) > 0 {
+				ret.NSID = optionText(option.Data)
+			}
+		}
+	}
+	return ret
+}
+
+// chaosText returns the strings of the first TXT answer of a response.
+func chaosText(res *Response) string {
+	for _, r := range res.Answers {
+		if len(r.TXT) > 0 {
+			return strings.Join(r.TXT, "")
+		}
+	}
+	return ""
+}
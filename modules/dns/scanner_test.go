@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// reply answers a query as a recursive BIND server with an NSID and DNSSEC
+// would.
+func reply(t *testing.T, request []byte) []byte {
+	var query dnsmessage.Message
+	if err := query.Unpack(request); err != nil || len(query.Questions) != 1 {
+		t.Errorf("invalid query: %v", err)
+		return nil
+	}
+	q := query.Questions[0]
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.ID, Response: true, RecursionDesired: query.RecursionDesired, RecursionAvailable: true},
+		Questions: query.Questions,
+	}
+	header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: 300}
+	switch {
+	case q.Name.String() == "example.com." && q.Type == dnsmessage.TypeA:
+		msg.Answers = []dnsmessage.Resource{{Header: header, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}}}
+	case q.Name.String() == "." && q.Type == dnsmessage.TypeNS:
+		ns := dnsmessage.MustNewName("a.root-servers.net.")
+		msg.Answers = []dnsmessage.Resource{
+			{Header: header, Body: &dnsmessage.NSResource{NS: ns}},
+			{Header: dnsmessage.ResourceHeader{Name: q.Name, Type: 46, Class: q.Class}, Body: &dnsmessage.UnknownResource{Type: 46, Data: []byte{0, 2}}},
+		}
+		if len(query.Additionals) == 1 && query.Additionals[0].Header.DNSSECAllowed() {
+			var opt dnsmessage.ResourceHeader
+			opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, true)
+			body := &dnsmessage.OPTResource{Options: []dnsmessage.Option{{Code: optionNSID, Data: []byte("ns1.example")}}}
+			msg.Additionals = []dnsmessage.Resource{{Header: opt, Body: body}}
+		}
+	case q.Name.String() == "version.bind." && q.Class == dnsmessage.ClassCHAOS:
+		msg.Answers = []dnsmessage.Resource{{Header: header, Body: &dnsmessage.TXTResource{TXT: []string{"9.18.1"}}}}
+	default:
+		msg.RCode = dnsmessage.RCodeRefused
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Error(err)
+	}
+	return packed
+}
+
+// serveUDP answers the queries on a local UDP port, after a stray message
+// with another ID.
+func serveUDP(t *testing.T) uint {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			stray := []byte{buf[0] ^ 0xff, buf[1], 0x80, 0}
+			conn.WriteTo(stray, addr)
+			conn.WriteTo(reply(t, buf[:n]), addr)
+		}
+	}()
+	return uint(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// serveTCP answers the queries on a local TCP port.
+func serveTCP(t *testing.T) uint {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				request := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, request); err != nil {
+					return
+				}
+				response := reply(t, request)
+				conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(response))), response...))
+			}()
+		}
+	}()
+	return uint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func scan(t *testing.T, tcp bool) (zgrab2.ScanStatus, *Results, error) {
+	flags := &Flags{
+		BaseFlags:     zgrab2.BaseFlags{Timeout: 2 * time.Second, BytesReadLimit: 1 << 16},
+		UseTCP:        tcp,
+		RecursionName: "example.com",
+		EDNSName:      ".",
+		BufferSize:    4096,
+		ChaosNames:    "version.bind,hostname.bind",
+	}
+	if tcp {
+		flags.Port = serveTCP(t)
+	} else {
+		flags.Port = serveUDP(t)
+	}
+	scanner := new(Scanner)
+	scanner.Init(flags)
+	status, res, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	results, _ := res.(*Results)
+	return status, results, err
+}
+
+func TestScan(t *testing.T) {
+	for _, tcp := range []bool{false, true} {
+		status, results, err := scan(t, tcp)
+		if status != zgrab2.SCAN_SUCCESS || err != nil {
+			t.Fatalf("tcp %v: unexpected status %s, %v", tcp, status, err)
+		}
+		if !results.OpenResolver || results.Recursion.Answers[0].Address != "192.0.2.1" {
+			t.Errorf("tcp %v: expected an open resolver, got %+v", tcp, results.Recursion)
+		}
+		edns := results.EDNS
+		if !edns.Supported || edns.UDPSize != 1232 || !edns.DO || edns.NSID != "ns1.example" || !edns.DNSSEC {
+			t.Errorf("tcp %v: unexpected EDNS result %+v", tcp, edns)
+		}
+		if edns.Response.Answers[0].Target != "a.root-servers.net." || edns.Response.Answers[1].Type != "RRSIG" {
+			t.Errorf("tcp %v: unexpected EDNS answers %+v", tcp, edns.Response.Answers)
+		}
+		if results.Version != "9.18.1" || results.Hostname != "" || len(results.Chaos) != 2 {
+			t.Errorf("tcp %v: unexpected CHAOS results %+v", tcp, results.Chaos)
+		}
+		if rcode := results.Chaos[1].RCode; rcode != "REFUSED" || results.Chaos[1].Class != "CH" {
+			t.Errorf("tcp %v: unexpected hostname.bind response %+v", tcp, results.Chaos[1])
+		}
+	}
+}
+
+func TestDecodeMismatched(t *testing.T) {
+	q := &query{name: "example.com", qtype: dnsmessage.TypeA, class: dnsmessage.ClassINET}
+	other := &query{name: "example.org", qtype: dnsmessage.TypeA, class: dnsmessage.ClassINET}
+	packed, err := other.encode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed[2] |= 0x80 // response
+	if _, err := q.decode(packed); err != ErrMismatchedResponse {
+		t.Errorf("expected ErrMismatchedResponse, got %v", err)
+	}
+	if _, err := other.decode(packed); err != nil {
+		t.Error(err)
+	}
+}
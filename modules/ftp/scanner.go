@@ -43,6 +43,14 @@ type ScanResults struct {
 	TLSLog *zgrab2.TLSLog `json:"tls,omitempty"`
 }
 
+// ServiceBanner implements zgrab2.ServiceBanner.
+func (results *ScanResults) ServiceBanner() ([]byte, string) {
+	if results == nil {
+		return nil, ""
+	}
+	return []byte(results.Banner), "NULL"
+}
+
 // Flags are the FTP-specific command-line flags. Taken from the original zgrab.
 // (TODO: should FTPAuthTLS be on by default?).
 type Flags struct {
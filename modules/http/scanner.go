@@ -104,6 +104,15 @@ type Results struct {
 	RedirectResponseChain []*http.Response `json:"redirect_response_chain,omitempty"`
 }
 
+// ServiceBanner implements zgrab2.ServiceBanner; the banner is the final
+// response, which corresponds to nmap's GetRequest probe.
+func (results *Results) ServiceBanner() ([]byte, string) {
+	if results == nil {
+		return nil, ""
+	}
+	return []byte(results.Banner), "GetRequest"
+}
+
 // Module is an implementation of the zgrab2.Module interface.
 type Module struct {
 }
@@ -121,6 +130,7 @@ type Scanner struct {
 // scan holds the state for a single scan. This may entail multiple connections.
 // It is used to implement the zgrab2.Scanner interface.
 type scan struct {
+	ctx            context.Context
 	connections    []net.Conn
 	scanner        *Scanner
 	target         *zgrab2.ScanTarget
@@ -340,7 +350,7 @@ func (scan *scan) dialContext(ctx context.Context, network string, addr string)
 		}
 	}
 
-	timeoutContext, _ := context.WithTimeout(context.Background(), scan.scanner.config.Timeout)
+	timeoutContext, _ := context.WithTimeout(scan.ctx, scan.scanner.config.Timeout)
 
 	conn, err := dialer.DialContext(scan.withDeadlineContext(timeoutContext), network, addr)
 	if err != nil {
@@ -354,7 +364,7 @@ func (scan *scan) dialContext(ctx context.Context, network string, addr string)
 // zgrab2.GetTLSConnection()
 func (scan *scan) getTLSDialer(t *zgrab2.ScanTarget) func(network, addr string) (net.Conn, error) {
 	return func(network, addr string) (net.Conn, error) {
-		outer, err := scan.dialContext(context.Background(), network, addr)
+		outer, err := scan.dialContext(scan.ctx, network, addr)
 		if err != nil {
 			return nil, err
 		}
@@ -368,9 +378,10 @@ func (scan *scan) getTLSDialer(t *zgrab2.ScanTarget) func(network, addr string)
 		//  - host is the current target of the request in this context; this is true for the
 		//    initial request as well as subsequent requests caused by redirects
 		//  - scan.scanner.config.ServerName is the value from --server-name if one was specified
+		//  - t.ServerName is the per-target value from the input file, which takes precedence
 
 		// If SNI is enabled and --server-name is not set, use the target host for the SNI server name
-		if !scan.scanner.config.NoSNI && scan.scanner.config.ServerName == "" {
+		if !scan.scanner.config.NoSNI && scan.scanner.config.ServerName == "" && t.ServerName == "" {
 			host, _, err := net.SplitHostPort(addr)
 			if err != nil {
 				log.Errorf("getTLSDialer(): Something went wrong splitting host/port '%s': %s", addr, err)
@@ -428,6 +439,10 @@ func (scan *scan) getCheckRedirect() func(*http.Request, *http.Response, []*http
 		if !scan.scanner.config.FollowLocalhostRedirects && redirectsToLocalhost(req.URL.Hostname()) {
 			return ErrRedirLocalhost
 		}
+		// Redirects to domains are checked when connecting
+		if ip := net.ParseIP(req.URL.Hostname()); ip != nil && !zgrab2.IsAddressAllowedContext(scan.ctx, ip) {
+			return zgrab2.ErrBlocklisted
+		}
 		scan.results.RedirectResponseChain = append(scan.results.RedirectResponseChain, res)
 		b := new(bytes.Buffer)
 		maxReadLen := int64(scan.scanner.config.MaxSize) * 1024
@@ -485,8 +500,9 @@ func getHTTPURL(https bool, host string, port uint16, endpoint string) string {
 }
 
 // NewHTTPScan gets a new Scan instance for the given target
-func (scanner *Scanner) newHTTPScan(t *zgrab2.ScanTarget, useHTTPS bool) *scan {
+func (scanner *Scanner) newHTTPScan(ctx context.Context, t *zgrab2.ScanTarget, useHTTPS bool) *scan {
 	ret := scan{
+		ctx:     ctx,
 		scanner: scanner,
 		target:  t,
 		transport: &http.Transport{
@@ -516,7 +532,12 @@ func (scanner *Scanner) newHTTPScan(t *zgrab2.ScanTarget, useHTTPS bool) *scan {
 	} else {
 		port = uint16(scanner.config.BaseFlags.Port)
 	}
-	ret.url = getHTTPURL(useHTTPS, host, port, scanner.config.Endpoint)
+	// Scanner Target endpoint overrides config flag endpoint
+	endpoint := scanner.config.Endpoint
+	if t.Endpoint != "" {
+		endpoint = t.Endpoint
+	}
+	ret.url = getHTTPURL(useHTTPS, host, port, endpoint)
 
 	return &ret
 }
@@ -528,6 +549,7 @@ func (scan *scan) Grab() *zgrab2.ScanError {
 	if err != nil {
 		return zgrab2.NewScanError(zgrab2.SCAN_UNKNOWN_ERROR, err)
 	}
+	request = request.WithContext(scan.ctx)
 
 	// By default, the following headers are *always* set:
 	// Host, User-Agent, Accept, Accept-Encoding
@@ -680,13 +702,20 @@ func (scan *scan) getBanner(resp *http.Response, body []byte) []byte {
 // the target. If the scanner is configured to follow redirects, this may entail
 // multiple TCP connections to hosts other than target.
 func (scanner *Scanner) Scan(t zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
-	scan := scanner.newHTTPScan(&t, scanner.config.UseHTTPS)
+	return scanner.ScanContext(t.Context(), t)
+}
+
+// ScanContext implements the zgrab2.ContextScanner interface: the connections
+// and requests of the scan, including redirects, are cancelled when ctx is
+// done.
+func (scanner *Scanner) ScanContext(ctx context.Context, t zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	scan := scanner.newHTTPScan(ctx, &t, scanner.config.UseHTTPS)
 	defer scan.Cleanup()
 	err := scan.Grab()
 	if err != nil {
 		if scanner.config.RetryHTTPS && !scanner.config.UseHTTPS {
 			scan.Cleanup()
-			retry := scanner.newHTTPScan(&t, true)
+			retry := scanner.newHTTPScan(ctx, &t, true)
 			defer retry.Cleanup()
 			retryError := retry.Grab()
 			if retryError != nil {
@@ -807,6 +836,7 @@ func (scan *scan) downloadFavicon(selectedFavicon string) ([]byte, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "error http.NewRequest")
 	}
+	request = request.WithContext(scan.ctx)
 
 	response, err := scan.client.Do(request)
 	if err != nil {
@@ -46,6 +46,14 @@ type ScanResults struct {
 	TLSLog *zgrab2.TLSLog `json:"tls,omitempty"`
 }
 
+// ServiceBanner implements zgrab2.ServiceBanner.
+func (results *ScanResults) ServiceBanner() ([]byte, string) {
+	if results == nil {
+		return nil, ""
+	}
+	return []byte(results.Banner), "NULL"
+}
+
 // Flags holds the command-line configuration for the IMAP scan module.
 // Populated by the framework.
 type Flags struct {
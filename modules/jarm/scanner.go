@@ -72,6 +72,12 @@ func (scanner *Scanner) Protocol() string {
 	return "jarm"
 }
 
+// NoRetry disables the framework retries when --max-tries is set, as the
+// scanner retries failed connections itself.
+func (scanner *Scanner) NoRetry() bool {
+	return scanner.config.MaxTries > 1
+}
+
 // InitPerSender initializes the scanner for a given sender.
 func (scanner *Scanner) InitPerSender(senderID int) error {
 	return nil
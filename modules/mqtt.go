@@ -0,0 +1,7 @@
+package modules
+
+import "github.com/zmap/zgrab2/modules/mqtt"
+
+func init() {
+	mqtt.RegisterModule()
+}
@@ -0,0 +1,417 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrInvalidPacket is returned when a packet cannot be decoded.
+	ErrInvalidPacket = errors.New("invalid MQTT packet")
+
+	// ErrUnexpectedPacket is returned when the server sends another packet
+	// than the expected one.
+	ErrUnexpectedPacket = errors.New("unexpected MQTT packet")
+)
+
+// Control packet types (MQTT 5.0, section 2.1.2).
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetSubscribe  = 8
+	packetSuback     = 9
+	packetDisconnect = 14
+)
+
+// Protocol levels of the CONNECT packet.
+const (
+	level311 = 4
+	level5   = 5
+)
+
+// maxPacketSize bounds the size of the packets read from the server.
+const maxPacketSize = 1 << 20
+
+// appendVarint appends a variable byte integer to b.
+func appendVarint(b []byte, v int) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+// appendString appends a length-prefixed UTF-8 string or binary data to b.
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// appendPacket appends a packet with the given type, flags and body to b.
+func appendPacket(b []byte, packetType, flags byte, body []byte) []byte {
+	b = append(b, packetType<<4|flags)
+	b = appendVarint(b, len(body))
+	return append(b, body...)
+}
+
+// encodeConnect returns a CONNECT packet with a clean session and no will.
+func encodeConnect(level byte, clientID string, username, password *string, keepAlive uint16) []byte {
+	body := appendString(nil, "MQTT")
+	var flags byte = 0x02 // clean session
+	if username != nil {
+		flags |= 0x80
+	}
+	if password != nil {
+		flags |= 0x40
+	}
+	body = append(body, level, flags)
+	body = binary.BigEndian.AppendUint16(body, keepAlive)
+	if level >= level5 {
+		body = appendVarint(body, 0) // properties
+	}
+	body = appendString(body, clientID)
+	if username != nil {
+		body = appendString(body, *username)
+	}
+	if password != nil {
+		body = appendString(body, *password)
+	}
+	return appendPacket(nil, packetConnect, 0, body)
+}
+
+// encodeSubscribe returns a SUBSCRIBE packet for filters with QoS 0.
+func encodeSubscribe(level byte, packetID uint16, filters []string) []byte {
+	body := binary.BigEndian.AppendUint16(nil, packetID)
+	if level >= level5 {
+		body = appendVarint(body, 0) // properties
+	}
+	for _, filter := range filters {
+		body = appendString(body, filter)
+		body = append(body, 0)
+	}
+	return appendPacket(nil, packetSubscribe, 0x02, body)
+}
+
+// encodeDisconnect returns a DISCONNECT packet with a normal disconnection.
+func encodeDisconnect() []byte {
+	return appendPacket(nil, packetDisconnect, 0, nil)
+}
+
+// readPacket reads a packet from r, and returns its type, flags and body.
+func readPacket(r *bufio.Reader) (packetType, flags byte, body []byte, err error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	length := 0
+	for i := 0; ; i++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		length |= int(c&0x7f) << (7 * i)
+		if c&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, 0, nil, fmt.Errorf("%w: bad remaining length", ErrInvalidPacket)
+		}
+	}
+	if length > maxPacketSize {
+		return 0, 0, nil, fmt.Errorf("%w: %d byte packet", ErrInvalidPacket, length)
+	}
+	body = make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return first >> 4, first & 0x0f, body, nil
+}
+
+// decoder reads the fields of a packet body.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+// next returns the next n bytes, or nil if there are not enough.
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.b) {
+		d.err = ErrInvalidPacket
+		return nil
+	}
+	ret := d.b[:n]
+	d.b = d.b[n:]
+	return ret
+}
+
+// readByte reads a byte.
+func (d *decoder) readByte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// readUint16 reads a two byte integer.
+func (d *decoder) readUint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+// readUint32 reads a four byte integer.
+func (d *decoder) readUint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// readVarint reads a variable byte integer.
+func (d *decoder) readVarint() int {
+	v := 0
+	for i := 0; i < 4; i++ {
+		c := d.readByte()
+		v |= int(c&0x7f) << (7 * i)
+		if c&0x80 == 0 {
+			return v
+		}
+	}
+	if d.err == nil {
+		d.err = ErrInvalidPacket
+	}
+	return 0
+}
+
+// readString reads a length-prefixed string or binary data.
+func (d *decoder) readString() string {
+	n := d.readUint16()
+	return string(d.next(int(n)))
+}
+
+// Properties holds the MQTT 5.0 properties of a CONNACK packet (MQTT 5.0,
+// section 3.2.2.3).
+type Properties struct {
+	SessionExpiryInterval            *uint32        `json:"session_expiry_interval,omitempty"`
+	ReceiveMaximum                   *uint16        `json:"receive_maximum,omitempty"`
+	MaximumQoS                       *uint8         `json:"maximum_qos,omitempty"`
+	RetainAvailable                  *bool          `json:"retain_available,omitempty"`
+	MaximumPacketSize                *uint32        `json:"maximum_packet_size,omitempty"`
+	AssignedClientID                 string         `json:"assigned_client_id,omitempty"`
+	TopicAliasMaximum                *uint16        `json:"topic_alias_maximum,omitempty"`
+	ReasonString                     string         `json:"reason_string,omitempty"`
+	UserProperties                   []UserProperty `json:"user_properties,omitempty"`
+	WildcardSubscriptionAvailable    *bool          `json:"wildcard_subscription_available,omitempty"`
+	SubscriptionIdentifiersAvailable *bool          `json:"subscription_identifiers_available,omitempty"`
+	SharedSubscriptionAvailable      *bool          `json:"shared_subscription_available,omitempty"`
+	ServerKeepAlive                  *uint16        `json:"server_keep_alive,omitempty"`
+	ResponseInformation              string         `json:"response_information,omitempty"`
+	ServerReference                  string         `json:"server_reference,omitempty"`
+	AuthenticationMethod             string         `json:"authentication_method,omitempty"`
+}
+
+// UserProperty is a name/value pair sent by the server.
+type UserProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// decodeProperties decodes a property list. Properties that are not valid
+// in a CONNACK packet are skipped.
+func decodeProperties(d *decoder) *Properties {
+	length := d.readVarint()
+	list := &decoder{b: d.next(length)}
+	if d.err != nil {
+		return nil
+	}
+	ret := &Properties{}
+	flag := func() *bool {
+		v := list.readByte() != 0
+		return &v
+	}
+	u16 := func() *uint16 {
+		v := list.readUint16()
+		return &v
+	}
+	u32 := func() *uint32 {
+		v := list.readUint32()
+		return &v
+	}
+	for len(list.b) > 0 && list.err == nil {
+		switch id := list.readVarint(); id {
+		case 0x11:
+			ret.SessionExpiryInterval = u32()
+		case 0x21:
+			ret.ReceiveMaximum = u16()
+		case 0x24:
+			v := list.readByte()
+			ret.MaximumQoS = &v
+		case 0x25:
+			ret.RetainAvailable = flag()
+		case 0x27:
+			ret.MaximumPacketSize = u32()
+		case 0x12:
+			ret.AssignedClientID = list.readString()
+		case 0x22:
+			ret.TopicAliasMaximum = u16()
+		case 0x1f:
+			ret.ReasonString = list.readString()
+		case 0x26:
+			name := list.readString()
+			ret.UserProperties = append(ret.UserProperties, UserProperty{Name: name, Value: list.readString()})
+		case 0x28:
+			ret.WildcardSubscriptionAvailable = flag()
+		case 0x29:
+			ret.SubscriptionIdentifiersAvailable = flag()
+		case 0x2a:
+			ret.SharedSubscriptionAvailable = flag()
+		case 0x13:
+			ret.ServerKeepAlive = u16()
+		case 0x1a:
+			ret.ResponseInformation = list.readString()
+		case 0x1c:
+			ret.ServerReference = list.readString()
+		case 0x15:
+			ret.AuthenticationMethod = list.readString()
+		case 0x16, 0x09, 0x03, 0x08:
+			// binary data and strings of other packets
+			list.readString()
+		case 0x01, 0x17, 0x19:
+			list.readByte()
+		case 0x02, 0x18:
+			list.readUint32()
+		case 0x23:
+			list.readUint16()
+		case 0x0b:
+			list.readVarint()
+		default:
+			list.err = fmt.Errorf("%w: unknown property 0x%02x", ErrInvalidPacket, id)
+		}
+	}
+	if list.err != nil {
+		d.err = list.err
+		return nil
+	}
+	return ret
+}
+
+// connack is a decoded CONNACK packet.
+type connack struct {
+	sessionPresent bool
+	code           byte
+
+	// v5 is set if the packet has the MQTT 5.0 format, with properties.
+	v5         bool
+	properties *Properties
+}
+
+// decodeConnack decodes the body of a CONNACK packet. The MQTT 3.1.1 format
+// has no properties, and is also sent by MQTT 5.0 servers to 3.1.1 clients,
+// and by 3.1.1 servers to refuse MQTT 5.0 clients.
+func decodeConnack(body []byte) (*connack, error) {
+	d := &decoder{b: body}
+	ret := &connack{sessionPresent: d.readByte()&0x01 != 0, code: d.readByte()}
+	if len(d.b) > 0 {
+		ret.v5 = true
+		ret.properties = decodeProperties(d)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return ret, nil
+}
+
+// decodeSuback decodes the body of a SUBACK packet, and returns its packet
+// identifier and return or reason codes.
+func decodeSuback(body []byte, level byte) (uint16, []byte, error) {
+	d := &decoder{b: body}
+	packetID := d.readUint16()
+	if level >= level5 {
+		decodeProperties(d)
+	}
+	if d.err != nil {
+		return 0, nil, d.err
+	}
+	return packetID, d.b, nil
+}
+
+// decodePublish decodes the body of a PUBLISH packet with the given flags,
+// and returns its topic name and payload.
+func decodePublish(body []byte, flags byte, level byte) (string, []byte, error) {
+	d := &decoder{b: body}
+	topic := d.readString()
+	if flags&0x06 != 0 {
+		// QoS 1 or 2
+		d.readUint16()
+	}
+	if level >= level5 {
+		length := d.readVarint()
+		d.next(length)
+	}
+	if d.err != nil {
+		return "", nil, d.err
+	}
+	return topic, d.b, nil
+}
+
+// returnCodes are the CONNACK return codes of MQTT 3.1.1 (section 3.2.2.3).
+var returnCodes = map[byte]string{
+	0x00: "accepted",
+	0x01: "unacceptable protocol version",
+	0x02: "identifier rejected",
+	0x03: "server unavailable",
+	0x04: "bad user name or password",
+	0x05: "not authorized",
+}
+
+// reasonCodes are the CONNACK reason codes of MQTT 5.0 (section 3.2.2.2).
+var reasonCodes = map[byte]string{
+	0x00: "success",
+	0x80: "unspecified error",
+	0x81: "malformed packet",
+	0x82: "protocol error",
+	0x83: "implementation specific error",
+	0x84: "unsupported protocol version",
+	0x85: "client identifier not valid",
+	0x86: "bad user name or password",
+	0x87: "not authorized",
+	0x88: "server unavailable",
+	0x89: "server busy",
+	0x8a: "banned",
+	0x8c: "bad authentication method",
+	0x90: "topic name invalid",
+	0x95: "packet too large",
+	0x97: "quota exceeded",
+	0x99: "payload format invalid",
+	0x9a: "retain not supported",
+	0x9b: "qos not supported",
+	0x9c: "use another server",
+	0x9d: "server moved",
+	0x9f: "connection rate exceeded",
+}
+
+// reason returns the name of the packet's return or reason code.
+func (c *connack) reason() string {
+	names := returnCodes
+	if c.v5 {
+		names = reasonCodes
+	}
+	if name, ok := names[c.code]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (0x%02x)", c.code)
+}
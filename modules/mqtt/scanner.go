@@ -0,0 +1,346 @@
+// Package mqtt provides a zgrab2 module that probes for MQTT brokers.
+// Default Port: 1883 (TCP)
+//
+// The scan sends a CONNECT packet, with MQTT 3.1.1 or, with --v5, MQTT 5.0,
+// and decodes the CONNACK return or reason code and, for MQTT 5.0, its
+// properties. Brokers that accept the connection without --username are
+// reported as anonymous.
+//
+// The --tls flag tells the scanner to perform a TLS handshake immediately
+// after connecting, using the standard TLS flags; --websocket tunnels the
+// packets over a WebSocket connection to --websocket-path. Neither changes
+// the default port number from 1883, so they should usually be coupled with
+// e.g. --port 8883 or --port 8080.
+//
+// With --subscribe, if the connection is accepted, the scanner subscribes
+// to # and $SYS/# and records the topic names of the messages it receives
+// for --subscribe-time, along with the broker version published in $SYS.
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+	"golang.org/x/net/websocket"
+)
+
+// Flags holds the command-line flags for the scanner.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.TLSFlags
+
+	UseTLS        bool          `long:"tls" description:"Perform a TLS handshake immediately after connecting"`
+	WebSocket     bool          `long:"websocket" description:"Connect over WebSocket (wss with --tls)"`
+	WebSocketPath string        `long:"websocket-path" default:"/mqtt" description:"Path of the WebSocket endpoint"`
+	V5            bool          `long:"v5" description:"Send an MQTT 5.0 CONNECT instead of MQTT 3.1.1"`
+	ClientID      string        `long:"client-id" default:"zgrab" description:"Client identifier of the CONNECT packet"`
+	Username      string        `long:"username" description:"User name of the CONNECT packet; by default, the connection is anonymous"`
+	Password      string        `long:"password" description:"Password of the CONNECT packet, sent only if set"`
+	Subscribe     bool          `long:"subscribe" description:"If the connection is accepted, subscribe to # and $SYS/# and record the topics received"`
+	SubscribeTime time.Duration `long:"subscribe-time" default:"3s" description:"How long to read messages after subscribing; bounded by --timeout"`
+	MaxTopics     int           `long:"max-topics" default:"100" description:"Maximum number of topic names recorded"`
+}
+
+// Results is the output of the scan.
+type Results struct {
+	// ProtocolVersion is the version of the CONNECT packet: 3.1.1 or 5.0.
+	ProtocolVersion string `json:"protocol_version"`
+
+	// SessionPresent is the session present flag of the CONNACK packet.
+	SessionPresent bool `json:"session_present"`
+
+	// ReturnCode is the return code (MQTT 3.1.1) or reason code (MQTT 5.0)
+	// of the CONNACK packet, and Reason its name.
+	ReturnCode uint8  `json:"return_code"`
+	Reason     string `json:"reason"`
+
+	// Accepted is set if the broker accepted the connection.
+	Accepted bool `json:"accepted"`
+
+	// Anonymous is set if the broker accepted the connection without
+	// credentials.
+	Anonymous bool `json:"anonymous"`
+
+	// Properties are the properties of an MQTT 5.0 CONNACK packet.
+	Properties *Properties `json:"properties,omitempty"`
+
+	// Subscriptions are the return or reason codes of the SUBSCRIBE
+	// packet, with --subscribe.
+	Subscriptions []Subscription `json:"subscriptions,omitempty"`
+
+	// Topics are the distinct topic names of the messages received after
+	// subscribing.
+	Topics []string `json:"topics,omitempty"`
+
+	// BrokerVersion is the payload of a $SYS version topic, such as
+	// $SYS/broker/version.
+	BrokerVersion string `json:"broker_version,omitempty"`
+
+	// TLSLog is the standard TLS log, if --tls is enabled.
+	TLSLog *zgrab2.TLSLog `json:"tls,omitempty"`
+}
+
+// Subscription is the result of subscribing to a topic filter.
+type Subscription struct {
+	Filter string `json:"filter"`
+
+	// ReturnCode is the granted QoS, or a failure code of 0x80 and up.
+	ReturnCode uint8 `json:"return_code"`
+	Granted    bool  `json:"granted"`
+}
+
+// subscribeFilters are the topic filters subscribed to with --subscribe.
+var subscribeFilters = []string{"#", "$SYS/#"}
+
+// Module is the zgrab2 module implementation
+type Module struct {
+}
+
+// Scanner holds the state for a single scan
+type Scanner struct {
+	config *Flags
+}
+
+// RegisterModule registers the module with zgrab2
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("mqtt", "MQTT", module.Description(), 1883, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a flags instant to be populated with the command line args
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new MQTT scanner instance
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Description returns an overview of this module.
+func (module *Module) Description() string {
+	return "Connect to an MQTT broker over TCP, TLS or WebSocket, and optionally sample its topics"
+}
+
+// Validate checks that the flags are valid
+func (cfg *Flags) Validate(args []string) error {
+	if cfg.WebSocket && !strings.HasPrefix(cfg.WebSocketPath, "/") {
+		log.Errorf("Invalid WebSocket path %q (must start with /)", cfg.WebSocketPath)
+		return zgrab2.ErrInvalidArguments
+	}
+	if cfg.Subscribe && (cfg.SubscribeTime <= 0 || cfg.MaxTopics <= 0) {
+		log.Errorln("--subscribe-time and --max-topics must be positive")
+		return zgrab2.ErrInvalidArguments
+	}
+	return nil
+}
+
+// Help returns the module's help string
+func (cfg *Flags) Help() string {
+	return ""
+}
+
+// Init initialized the scanner
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// Protocol returns the protocol identifer for the scanner.
+func (scanner *Scanner) Protocol() string {
+	return "mqtt"
+}
+
+// GetName returns the module's name
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// open connects to the target, performs the TLS handshake if --tls is set,
+// and the WebSocket handshake if --websocket is set.
+func (scanner *Scanner) open(target *zgrab2.ScanTarget, results *Results) (net.Conn, error) {
+	var conn net.Conn
+	if scanner.config.UseTLS {
+		tlsConn, err := target.OpenTLS(&scanner.config.BaseFlags, &scanner.config.TLSFlags)
+		if tlsConn != nil {
+			results.TLSLog = tlsConn.GetLog()
+		}
+		if err != nil {
+			if tlsConn != nil {
+				tlsConn.Close()
+			}
+			return nil, err
+		}
+		conn = tlsConn
+	} else {
+		var err error
+		if conn, err = target.Open(&scanner.config.BaseFlags); err != nil {
+			return nil, err
+		}
+	}
+	if !scanner.config.WebSocket {
+		return conn, nil
+	}
+	port := scanner.config.Port
+	if target.Port != nil {
+		port = *target.Port
+	}
+	host := net.JoinHostPort(target.Host(), strconv.FormatUint(uint64(port), 10))
+	scheme, origin := "ws", "http"
+	if scanner.config.UseTLS {
+		scheme, origin = "wss", "https"
+	}
+	config, err := websocket.NewConfig(scheme+"://"+host+scanner.config.WebSocketPath, origin+"://"+host+"/")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	config.Protocol = []string{"mqtt"}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+// status returns the status for err.
+func status(err error) zgrab2.ScanStatus {
+	var wsErr *websocket.ProtocolError
+	if errors.Is(err, ErrInvalidPacket) || errors.Is(err, ErrUnexpectedPacket) || errors.As(err, &wsErr) {
+		return zgrab2.SCAN_PROTOCOL_ERROR
+	}
+	return zgrab2.TryGetScanStatus(err)
+}
+
+// Scan sends the CONNECT packet and decodes the CONNACK, then subscribes
+// and samples the topics if --subscribe is set.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	results := &Results{ProtocolVersion: "3.1.1"}
+	level := byte(level311)
+	if scanner.config.V5 {
+		results.ProtocolVersion, level = "5.0", level5
+	}
+	conn, err := scanner.open(&target, results)
+	if err != nil {
+		if results.TLSLog != nil {
+			return status(err), results, err
+		}
+		return status(err), nil, err
+	}
+	defer conn.Close()
+
+	var username, password *string
+	if scanner.config.Username != "" {
+		username = &scanner.config.Username
+	}
+	if scanner.config.Password != "" {
+		password = &scanner.config.Password
+	}
+	if _, err := conn.Write(encodeConnect(level, scanner.config.ClientID, username, password, 60)); err != nil {
+		return status(err), nil, err
+	}
+	r := bufio.NewReader(conn)
+	packetType, _, body, err := readPacket(r)
+	if err == nil && packetType != packetConnack {
+		err = ErrUnexpectedPacket
+	}
+	if err != nil {
+		return status(err), nil, err
+	}
+	ack, err := decodeConnack(body)
+	if err != nil {
+		return status(err), nil, err
+	}
+	results.SessionPresent = ack.sessionPresent
+	results.ReturnCode = ack.code
+	results.Reason = ack.reason()
+	results.Accepted = ack.code == 0
+	results.Anonymous = results.Accepted && username == nil
+	results.Properties = ack.properties
+	if results.Accepted && scanner.config.Subscribe {
+		if err := scanner.sample(conn, r, level, results); err != nil {
+			return status(err), results, err
+		}
+	}
+	if results.Accepted {
+		conn.Write(encodeDisconnect())
+	}
+	return zgrab2.SCAN_SUCCESS, results, nil
+}
+
+// sample subscribes to subscribeFilters and records the topics of the
+// messages received until --subscribe-time elapses or --max-topics topics
+// are recorded.
+func (scanner *Scanner) sample(conn net.Conn, r *bufio.Reader, level byte, results *Results) error {
+	const packetID = 1
+	if _, err := conn.Write(encodeSubscribe(level, packetID, subscribeFilters)); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	end := time.Now().Add(scanner.config.SubscribeTime)
+	for time.Now().Before(end) && len(results.Topics) < scanner.config.MaxTopics {
+		conn.SetReadDeadline(end)
+		packetType, flags, body, err := readPacket(r)
+		if err != nil {
+			if errors.Is(err, ErrInvalidPacket) {
+				return err
+			}
+			// end of the sampling time, of the session or of the read limit
+			return nil
+		}
+		switch packetType {
+		case packetSuback:
+			id, codes, err := decodeSuback(body, level)
+			if err != nil {
+				return err
+			}
+			if id != packetID {
+				continue
+			}
+			for i, code := range codes {
+				if i < len(subscribeFilters) {
+					results.Subscriptions = append(results.Subscriptions, Subscription{
+						Filter:     subscribeFilters[i],
+						ReturnCode: code,
+						Granted:    code < 0x80,
+					})
+				}
+			}
+		case packetPublish:
+			topic, payload, err := decodePublish(body, flags, level)
+			if err != nil {
+				return err
+			}
+			if !seen[topic] {
+				seen[topic] = true
+				results.Topics = append(results.Topics, topic)
+			}
+			if results.BrokerVersion == "" && strings.HasPrefix(topic, "$SYS/") && strings.HasSuffix(topic, "/version") {
+				results.BrokerVersion = string(payload)
+			}
+		}
+	}
+	return nil
+}
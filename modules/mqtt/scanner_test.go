@@ -0,0 +1,198 @@
+package mqtt
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+	"golang.org/x/net/websocket"
+)
+
+// broker answers a client as a mosquitto broker with a user admin, which
+// also allows anonymous clients.
+func broker(t *testing.T, conn io.ReadWriter) {
+	r := bufio.NewReader(conn)
+	packetType, _, body, err := readPacket(r)
+	if err != nil || packetType != packetConnect {
+		t.Errorf("expected CONNECT, got %d, %v", packetType, err)
+		return
+	}
+	d := &decoder{b: body}
+	d.readString()
+	level, flags := d.readByte(), d.readByte()
+	d.readUint16()
+	if level == level5 {
+		d.next(d.readVarint())
+	}
+	clientID := d.readString()
+	var username string
+	if flags&0x80 != 0 {
+		username = d.readString()
+	}
+	if d.err != nil {
+		t.Error(d.err)
+		return
+	}
+	code := byte(0)
+	if flags&0x80 != 0 && username != "admin" {
+		code = 0x04
+		if level == level5 {
+			code = 0x86
+		}
+	}
+	ack := []byte{0, code}
+	if level == level5 {
+		props := []byte{0x13, 0, 30, 0x27, 0, 0x10, 0, 0}
+		if clientID == "" {
+			props = append(props, 0x12)
+			props = appendString(props, "auto-1F2E")
+		}
+		props = append(props, 0x26)
+		props = appendString(appendString(props, "node"), "emqx@127.0.0.1")
+		ack = append(appendVarint(ack, len(props)), props...)
+	}
+	conn.Write(appendPacket(nil, packetConnack, 0, ack))
+	if code != 0 {
+		return
+	}
+	for {
+		packetType, _, body, err := readPacket(r)
+		if err != nil || packetType == packetDisconnect {
+			return
+		}
+		if packetType != packetSubscribe {
+			continue
+		}
+		suback := body[:2]
+		if level == level5 {
+			suback = append(suback, 0)
+		}
+		conn.Write(appendPacket(nil, packetSuback, 0, append(suback, 0, 0x80)))
+		for _, msg := range [][2]string{
+			{"$SYS/broker/version", "mosquitto version 2.0.18"},
+			{"home/livingroom/temperature", "21.5"},
+			{"home/livingroom/temperature", "21.6"},
+		} {
+			publish := appendString(nil, msg[0])
+			if level == level5 {
+				publish = append(publish, 0)
+			}
+			conn.Write(appendPacket(nil, packetPublish, 0x01, append(publish, msg[1]...)))
+		}
+	}
+}
+
+// serveTCP runs the broker on a local TCP port.
+func serveTCP(t *testing.T) uint {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				broker(t, conn)
+			}()
+		}
+	}()
+	return uint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// serveWebSocket runs the broker on a local WebSocket endpoint at /mqtt.
+func serveWebSocket(t *testing.T) uint {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	mux := http.NewServeMux()
+	mux.Handle("/mqtt", websocket.Handler(func(ws *websocket.Conn) {
+		ws.PayloadType = websocket.BinaryFrame
+		broker(t, ws)
+	}))
+	go http.Serve(listener, mux)
+	return uint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func scan(t *testing.T, flags *Flags) *Results {
+	flags.Timeout = 2 * time.Second
+	flags.BytesReadLimit = 1 << 16
+	flags.WebSocketPath = "/mqtt"
+	flags.SubscribeTime = 300 * time.Millisecond
+	flags.MaxTopics = 100
+	scanner := new(Scanner)
+	scanner.Init(flags)
+	status, res, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("unexpected status %s, %v", status, err)
+	}
+	return res.(*Results)
+}
+
+func TestAnonymous(t *testing.T) {
+	flags := &Flags{ClientID: "zgrab", Subscribe: true}
+	flags.Port = serveTCP(t)
+	results := scan(t, flags)
+	if !results.Accepted || !results.Anonymous || results.Reason != "accepted" || results.Properties != nil {
+		t.Fatalf("expected an anonymous connection, got %+v", results)
+	}
+	if len(results.Subscriptions) != 2 || !results.Subscriptions[0].Granted || results.Subscriptions[1].ReturnCode != 0x80 {
+		t.Errorf("unexpected subscriptions %+v", results.Subscriptions)
+	}
+	if len(results.Topics) != 2 || results.Topics[1] != "home/livingroom/temperature" {
+		t.Errorf("unexpected topics %v", results.Topics)
+	}
+	if results.BrokerVersion != "mosquitto version 2.0.18" {
+		t.Errorf("unexpected broker version %q", results.BrokerVersion)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	for _, v5 := range []bool{false, true} {
+		flags := &Flags{ClientID: "zgrab", Username: "guest", Password: "guest", V5: v5, Subscribe: true}
+		flags.Port = serveTCP(t)
+		results := scan(t, flags)
+		if results.Accepted || results.Anonymous || results.Reason != "bad user name or password" || len(results.Topics) != 0 {
+			t.Errorf("v5 %v: expected a refused connection, got %+v", v5, results)
+		}
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	flags := &Flags{WebSocket: true, V5: true}
+	flags.Port = serveWebSocket(t)
+	results := scan(t, flags)
+	if !results.Accepted || !results.Anonymous || results.ProtocolVersion != "5.0" || results.Reason != "success" {
+		t.Fatalf("expected an anonymous connection, got %+v", results)
+	}
+	props := results.Properties
+	if props == nil || *props.ServerKeepAlive != 30 || *props.MaximumPacketSize != 1<<20 || props.AssignedClientID != "auto-1F2E" {
+		t.Fatalf("unexpected properties %+v", props)
+	}
+	if len(props.UserProperties) != 1 || props.UserProperties[0].Value != "emqx@127.0.0.1" {
+		t.Errorf("unexpected user properties %+v", props.UserProperties)
+	}
+}
+
+func TestVarint(t *testing.T) {
+	for _, v := range []int{0, 127, 128, 16383, 16384, 268435455} {
+		b := appendVarint(nil, v)
+		d := &decoder{b: b}
+		if got := d.readVarint(); got != v || d.err != nil || len(d.b) != 0 {
+			t.Errorf("%d: got %d (%x), %v", v, got, b, d.err)
+		}
+	}
+	if _, err := decodeConnack([]byte{0, 0, 5, 0x13}); err == nil {
+		t.Error("expected an error for truncated properties")
+	}
+}
@@ -0,0 +1,330 @@
+// Package nmap provides a zgrab2 module that identifies services the way
+// nmap's version detection does: it walks the Probe directives of an
+// nmap-service-probes database in rarity order, and stops at the first
+// response that hard-matches one of the probe's match directives.
+//
+// The database is the one given with the global --nmap-service-probes
+// option, unless --probes-file is set. Each probe is sent over a fresh
+// connection, as nmap does. Probes are restricted to the given --intensity,
+// except for those whose ports (or sslports, with --tls) directive lists the
+// target port. With --udp, only UDP probes are sent.
+//
+// The output is the detected service/product/version/cpe, the list of probes
+// that were sent and the response that matched.
+package nmap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+	"github.com/zmap/zgrab2/lib/nmap"
+)
+
+// ErrNoMatch is returned when no probe elicited a response matching the database.
+var ErrNoMatch = errors.New("no probe response matched")
+
+// ErrPortExcluded is returned when the target port is listed in the Exclude directive.
+var ErrPortExcluded = errors.New("port excluded from version scanning")
+
+// Time to wait for further data once a response has started to arrive.
+const readTimeout = 500 * time.Millisecond
+
+const readBufferSize = 8209
+
+// Flags give the command-line flags for the nmap module.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.UDPFlags
+	zgrab2.TLSFlags
+
+	ProbesFile string `long:"probes-file" description:"nmap-service-probes file to use instead of the global --nmap-service-probes"`
+	Intensity  int    `long:"intensity" default:"7" description:"Send probes with rarity up to this value (0-9), plus those listing the target port"`
+	UseTLS     bool   `long:"tls" description:"Send the probes over TLS, and use the sslports directive instead of ports"`
+	UseUDP     bool   `long:"udp" description:"Send the UDP probes instead of the TCP probes"`
+	MaxSize    int    `long:"max-size" default:"64" description:"Max kilobytes to read in response to a single probe"`
+	Hex        bool   `long:"hex" description:"Store the matched response in hex"`
+}
+
+// Module is the implementation of the zgrab2.Module interface.
+type Module struct {
+}
+
+// Scanner is the implementation of the zgrab2.Scanner interface.
+type Scanner struct {
+	config *Flags
+	probes *nmap.ServiceProbes
+}
+
+// Results is the output of the scan.
+type Results struct {
+	// Service is the version information from the matching directive.
+	Service *nmap.ServiceInfo `json:"service,omitempty"`
+
+	// TCPWrapped is set if the server closed the connection before sending
+	// anything, within the tcpwrappedms period of the NULL probe.
+	TCPWrapped bool `json:"tcpwrapped,omitempty"`
+
+	// ProbesSent lists the names of the probes sent, in order.
+	ProbesSent []string `json:"probes_sent,omitempty"`
+
+	// Banner is the response that matched; if nothing matched, it is the
+	// first non-empty response received.
+	Banner string `json:"banner,omitempty"`
+
+	// TLSLog is the TLS handshake log of the first connection, if --tls is set.
+	TLSLog *zgrab2.TLSLog `json:"tls,omitempty"`
+}
+
+// RegisterModule is called by modules/nmap.go to register the scanner.
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("nmap", "nmap service probes", module.Description(), 80, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a new default flags object.
+func (m *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new Scanner object.
+func (m *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Description returns an overview of this module.
+func (m *Module) Description() string {
+	return "Identify a service by sending nmap-service-probes probes until one matches"
+}
+
+// Validate validates the flags and returns nil on success.
+func (f *Flags) Validate(args []string) error {
+	if f.Intensity < 0 || f.Intensity > 9 {
+		log.Errorf("--intensity must be in the range [0,9], given %d", f.Intensity)
+		return zgrab2.ErrInvalidArguments
+	}
+	if f.UseTLS && f.UseUDP {
+		log.Errorln("Cannot specify both --tls and --udp")
+		return zgrab2.ErrInvalidArguments
+	}
+	return nil
+}
+
+// Help returns the module's help string.
+func (f *Flags) Help() string {
+	return ""
+}
+
+// Init initializes the Scanner with the command-line flags.
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	if f.ProbesFile != "" {
+		probes, err := nmap.LoadServiceProbes(f.ProbesFile)
+		if err != nil {
+			log.Errorf("could not load %s: %s", f.ProbesFile, err)
+			return err
+		}
+		scanner.probes = probes
+	} else {
+		scanner.probes = zgrab2.GetServiceProbes()
+	}
+	if scanner.probes == nil {
+		log.Errorln("nmap module requires --nmap-service-probes or --probes-file")
+		return zgrab2.ErrInvalidArguments
+	}
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender.
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// GetName returns the Scanner name defined in the Flags.
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// Protocol returns the protocol identifier of the scan.
+func (scanner *Scanner) Protocol() string {
+	return "nmap"
+}
+
+func (scanner *Scanner) protocol() nmap.Protocol {
+	if scanner.config.UseUDP {
+		return nmap.UDP
+	}
+	return nmap.TCP
+}
+
+// open connects to the target over the configured transport.
+func (scanner *Scanner) open(target *zgrab2.ScanTarget, results *Results) (net.Conn, error) {
+	if scanner.config.UseUDP {
+		return target.OpenUDP(&scanner.config.BaseFlags, &scanner.config.UDPFlags)
+	}
+	if scanner.config.UseTLS {
+		conn, err := target.OpenTLS(&scanner.config.BaseFlags, &scanner.config.TLSFlags)
+		if conn != nil && results.TLSLog == nil {
+			results.TLSLog = conn.GetLog()
+		}
+		if err != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			return nil, err
+		}
+		return conn, nil
+	}
+	return target.Open(&scanner.config.BaseFlags)
+}
+
+// sendProbe sends the probe over a new connection and reads the response for
+// up to the probe's totalwaitms (bounded by --timeout). A connection closed
+// without any data within tcpwrappedms is reported with wrapped = true.
+func (scanner *Scanner) sendProbe(target *zgrab2.ScanTarget, probe *nmap.Probe, results *Results) (response []byte, wrapped bool, err error) {
+	conn, err := scanner.open(target, results)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	start := time.Now()
+	if len(probe.Data) > 0 {
+		if _, err = conn.Write(probe.Data); err != nil {
+			return nil, false, err
+		}
+	}
+	wait := probe.TotalWait
+	if timeout := scanner.config.Timeout; timeout > 0 && timeout < wait {
+		wait = timeout
+	}
+	if err = conn.SetReadDeadline(start.Add(wait)); err != nil {
+		return nil, false, err
+	}
+	response, err = zgrab2.ReadAvailableWithOptions(conn, readBufferSize, readTimeout, wait, scanner.config.MaxSize*1024)
+	if err == io.EOF && len(response) == 0 && time.Since(start) < probe.TCPWrapped {
+		return nil, true, nil
+	}
+	if err == io.EOF || zgrab2.IsTimeoutError(err) {
+		err = nil
+	}
+	return response, false, err
+}
+
+// hasMatchesFor returns true if any of the matches tried for a response to
+// probe can identify service.
+func hasMatchesFor(probe *nmap.Probe, service string) bool {
+	for _, p := range append([]*nmap.Probe{probe}, probe.Fallbacks()...) {
+		for _, match := range p.Matches {
+			if match.Service == service {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Scan sends the probes for the target port in rarity order until one of
+// the responses matches.
+//  1. If the port is excluded by the database, fail with ErrPortExcluded.
+//  2. For each probe, connect, send the probe and read the response.
+//     A failure to connect on the first probe aborts the scan.
+//  3. Try the probe's matches (then its fallbacks') against the response.
+//     A hard match ends the scan. After a softmatch, only probes able to
+//     identify the same service are sent, and only matches for that service
+//     are accepted.
+//  4. If only a softmatch was found, report it.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	port := scanner.config.Port
+	if target.Port != nil {
+		port = *target.Port
+	}
+	exclude := scanner.probes.Exclude
+	if scanner.config.UseUDP {
+		exclude = scanner.probes.UDPExclude
+	}
+	if exclude.Contains(port) {
+		return zgrab2.SCAN_APPLICATION_ERROR, nil, ErrPortExcluded
+	}
+
+	results := &Results{}
+	var (
+		soft     *nmap.ServiceInfo
+		matched  []byte
+		firstErr error
+	)
+	probes := scanner.probes.ProbesByRarity(scanner.protocol(), port, scanner.config.UseTLS, scanner.config.Intensity)
+	for i, probe := range probes {
+		if soft != nil && !hasMatchesFor(probe, soft.Service) {
+			continue
+		}
+		results.ProbesSent = append(results.ProbesSent, probe.Name)
+		response, wrapped, err := scanner.sendProbe(&target, probe, results)
+		if err != nil {
+			if i == 0 {
+				return zgrab2.TryGetScanStatus(err), nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if wrapped && len(probe.Data) == 0 {
+			results.TCPWrapped = true
+			results.Service = &nmap.ServiceInfo{Service: "tcpwrapped", Probe: probe.Name}
+			return zgrab2.SCAN_SUCCESS, results, nil
+		}
+		if len(response) == 0 {
+			continue
+		}
+		if matched == nil {
+			matched = response
+		}
+		info := nmap.MatchResponse(probe, response)
+		if info == nil {
+			continue
+		}
+		if soft != nil && info.Service != soft.Service {
+			continue
+		}
+		if info.SoftMatch {
+			if soft == nil {
+				soft = info
+				matched = response
+			}
+			continue
+		}
+		results.Service = info
+		scanner.setBanner(results, response)
+		return zgrab2.SCAN_SUCCESS, results, nil
+	}
+	scanner.setBanner(results, matched)
+	if soft != nil {
+		results.Service = soft
+		return zgrab2.SCAN_SUCCESS, results, nil
+	}
+	if matched == nil && firstErr != nil {
+		return zgrab2.TryGetScanStatus(firstErr), results, firstErr
+	}
+	return zgrab2.SCAN_PROTOCOL_ERROR, results, ErrNoMatch
+}
+
+func (scanner *Scanner) setBanner(results *Results, response []byte) {
+	if scanner.config.Hex {
+		results.Banner = fmt.Sprintf("%x", response)
+	} else {
+		results.Banner = string(response)
+	}
+}
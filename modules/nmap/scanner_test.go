@@ -0,0 +1,202 @@
+package nmap
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+	"github.com/zmap/zgrab2/lib/nmap"
+)
+
+const testProbes = `Probe TCP NULL q||
+totalwaitms 300
+tcpwrappedms 200
+match ftp m|^220 ProFTPD (\S+) Server| p/ProFTPD/ v/$1/
+
+Probe TCP GetRequest q|GET / HTTP/1.0\r\n\r\n|
+rarity 1
+ports 80
+softmatch http m|^HTTP/1\.[01] \d\d\d|
+match http m|^HTTP/1\.[01] \d\d\d .*\r\nServer: nginx/([\d.]+)|s p/nginx/ v/$1/
+
+Probe TCP Help q|HELP\r\n|
+rarity 3
+match echo m|^HELP\r\n$|
+`
+
+// serve accepts connections on a local listener and calls handle for each of
+// them with the first read (if any), until the test ends.
+func serve(t *testing.T, handle func(conn net.Conn, request []byte)) uint {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1024)
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				n, _ := conn.Read(buf)
+				handle(conn, buf[:n])
+			}()
+		}
+	}()
+	return uint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func newScanner(t *testing.T) *Scanner {
+	probes, err := nmap.ParseServiceProbes(strings.NewReader(testProbes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Scanner{
+		config: &Flags{
+			BaseFlags: zgrab2.BaseFlags{Timeout: 2 * time.Second},
+			Intensity: 7,
+			MaxSize:   64,
+		},
+		probes: probes,
+	}
+}
+
+func scan(t *testing.T, port uint) (zgrab2.ScanStatus, *Results, error) {
+	scanner := newScanner(t)
+	scanner.config.Port = port
+	status, res, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	results, _ := res.(*Results)
+	return status, results, err
+}
+
+func TestScanNullProbeMatch(t *testing.T) {
+	port := serveBanner(t, "220 ProFTPD 1.3.6 Server (Debian)\r\n")
+	status, results, err := scan(t, port)
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("unexpected status %s: %v", status, err)
+	}
+	if results.Service.Service != "ftp" || results.Service.Version != "1.3.6" {
+		t.Errorf("wrong service: %+v", results.Service)
+	}
+	if !reflect.DeepEqual(results.ProbesSent, []string{"NULL"}) {
+		t.Errorf("wrong probes sent: %v", results.ProbesSent)
+	}
+}
+
+// serveBanner starts a server that immediately sends banner on connect.
+func serveBanner(t *testing.T, banner string) uint {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(banner))
+			time.Sleep(50 * time.Millisecond)
+			conn.Close()
+		}
+	}()
+	return uint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestScanSoftMatchThenHardMatch(t *testing.T) {
+	port := serve(t, func(conn net.Conn, request []byte) {
+		if strings.HasPrefix(string(request), "GET ") {
+			conn.Write([]byte("HTTP/1.0 200 OK\r\nServer: nginx/1.18.0\r\n\r\n"))
+		} else if len(request) > 0 {
+			conn.Write(request)
+		}
+	})
+	status, results, err := scan(t, port)
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("unexpected status %s: %v", status, err)
+	}
+	if results.Service.Service != "http" || results.Service.Product != "nginx" || results.Service.SoftMatch {
+		t.Errorf("wrong service: %+v", results.Service)
+	}
+	if !reflect.DeepEqual(results.ProbesSent, []string{"NULL", "GetRequest"}) {
+		t.Errorf("wrong probes sent: %v", results.ProbesSent)
+	}
+}
+
+func TestScanRarity(t *testing.T) {
+	port := serve(t, func(conn net.Conn, request []byte) {
+		if len(request) > 0 && !strings.HasPrefix(string(request), "GET ") {
+			conn.Write(request)
+		}
+	})
+	status, results, err := scan(t, port)
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("unexpected status %s: %v", status, err)
+	}
+	if results.Service.Service != "echo" {
+		t.Errorf("wrong service: %+v", results.Service)
+	}
+	if !reflect.DeepEqual(results.ProbesSent, []string{"NULL", "GetRequest", "Help"}) {
+		t.Errorf("wrong probes sent: %v", results.ProbesSent)
+	}
+
+	scanner := newScanner(t)
+	scanner.config.Port = port
+	scanner.config.Intensity = 2
+	status, _, err = scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_PROTOCOL_ERROR || err != ErrNoMatch {
+		t.Errorf("expected no match with --intensity=2, got %s: %v", status, err)
+	}
+}
+
+func TestScanTCPWrapped(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	status, results, err := scan(t, uint(listener.Addr().(*net.TCPAddr).Port))
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("unexpected status %s: %v", status, err)
+	}
+	if !results.TCPWrapped || results.Service.Service != "tcpwrapped" {
+		t.Errorf("expected tcpwrapped, got %+v", results.Service)
+	}
+}
+
+func TestScanConnectionRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+	status, _, err := scan(t, port)
+	if status == zgrab2.SCAN_SUCCESS || err == nil {
+		t.Errorf("expected failure, got %s", status)
+	}
+}
+
+func TestInitBadProbesFile(t *testing.T) {
+	scanner := new(Scanner)
+	if err := scanner.Init(&Flags{ProbesFile: "/nonexistent/nmap-service-probes"}); err == nil {
+		t.Error("expected an error for a missing probes file")
+	}
+}
@@ -0,0 +1,164 @@
+package rtsp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidResponse is returned when the server's response is not a valid
+// RTSP response.
+var ErrInvalidResponse = errors.New("invalid RTSP response")
+
+// maxBodySize bounds the size of the response bodies.
+const maxBodySize = 1 << 16
+
+// Response is a parsed RTSP response.
+type Response struct {
+	// Method and URL are those of the request.
+	Method string `json:"method"`
+	URL    string `json:"url"`
+
+	// StatusLine is the first line of the response; its parts follow.
+	StatusLine   string `json:"status_line,omitempty"`
+	Version      string `json:"version,omitempty"`
+	StatusCode   int    `json:"status_code,omitempty"`
+	ReasonPhrase string `json:"reason_phrase,omitempty"`
+
+	Server string `json:"server,omitempty"`
+
+	// Public lists the methods in the Public header of the response.
+	Public []string `json:"public,omitempty"`
+
+	// Authenticate holds the challenges of the WWW-Authenticate headers.
+	Authenticate []Challenge `json:"authenticate,omitempty"`
+
+	// ContentBase is the Content-Base header, relative to which the control
+	// URLs of the session description are resolved.
+	ContentBase string `json:"content_base,omitempty"`
+
+	// ContentType is the type of the body.
+	ContentType string `json:"content_type,omitempty"`
+
+	// Body is the body of the response. Debug only.
+	Body string `json:"body,omitempty" zgrab:"debug"`
+
+	// SDP is the parsed body, if it is a session description.
+	SDP *SessionDescription `json:"sdp,omitempty"`
+
+	// Error is set if the request failed, e.g. if the server closed the
+	// connection without responding.
+	Error string `json:"error,omitempty"`
+}
+
+// Challenge is an authentication challenge of a WWW-Authenticate header.
+type Challenge struct {
+	Scheme string `json:"scheme"`
+	Realm  string `json:"realm,omitempty"`
+}
+
+// encodeRequest returns the wire format of a request with no body.
+func encodeRequest(method, url string, cseq int, userAgent string, accept string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\n", method, url)
+	fmt.Fprintf(&b, "CSeq: %d\r\n", cseq)
+	if userAgent != "" {
+		fmt.Fprintf(&b, "User-Agent: %s\r\n", userAgent)
+	}
+	if accept != "" {
+		fmt.Fprintf(&b, "Accept: %s\r\n", accept)
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// readResponse reads a response from r into ret.
+func readResponse(r *bufio.Reader, ret *Response) error {
+	tp := textproto.NewReader(r)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "RTSP/") {
+		return fmt.Errorf("%w: bad status line %q", ErrInvalidResponse, line)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 3 {
+		return fmt.Errorf("%w: bad status line %q", ErrInvalidResponse, line)
+	}
+	ret.StatusLine, ret.Version, ret.StatusCode = line, parts[0], code
+	if len(parts) == 3 {
+		ret.ReasonPhrase = parts[2]
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	ret.Server = header.Get("Server")
+	for _, value := range header["Public"] {
+		for _, method := range strings.Split(value, ",") {
+			if method = strings.TrimSpace(method); method != "" {
+				ret.Public = append(ret.Public, method)
+			}
+		}
+	}
+	for _, value := range header["Www-Authenticate"] {
+		if challenge := parseChallenge(value); challenge.Scheme != "" {
+			ret.Authenticate = append(ret.Authenticate, challenge)
+		}
+	}
+	ret.ContentBase = header.Get("Content-Base")
+	ret.ContentType = header.Get("Content-Type")
+	if s := header.Get("Content-Length"); s != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 0 || n > maxBodySize {
+			return fmt.Errorf("%w: bad Content-Length %q", ErrInvalidResponse, s)
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return err
+		}
+		ret.Body = string(body)
+	}
+	if strings.HasPrefix(strings.ToLower(ret.ContentType), "application/sdp") {
+		ret.SDP = parseSDP(ret.Body)
+	}
+	return nil
+}
+
+// parseChallenge returns the scheme and realm of a WWW-Authenticate header
+// value, e.g. `Digest realm="IP Camera", nonce="0a4f113b"`.
+func parseChallenge(value string) Challenge {
+	value = strings.TrimSpace(value)
+	scheme, params, _ := strings.Cut(value, " ")
+	ret := Challenge{Scheme: scheme}
+	for params != "" {
+		var name string
+		name, params, _ = strings.Cut(strings.TrimLeft(params, " ,"), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		var param string
+		if strings.HasPrefix(params, `"`) {
+			end := strings.IndexByte(params[1:], '"')
+			if end < 0 {
+				param, params = params[1:], ""
+			} else {
+				param, params = params[1:end+1], params[end+2:]
+			}
+		} else {
+			param, params, _ = strings.Cut(params, ",")
+			param = strings.TrimSpace(param)
+		}
+		if name == "realm" {
+			ret.Realm = param
+		}
+	}
+	return ret
+}
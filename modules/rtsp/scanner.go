@@ -0,0 +1,269 @@
+// Package rtsp provides a zgrab2 module that probes for RTSP servers, such
+// as IP cameras.
+// Default Port: 554 (TCP)
+//
+// The scan sends an OPTIONS request, then a DESCRIBE request for each of
+// --paths in turn (and the common camera stream paths with
+// --common-paths), until one is answered with a session description. Each
+// request is sent over its own connection, as servers often close the
+// connection after an error; other failures, such as timeouts, end the
+// scan.
+//
+// The --tls flag tells the scanner to perform a TLS handshake immediately
+// after connecting (RTSPS), using the standard TLS flags. It does not
+// change the default port number from 554, so it should usually be coupled
+// with e.g. --port 322.
+//
+// The output holds the parsed responses: the Public methods, the Server
+// header, the WWW-Authenticate challenges and the parsed SDP body, with
+// its media types, codecs and control URLs.
+package rtsp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// commonPaths are the stream paths of common camera vendors, tried with
+// --common-paths.
+var commonPaths = []string{
+	"/live",
+	"/live.sdp",
+	"/stream1",
+	"/h264",
+	"/11",
+	"/onvif1",
+	"/media/video1",
+	"/videoMain",
+	"/axis-media/media.amp",
+	"/Streaming/Channels/101",
+	"/cam/realmonitor?channel=1&subtype=0",
+	"/live/ch00_0",
+}
+
+// Flags holds the command-line flags for the scanner.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.TLSFlags
+
+	UseTLS      bool   `long:"tls" description:"Perform a TLS handshake immediately after connecting (RTSPS)"`
+	Paths       string `long:"paths" default:"/" description:"Comma-separated paths sent DESCRIBE requests, until one is answered with a session description"`
+	CommonPaths bool   `long:"common-paths" description:"After --paths, also try the stream paths of common camera vendors"`
+	UserAgent   string `long:"user-agent" default:"zgrab/0.x" description:"Value of the User-Agent header; empty to omit it"`
+}
+
+// Results is the output of the scan.
+type Results struct {
+	// Options is the response to the OPTIONS request.
+	Options *Response `json:"options,omitempty"`
+
+	// Describe holds the responses to the DESCRIBE requests.
+	Describe []*Response `json:"describe,omitempty"`
+
+	// Stream is the URL whose DESCRIBE request was answered with a session
+	// description, if any.
+	Stream string `json:"stream,omitempty"`
+
+	// TLSLog is the standard TLS log of the first connection, if --tls is
+	// enabled.
+	TLSLog *zgrab2.TLSLog `json:"tls,omitempty"`
+}
+
+// Module is the zgrab2 module implementation
+type Module struct {
+}
+
+// Scanner holds the state for a single scan
+type Scanner struct {
+	config *Flags
+	paths  []string
+}
+
+// RegisterModule registers the module with zgrab2
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("rtsp", "RTSP", module.Description(), 554, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a flags instant to be populated with the command line args
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new RTSP scanner instance
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Description returns an overview of this module.
+func (module *Module) Description() string {
+	return "Send RTSP OPTIONS and DESCRIBE requests, optionally over TLS"
+}
+
+// Validate checks that the flags are valid
+func (cfg *Flags) Validate(args []string) error {
+	for _, path := range strings.Split(cfg.Paths, ",") {
+		if path = strings.TrimSpace(path); path != "" && !strings.HasPrefix(path, "/") {
+			log.Errorf("Invalid path %q (must start with /)", path)
+			return zgrab2.ErrInvalidArguments
+		}
+	}
+	return nil
+}
+
+// Help returns the module's help string
+func (cfg *Flags) Help() string {
+	return ""
+}
+
+// Init initialized the scanner
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	scanner.paths = nil
+	seen := make(map[string]bool)
+	paths := strings.Split(f.Paths, ",")
+	if f.CommonPaths {
+		paths = append(paths, commonPaths...)
+	}
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path != "" && !seen[path] {
+			seen[path] = true
+			scanner.paths = append(scanner.paths, path)
+		}
+	}
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// Protocol returns the protocol identifer for the scanner.
+func (scanner *Scanner) Protocol() string {
+	return "rtsp"
+}
+
+// GetName returns the module's name
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// baseURL returns the URL of the target, without a path.
+func (scanner *Scanner) baseURL(target *zgrab2.ScanTarget) string {
+	port := scanner.config.Port
+	if target.Port != nil {
+		port = *target.Port
+	}
+	scheme := "rtsp"
+	if scanner.config.UseTLS {
+		scheme = "rtsps"
+	}
+	return scheme + "://" + net.JoinHostPort(target.Host(), strconv.FormatUint(uint64(port), 10))
+}
+
+// open connects to the target, and performs the TLS handshake if --tls is
+// set.
+func (scanner *Scanner) open(target *zgrab2.ScanTarget, results *Results) (net.Conn, error) {
+	if !scanner.config.UseTLS {
+		return target.Open(&scanner.config.BaseFlags)
+	}
+	conn, err := target.OpenTLS(&scanner.config.BaseFlags, &scanner.config.TLSFlags)
+	if conn != nil && results.TLSLog == nil {
+		results.TLSLog = conn.GetLog()
+	}
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// send sends a request over a new connection and reads the response.
+func (scanner *Scanner) send(target *zgrab2.ScanTarget, results *Results, method, url string, cseq int) (*Response, error) {
+	conn, err := scanner.open(target, results)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	accept := ""
+	if method == "DESCRIBE" {
+		accept = "application/sdp"
+	}
+	if _, err := conn.Write(encodeRequest(method, url, cseq, scanner.config.UserAgent, accept)); err != nil {
+		return nil, err
+	}
+	ret := &Response{Method: method, URL: url}
+	if err := readResponse(bufio.NewReader(conn), ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// status returns the status for err.
+func status(err error) zgrab2.ScanStatus {
+	if errors.Is(err, ErrInvalidResponse) {
+		return zgrab2.SCAN_PROTOCOL_ERROR
+	}
+	return zgrab2.TryGetScanStatus(err)
+}
+
+// closed returns true if err means that the server closed the connection,
+// as cameras often do on unknown paths.
+func closed(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// Scan sends the OPTIONS request, then the DESCRIBE requests until one is
+// answered with a session description. Once OPTIONS is answered, the scan
+// succeeds, and the DESCRIBE requests that fail are recorded with their
+// error. The next paths are tried only if the server closed the connection;
+// on other errors, such as timeouts, the scan stops there.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	results := &Results{}
+	base := scanner.baseURL(&target)
+	options, err := scanner.send(&target, results, "OPTIONS", base+"/", 1)
+	if err != nil {
+		if results.TLSLog != nil {
+			return status(err), results, err
+		}
+		return status(err), nil, err
+	}
+	results.Options = options
+	for i, path := range scanner.paths {
+		url := base + path
+		describe, err := scanner.send(&target, results, "DESCRIBE", url, i+2)
+		if err != nil {
+			results.Describe = append(results.Describe, &Response{Method: "DESCRIBE", URL: url, Error: err.Error()})
+			if !closed(err) || target.Context().Err() != nil {
+				break
+			}
+			continue
+		}
+		results.Describe = append(results.Describe, describe)
+		if describe.StatusCode == 200 && describe.SDP != nil {
+			results.Stream = url
+			break
+		}
+	}
+	return zgrab2.SCAN_SUCCESS, results, nil
+}
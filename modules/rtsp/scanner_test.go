@@ -0,0 +1,166 @@
+package rtsp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+const sdp = "v=0\r\n" +
+	"o=- 1109162014219182 0 IN IP4 0.0.0.0\r\n" +
+	"s=HIK Media Server V3.4.96\r\n" +
+	"i=HIK Media Server Session Description : standard\r\n" +
+	"t=0 0\r\n" +
+	"a=control:*\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"i=Video Media\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=control:rtsp://127.0.0.1/Streaming/Channels/101/trackID=1\r\n" +
+	"m=audio 0 RTP/AVP 0\r\n" +
+	"a=control:trackID=2\r\n"
+
+// serve answers requests on a local port as a camera streaming on
+// /Streaming/Channels/101, which requires no authentication for it, and
+// digest authentication for /. It closes the connection on /live.sdp, and
+// does not answer on /hang.
+func serve(t *testing.T) uint {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				var cseq string
+				for {
+					header, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if header == "\r\n" {
+						break
+					}
+					if strings.HasPrefix(header, "CSeq: ") {
+						cseq = strings.TrimSpace(header[6:])
+					}
+				}
+				fields := strings.Fields(line)
+				path := strings.TrimPrefix(fields[1], "rtsp://"+conn.LocalAddr().String())
+				var response string
+				switch {
+				case fields[0] == "OPTIONS":
+					response = "RTSP/1.0 200 OK\r\nCSeq: " + cseq + "\r\n" +
+						"Public: OPTIONS, DESCRIBE, PLAY, PAUSE, SETUP, TEARDOWN, SET_PARAMETER, GET_PARAMETER\r\n\r\n"
+				case path == "/live.sdp":
+					return
+				case path == "/hang":
+					io.Copy(io.Discard, conn)
+					return
+				case path == "/":
+					response = "RTSP/1.0 401 Unauthorized\r\nCSeq: " + cseq + "\r\n" +
+						"WWW-Authenticate: Digest realm=\"IP Camera(C2358)\", nonce=\"a1d2e3\", stale=\"FALSE\"\r\n" +
+						"WWW-Authenticate: Basic realm=\"IP Camera(C2358)\"\r\n\r\n"
+				case path == "/Streaming/Channels/101":
+					response = "RTSP/1.0 200 OK\r\nCSeq: " + cseq + "\r\n" +
+						"Content-Type: application/sdp\r\n" +
+						"Content-Base: " + fields[1] + "/\r\n" +
+						fmt.Sprintf("Content-Length: %d\r\n\r\n", len(sdp)) + sdp
+				default:
+					response = "RTSP/1.0 404 Not Found\r\nCSeq: " + cseq + "\r\n\r\n"
+				}
+				conn.Write([]byte(response))
+			}()
+		}
+	}()
+	return uint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestScan(t *testing.T) {
+	flags := &Flags{
+		BaseFlags:   zgrab2.BaseFlags{Port: serve(t), Timeout: 2 * time.Second, BytesReadLimit: 1 << 16},
+		Paths:       "/",
+		CommonPaths: true,
+	}
+	scanner := new(Scanner)
+	scanner.Init(flags)
+	status, res, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("unexpected status %s, %v", status, err)
+	}
+	results := res.(*Results)
+	if public := results.Options.Public; len(public) != 8 || public[7] != "GET_PARAMETER" {
+		t.Errorf("unexpected Public methods %v", public)
+	}
+	if len(results.Describe) != 11 || !strings.HasSuffix(results.Stream, "/Streaming/Channels/101") {
+		t.Fatalf("unexpected DESCRIBE responses %d, %s", len(results.Describe), results.Stream)
+	}
+	auth := results.Describe[0].Authenticate
+	if len(auth) != 2 || auth[0] != (Challenge{Scheme: "Digest", Realm: "IP Camera(C2358)"}) || auth[1].Scheme != "Basic" {
+		t.Errorf("unexpected challenges %+v", auth)
+	}
+	if results.Describe[1].StatusCode != 404 {
+		t.Errorf("expected 404 for /live, got %+v", results.Describe[1])
+	}
+	if failed := results.Describe[2]; failed.StatusCode != 0 || failed.Error == "" || !strings.HasSuffix(failed.URL, "/live.sdp") {
+		t.Errorf("expected an error for /live.sdp, got %+v", failed)
+	}
+	session := results.Describe[10].SDP
+	if session == nil || session.Name != "HIK Media Server V3.4.96" || session.Control != "*" || len(session.Media) != 2 {
+		t.Fatalf("unexpected session description %+v", session)
+	}
+	video, audio := session.Media[0], session.Media[1]
+	if video.Type != "video" || video.Codecs[0] != "H264/90000" || !strings.HasSuffix(video.Control, "trackID=1") {
+		t.Errorf("unexpected video media %+v", video)
+	}
+	if audio.Type != "audio" || audio.Codecs[0] != "PCMU/8000" || audio.Control != "trackID=2" {
+		t.Errorf("unexpected audio media %+v", audio)
+	}
+}
+
+func TestScanTimeout(t *testing.T) {
+	flags := &Flags{
+		BaseFlags:   zgrab2.BaseFlags{Port: serve(t), Timeout: 200 * time.Millisecond, BytesReadLimit: 1 << 16},
+		Paths:       "/hang",
+		CommonPaths: true,
+	}
+	scanner := new(Scanner)
+	scanner.Init(flags)
+	status, res, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("unexpected status %s, %v", status, err)
+	}
+	results := res.(*Results)
+	if len(results.Describe) != 1 || results.Describe[0].Error == "" || results.Stream != "" {
+		t.Fatalf("expected only /hang to be tried, got %+v", results.Describe)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	for value, expected := range map[string]Challenge{
+		`Digest realm="a, b", nonce="x"`: {Scheme: "Digest", Realm: "a, b"},
+		`Basic realm=camera`:             {Scheme: "Basic", Realm: "camera"},
+		`Negotiate`:                      {Scheme: "Negotiate"},
+		`Digest nonce="x", realm="r`:     {Scheme: "Digest", Realm: "r"},
+	} {
+		if got := parseChallenge(value); got != expected {
+			t.Errorf("%s: expected %+v, got %+v", value, expected, got)
+		}
+	}
+}
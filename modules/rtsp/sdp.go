@@ -0,0 +1,118 @@
+package rtsp
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SessionDescription is a parsed SDP body (RFC 4566), as returned to a
+// DESCRIBE request.
+type SessionDescription struct {
+	Origin      string `json:"origin,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Information string `json:"information,omitempty"`
+
+	// Control is the session-level control URL.
+	Control string `json:"control,omitempty"`
+
+	Media []Media `json:"media,omitempty"`
+}
+
+// Media is a media description of a session description.
+type Media struct {
+	// Type is the media type, e.g. video or audio.
+	Type     string `json:"type"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol,omitempty"`
+
+	// Formats are the payload types of the media.
+	Formats []string `json:"formats,omitempty"`
+
+	// Codecs are the encodings of the formats, e.g. H264/90000, from the
+	// rtpmap attributes or the static payload types.
+	Codecs []string `json:"codecs,omitempty"`
+
+	// Control is the control URL of the media stream.
+	Control string `json:"control,omitempty"`
+}
+
+// staticPayloadTypes maps the static RTP payload types (RFC 3551) to their
+// encodings.
+var staticPayloadTypes = map[string]string{
+	"0":  "PCMU/8000",
+	"3":  "GSM/8000",
+	"8":  "PCMA/8000",
+	"9":  "G722/8000",
+	"14": "MPA/90000",
+	"26": "JPEG/90000",
+	"32": "MPV/90000",
+	"33": "MP2T/90000",
+}
+
+// parseSDP parses a session description, skipping the lines it does not
+// understand.
+func parseSDP(body string) *SessionDescription {
+	ret := &SessionDescription{}
+	var media *Media
+	var rtpmap map[string]string
+	finish := func() {
+		if media == nil {
+			return
+		}
+		for _, format := range media.Formats {
+			if codec, ok := rtpmap[format]; ok {
+				media.Codecs = append(media.Codecs, codec)
+			} else if codec, ok := staticPayloadTypes[format]; ok {
+				media.Codecs = append(media.Codecs, codec)
+			}
+		}
+		ret.Media = append(ret.Media, *media)
+	}
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) < 2 || line[1] != '=' {
+			continue
+		}
+		value := line[2:]
+		switch line[0] {
+		case 'o':
+			ret.Origin = value
+		case 's':
+			ret.Name = value
+		case 'i':
+			if media == nil {
+				ret.Information = value
+			}
+		case 'm':
+			finish()
+			fields := strings.Fields(value)
+			media = &Media{}
+			rtpmap = make(map[string]string)
+			if len(fields) > 0 {
+				media.Type = fields[0]
+			}
+			if len(fields) > 1 {
+				port, _, _ := strings.Cut(fields[1], "/")
+				media.Port, _ = strconv.Atoi(port)
+			}
+			if len(fields) > 2 {
+				media.Protocol = fields[2]
+				media.Formats = fields[3:]
+			}
+		case 'a':
+			name, attr, _ := strings.Cut(value, ":")
+			switch {
+			case name == "control" && media == nil:
+				ret.Control = attr
+			case name == "control":
+				media.Control = attr
+			case name == "rtpmap" && media != nil:
+				if format, codec, ok := strings.Cut(attr, " "); ok {
+					rtpmap[format] = strings.TrimSpace(codec)
+				}
+			}
+		}
+	}
+	finish()
+	return ret
+}
@@ -0,0 +1,284 @@
+// Package sip provides a zgrab2 module that probes for SIP servers.
+// NOTE: by default, this scans on UDP; --tcp sends the request over TCP,
+// and --tls over TLS, using the standard TLS flags for the handshake.
+// --tls does not change the default port number from 5060, so it should
+// usually be coupled with e.g. --port 5061.
+//
+// The scan sends an OPTIONS request (RFC 3261, section 11) and reads the
+// final response, skipping any provisional ones.
+//
+// The output holds the status line, the capability headers (Allow,
+// Supported and Accept), the Server and User-Agent headers, and the
+// received and rport parameters the server added to the Via header, which
+// give the address the request came from as seen by the server. Some
+// servers and middleboxes send the request back instead of answering it,
+// which is reported as reflected.
+package sip
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// Flags holds the command-line flags for the scanner.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.UDPFlags
+	zgrab2.TLSFlags
+
+	UseTCP    bool   `long:"tcp" description:"Send the request over TCP instead of UDP"`
+	UseTLS    bool   `long:"tls" description:"Send the request over TLS instead of UDP"`
+	ToUser    string `long:"to-user" description:"User part of the Request-URI; by default, the URI has none"`
+	FromUser  string `long:"from-user" default:"zgrab" description:"User part of the From and Contact URIs"`
+	UserAgent string `long:"user-agent" default:"zgrab/0.x" description:"Value of the User-Agent header; empty to omit it"`
+}
+
+// Results is the output of the scan.
+type Results struct {
+	// Transport is the transport of the request: UDP, TCP or TLS.
+	Transport string `json:"transport"`
+
+	// Reflected is set if the server sent the request back.
+	Reflected bool `json:"reflected"`
+
+	// StatusLine is the first line of the response; its parts follow.
+	StatusLine   string `json:"status_line,omitempty"`
+	Version      string `json:"version,omitempty"`
+	StatusCode   int    `json:"status_code,omitempty"`
+	ReasonPhrase string `json:"reason_phrase,omitempty"`
+
+	Server    string `json:"server,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+
+	// Allow, Supported and Accept are the methods, extensions and body
+	// types the server advertises.
+	Allow     []string `json:"allow,omitempty"`
+	Supported []string `json:"supported,omitempty"`
+	Accept    []string `json:"accept,omitempty"`
+
+	// Received and RPort are the parameters of the top Via header of the
+	// response, set by the server to the source address of the request.
+	Received string `json:"received,omitempty"`
+	RPort    int    `json:"rport,omitempty"`
+
+	// TLSLog is the standard TLS log, if --tls is enabled.
+	TLSLog *zgrab2.TLSLog `json:"tls,omitempty"`
+}
+
+// Module is the zgrab2 module implementation
+type Module struct {
+}
+
+// Scanner holds the state for a single scan
+type Scanner struct {
+	config *Flags
+}
+
+// RegisterModule registers the module with zgrab2
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("sip", "SIP", module.Description(), 5060, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a flags instant to be populated with the command line args
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new SIP scanner instance
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Description returns an overview of this module.
+func (module *Module) Description() string {
+	return "Send a SIP OPTIONS request over UDP, TCP or TLS"
+}
+
+// Validate checks that the flags are valid
+func (cfg *Flags) Validate(args []string) error {
+	if cfg.UseTCP && cfg.UseTLS {
+		log.Errorln("Cannot send both --tcp and --tls")
+		return zgrab2.ErrInvalidArguments
+	}
+	if cfg.FromUser == "" {
+		log.Errorln("--from-user must not be empty")
+		return zgrab2.ErrInvalidArguments
+	}
+	return nil
+}
+
+// Help returns the module's help string
+func (cfg *Flags) Help() string {
+	return ""
+}
+
+// Init initialized the scanner
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// Protocol returns the protocol identifer for the scanner.
+func (scanner *Scanner) Protocol() string {
+	return "sip"
+}
+
+// GetName returns the module's name
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// transport returns the Via transport of the configured connections.
+func (scanner *Scanner) transport() string {
+	switch {
+	case scanner.config.UseTLS:
+		return "TLS"
+	case scanner.config.UseTCP:
+		return "TCP"
+	default:
+		return "UDP"
+	}
+}
+
+// open connects to the target over the configured transport.
+func (scanner *Scanner) open(target *zgrab2.ScanTarget, results *Results) (net.Conn, error) {
+	switch {
+	case scanner.config.UseTLS:
+		conn, err := target.OpenTLS(&scanner.config.BaseFlags, &scanner.config.TLSFlags)
+		if conn != nil {
+			results.TLSLog = conn.GetLog()
+		}
+		if err != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			return nil, err
+		}
+		return conn, nil
+	case scanner.config.UseTCP:
+		return target.Open(&scanner.config.BaseFlags)
+	default:
+		return target.OpenUDP(&scanner.config.BaseFlags, &scanner.config.UDPFlags)
+	}
+}
+
+// requestURI returns the Request-URI for the target.
+func (scanner *Scanner) requestURI(target *zgrab2.ScanTarget) string {
+	host := target.Host()
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if scanner.config.ToUser != "" {
+		return "sip:" + scanner.config.ToUser + "@" + host
+	}
+	return "sip:" + host
+}
+
+// readResponse returns the final response to req. Over UDP, each datagram
+// holds one message, and messages with another Call-ID are ignored.
+func (scanner *Scanner) readResponse(conn net.Conn, req *request) (*message, error) {
+	var next func() (*message, error)
+	if scanner.transport() == "UDP" {
+		buf := make([]byte, 65535)
+		next = func() (*message, error) {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			msg, err := readMessage(bufio.NewReader(bytes.NewReader(buf[:n])), false)
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// truncated datagram
+				return nil, ErrInvalidResponse
+			}
+			return msg, err
+		}
+	} else {
+		r := bufio.NewReader(conn)
+		next = func() (*message, error) {
+			return readMessage(r, true)
+		}
+	}
+	for {
+		msg, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if msg.header.Get("Call-Id") != req.callID {
+			if scanner.transport() == "UDP" {
+				continue
+			}
+			return nil, ErrInvalidResponse
+		}
+		if !msg.isResponse() {
+			return msg, nil
+		}
+		if _, code, _, err := parseStatusLine(msg.startLine); err != nil || code >= 200 {
+			return msg, err
+		}
+	}
+}
+
+// Scan sends an OPTIONS request to the target and parses the final
+// response.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	results := &Results{Transport: scanner.transport()}
+	conn, err := scanner.open(&target, results)
+	if err != nil {
+		if results.TLSLog != nil {
+			return zgrab2.TryGetScanStatus(err), results, err
+		}
+		return zgrab2.TryGetScanStatus(err), nil, err
+	}
+	defer conn.Close()
+	req := newRequest(scanner.requestURI(&target), results.Transport, conn.LocalAddr().String(), scanner.config.FromUser, scanner.config.UserAgent)
+	if _, err := conn.Write(req.encode()); err != nil {
+		return zgrab2.TryGetScanStatus(err), nil, err
+	}
+	msg, err := scanner.readResponse(conn, req)
+	if err != nil {
+		if errors.Is(err, ErrInvalidResponse) {
+			return zgrab2.SCAN_PROTOCOL_ERROR, nil, err
+		}
+		return zgrab2.TryGetScanStatus(err), nil, err
+	}
+	if !msg.isResponse() {
+		results.Reflected = true
+		return zgrab2.SCAN_PROTOCOL_ERROR, results, ErrReflected
+	}
+	results.StatusLine = msg.startLine
+	results.Version, results.StatusCode, results.ReasonPhrase, _ = parseStatusLine(msg.startLine)
+	results.Server = msg.header.Get("Server")
+	results.UserAgent = msg.header.Get("User-Agent")
+	results.Allow = splitList(msg.header["Allow"])
+	results.Supported = splitList(msg.header["Supported"])
+	results.Accept = splitList(msg.header["Accept"])
+	if vias := splitList(msg.header["Via"]); len(vias) > 0 {
+		_, _, params := parseVia(vias[0])
+		results.Received = params["received"]
+		results.RPort, _ = strconv.Atoi(params["rport"])
+	}
+	return zgrab2.SCAN_SUCCESS, results, nil
+}
@@ -0,0 +1,165 @@
+package sip
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+// answer returns the responses of an Asterisk server to an OPTIONS request
+// from addr: a provisional response, and the final one in compact form.
+func answer(t *testing.T, request []byte, addr *net.UDPAddr) [][]byte {
+	msg, err := readMessage(bufio.NewReader(bytes.NewReader(request)), false)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	if !strings.HasPrefix(msg.startLine, "OPTIONS sip:127.0.0.1 SIP/2.0") {
+		t.Errorf("unexpected request line %q", msg.startLine)
+	}
+	via := fmt.Sprintf("%s;received=%s;rport=%d", msg.header.Get("Via"), addr.IP, addr.Port)
+	common := "v: " + via + "\r\n" +
+		"f: " + msg.header.Get("From") + "\r\n" +
+		"t: " + msg.header.Get("To") + ";tag=as1234\r\n" +
+		"i: " + msg.header.Get("Call-Id") + "\r\n" +
+		"CSeq: 1 OPTIONS\r\n"
+	return [][]byte{
+		[]byte("SIP/2.0 100 Trying\r\n" + common + "l: 0\r\n\r\n"),
+		[]byte("SIP/2.0 200 OK\r\n" + common +
+			"Server: Asterisk PBX 18.10.0\r\n" +
+			"Allow: INVITE, ACK, CANCEL, OPTIONS, BYE\r\n" +
+			"Allow: REFER, NOTIFY\r\n" +
+			"k: replaces, timer\r\n" +
+			"Accept: application/sdp\r\n" +
+			"c: application/sdp\r\n" +
+			"l: 9\r\n\r\n" +
+			"v=0\r\ns=-\r\n"),
+	}
+}
+
+// serveUDP answers the requests on a local UDP port, after a stray response
+// with another Call-ID. If reflect is set, it sends the requests back
+// instead.
+func serveUDP(t *testing.T, reflect bool) uint {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reflect {
+				conn.WriteTo(buf[:n], addr)
+				continue
+			}
+			conn.WriteTo([]byte("SIP/2.0 200 OK\r\ni: other\r\n\r\n"), addr)
+			for _, response := range answer(t, buf[:n], addr.(*net.UDPAddr)) {
+				conn.WriteTo(response, addr)
+			}
+		}
+	}()
+	return uint(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// serveTCP answers the requests on a local TCP port.
+func serveTCP(t *testing.T) uint {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				var request bytes.Buffer
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					request.WriteString(line)
+					if line == "\r\n" {
+						break
+					}
+				}
+				addr := conn.RemoteAddr().(*net.TCPAddr)
+				for _, response := range answer(t, request.Bytes(), &net.UDPAddr{IP: addr.IP, Port: addr.Port}) {
+					conn.Write(response)
+				}
+			}()
+		}
+	}()
+	return uint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func scan(port uint, tcp bool) (zgrab2.ScanStatus, *Results, error) {
+	flags := &Flags{
+		BaseFlags: zgrab2.BaseFlags{Port: port, Timeout: 2 * time.Second, BytesReadLimit: 1 << 16},
+		UseTCP:    tcp,
+		FromUser:  "zgrab",
+		UserAgent: "zgrab/0.x",
+	}
+	scanner := new(Scanner)
+	scanner.Init(flags)
+	status, res, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	results, _ := res.(*Results)
+	return status, results, err
+}
+
+func TestScan(t *testing.T) {
+	for _, tcp := range []bool{false, true} {
+		var port uint
+		if tcp {
+			port = serveTCP(t)
+		} else {
+			port = serveUDP(t, false)
+		}
+		status, results, err := scan(port, tcp)
+		if status != zgrab2.SCAN_SUCCESS || err != nil {
+			t.Fatalf("tcp %v: unexpected status %s, %v", tcp, status, err)
+		}
+		if results.StatusCode != 200 || results.ReasonPhrase != "OK" || results.Server != "Asterisk PBX 18.10.0" {
+			t.Errorf("tcp %v: unexpected response %+v", tcp, results)
+		}
+		if len(results.Allow) != 7 || results.Allow[6] != "NOTIFY" {
+			t.Errorf("tcp %v: unexpected Allow %v", tcp, results.Allow)
+		}
+		if strings.Join(results.Supported, ",") != "replaces,timer" || results.Accept[0] != "application/sdp" {
+			t.Errorf("tcp %v: unexpected capabilities %v, %v", tcp, results.Supported, results.Accept)
+		}
+		if results.Received != "127.0.0.1" || results.RPort == 0 || results.Reflected {
+			t.Errorf("tcp %v: unexpected Via parameters %+v", tcp, results)
+		}
+	}
+}
+
+func TestReflected(t *testing.T) {
+	status, results, err := scan(serveUDP(t, true), false)
+	if status != zgrab2.SCAN_PROTOCOL_ERROR || err != ErrReflected || !results.Reflected {
+		t.Errorf("expected a reflected request, got %s, %v", status, err)
+	}
+}
+
+func TestParseVia(t *testing.T) {
+	transport, sentBy, params := parseVia("SIP/2.0/UDP 10.0.0.1:5060;branch=z9hG4bK776;received=192.0.2.4;rport=6000")
+	if transport != "UDP" || sentBy != "10.0.0.1:5060" || params["received"] != "192.0.2.4" || params["rport"] != "6000" {
+		t.Errorf("unexpected Via %s %s %v", transport, sentBy, params)
+	}
+}
@@ -0,0 +1,202 @@
+package sip
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidResponse is returned when the server's response is not a
+	// valid SIP message.
+	ErrInvalidResponse = errors.New("invalid SIP response")
+
+	// ErrReflected is returned when the server sent the request back instead
+	// of answering it.
+	ErrReflected = errors.New("server reflected the request")
+)
+
+// maxBodySize bounds the bodies skipped over a stream transport.
+const maxBodySize = 1 << 16
+
+// compactForms maps the canonical compact header names (RFC 3261, section
+// 7.3.3) to the full ones.
+var compactForms = map[string]string{
+	"C": "Content-Type",
+	"E": "Content-Encoding",
+	"F": "From",
+	"I": "Call-Id",
+	"K": "Supported",
+	"L": "Content-Length",
+	"M": "Contact",
+	"S": "Subject",
+	"T": "To",
+	"V": "Via",
+}
+
+// request holds the parameters of an OPTIONS request.
+type request struct {
+	// uri is the Request-URI, which is also the URI of the To header.
+	uri string
+
+	// transport is the Via transport: UDP, TCP or TLS.
+	transport string
+
+	// local is the host:port of the local end of the connection.
+	local string
+
+	fromUser  string
+	userAgent string
+
+	branch string
+	tag    string
+	callID string
+}
+
+// newRequest returns an OPTIONS request for uri with random identifiers.
+func newRequest(uri, transport, local, fromUser, userAgent string) *request {
+	return &request{
+		uri:       uri,
+		transport: transport,
+		local:     local,
+		fromUser:  fromUser,
+		userAgent: userAgent,
+		// The magic cookie identifies RFC 3261 branches.
+		branch: "z9hG4bK" + randomToken(),
+		tag:    randomToken(),
+		callID: randomToken(),
+	}
+}
+
+// randomToken returns a random hexadecimal token.
+func randomToken() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
+
+// encode returns the wire format of the request.
+func (r *request) encode() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "OPTIONS %s SIP/2.0\r\n", r.uri)
+	fmt.Fprintf(&b, "Via: SIP/2.0/%s %s;branch=%s;rport\r\n", r.transport, r.local, r.branch)
+	b.WriteString("Max-Forwards: 70\r\n")
+	fmt.Fprintf(&b, "To: <%s>\r\n", r.uri)
+	fmt.Fprintf(&b, "From: <sip:%s@%s>;tag=%s\r\n", r.fromUser, r.local, r.tag)
+	fmt.Fprintf(&b, "Call-ID: %s\r\n", r.callID)
+	b.WriteString("CSeq: 1 OPTIONS\r\n")
+	fmt.Fprintf(&b, "Contact: <sip:%s@%s;transport=%s>\r\n", r.fromUser, r.local, strings.ToLower(r.transport))
+	b.WriteString("Accept: application/sdp\r\n")
+	if r.userAgent != "" {
+		fmt.Fprintf(&b, "User-Agent: %s\r\n", r.userAgent)
+	}
+	b.WriteString("Content-Length: 0\r\n\r\n")
+	return []byte(b.String())
+}
+
+// message is a SIP request or response, without its body.
+type message struct {
+	startLine string
+	header    textproto.MIMEHeader
+}
+
+// isResponse returns true if the message is a response.
+func (m *message) isResponse() bool {
+	return strings.HasPrefix(m.startLine, "SIP/")
+}
+
+// readMessage reads a message from r. If skipBody is set, the body given by
+// the Content-Length header is read and discarded, as the next message
+// follows it on a stream transport.
+func readMessage(r *bufio.Reader, skipBody bool) (*message, error) {
+	tp := textproto.NewReader(r)
+	line, err := tp.ReadLine()
+	for err == nil && line == "" {
+		// keep-alives (RFC 5626, section 3.5.1)
+		line, err = tp.ReadLine()
+	}
+	if err != nil {
+		return nil, err
+	}
+	raw, err := tp.ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	header := make(textproto.MIMEHeader, len(raw))
+	for key, values := range raw {
+		if full, ok := compactForms[key]; ok {
+			key = full
+		}
+		header[key] = append(header[key], values...)
+	}
+	ret := &message{startLine: line, header: header}
+	if skipBody {
+		if s := header.Get("Content-Length"); s != "" {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || n < 0 || n > maxBodySize {
+				return nil, fmt.Errorf("%w: bad Content-Length %q", ErrInvalidResponse, s)
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ret, nil
+}
+
+// parseStatusLine splits a Status-Line into its version, status code and
+// reason phrase.
+func parseStatusLine(line string) (version string, code int, reason string, err error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "SIP/") {
+		return "", 0, "", fmt.Errorf("%w: bad status line %q", ErrInvalidResponse, line)
+	}
+	code, err = strconv.Atoi(parts[1])
+	if err != nil || code < 100 || code > 699 {
+		return "", 0, "", fmt.Errorf("%w: bad status line %q", ErrInvalidResponse, line)
+	}
+	if len(parts) == 3 {
+		reason = parts[2]
+	}
+	return parts[0], code, reason, nil
+}
+
+// splitList returns the non-empty elements of comma-separated header values.
+func splitList(values []string) []string {
+	var ret []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				ret = append(ret, item)
+			}
+		}
+	}
+	return ret
+}
+
+// parseVia returns the transport, sent-by address and parameters of a Via
+// header value.
+func parseVia(value string) (transport, sentBy string, params map[string]string) {
+	parts := strings.Split(value, ";")
+	fields := strings.Fields(parts[0])
+	if len(fields) > 0 {
+		if i := strings.LastIndex(fields[0], "/"); i >= 0 {
+			transport = fields[0][i+1:]
+		}
+	}
+	if len(fields) > 1 {
+		sentBy = fields[1]
+	}
+	params = make(map[string]string)
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		params[strings.ToLower(name)] = value
+	}
+	return transport, sentBy, params
+}
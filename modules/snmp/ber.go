@@ -0,0 +1,203 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidBER is returned when a message is not valid BER.
+var ErrInvalidBER = errors.New("invalid BER encoding")
+
+// BER identifiers of the types used by SNMP (RFC 1157, RFC 3416).
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+
+	tagIPAddress = 0x40
+	tagCounter32 = 0x41
+	tagGauge32   = 0x42
+	tagTimeTicks = 0x43
+	tagOpaque    = 0x44
+	tagCounter64 = 0x46
+
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+
+	tagGetRequest  = 0xa0
+	tagGetResponse = 0xa2
+	tagReport      = 0xa8
+)
+
+// appendTLV appends an element with the given identifier and content to b.
+func appendTLV(b []byte, tag byte, content []byte) []byte {
+	b = append(b, tag)
+	switch n := len(content); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, content...)
+}
+
+// appendInteger appends an INTEGER, or another integer type with tag, to b.
+func appendInteger(b []byte, tag byte, v int64) []byte {
+	var content []byte
+	for {
+		content = append([]byte{byte(v)}, content...)
+		if (v < 0x80 && v >= -0x80) || len(content) == 8 {
+			break
+		}
+		v >>= 8
+	}
+	return appendTLV(b, tag, content)
+}
+
+// appendOID appends an OBJECT IDENTIFIER given in dotted notation to b.
+func appendOID(b []byte, oid string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(oid, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+	arcs := make([]uint64, len(parts))
+	for i, part := range parts {
+		arc, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", oid)
+		}
+		arcs[i] = arc
+	}
+	if arcs[0] > 2 || (arcs[0] < 2 && arcs[1] >= 40) {
+		return nil, fmt.Errorf("invalid OID %q", oid)
+	}
+	var content []byte
+	for _, arc := range append([]uint64{arcs[0]*40 + arcs[1]}, arcs[2:]...) {
+		var enc []byte
+		for {
+			enc = append([]byte{byte(arc & 0x7f)}, enc...)
+			arc >>= 7
+			if arc == 0 {
+				break
+			}
+		}
+		for j := 0; j < len(enc)-1; j++ {
+			enc[j] |= 0x80
+		}
+		content = append(content, enc...)
+	}
+	return appendTLV(b, tagOID, content), nil
+}
+
+// parseTLV splits the first element of b into its identifier and content,
+// and returns the rest of b.
+func parseTLV(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, ErrInvalidBER
+	}
+	tag, length := b[0], int(b[1])
+	b = b[2:]
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 || len(b) < n {
+			return 0, nil, nil, ErrInvalidBER
+		}
+		length = 0
+		for _, c := range b[:n] {
+			length = length<<8 | int(c)
+		}
+		b = b[n:]
+	}
+	if length > len(b) {
+		return 0, nil, nil, ErrInvalidBER
+	}
+	return tag, b[:length], b[length:], nil
+}
+
+// parseExpected parses the first element of b, which must have the given
+// identifier.
+func parseExpected(b []byte, tag byte) (content, rest []byte, err error) {
+	got, content, rest, err := parseTLV(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if got != tag {
+		return nil, nil, fmt.Errorf("%w: expected type 0x%02x, got 0x%02x", ErrInvalidBER, tag, got)
+	}
+	return content, rest, nil
+}
+
+// parseInteger parses an element with the given integer type.
+func parseInteger(b []byte, tag byte) (int64, []byte, error) {
+	content, rest, err := parseExpected(b, tag)
+	if err != nil {
+		return 0, nil, err
+	}
+	v, err := decodeInteger(content)
+	return v, rest, err
+}
+
+// decodeInteger decodes the two's complement content of an INTEGER.
+func decodeInteger(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, ErrInvalidBER
+	}
+	v := int64(int8(content[0]))
+	for _, c := range content[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
+
+// decodeUnsigned decodes the content of an unsigned integer type, such as a
+// Counter64, whose content may have a leading zero.
+func decodeUnsigned(content []byte) (uint64, error) {
+	if len(content) == 0 || len(content) > 9 || (len(content) == 9 && content[0] != 0) {
+		return 0, ErrInvalidBER
+	}
+	var v uint64
+	for _, c := range content {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// decodeOID returns the dotted notation of the content of an OBJECT
+// IDENTIFIER.
+func decodeOID(content []byte) (string, error) {
+	var arcs []string
+	var arc uint64
+	for i, c := range content {
+		if arc > 1<<56 {
+			return "", ErrInvalidBER
+		}
+		arc = arc<<7 | uint64(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(content)-1 {
+				return "", ErrInvalidBER
+			}
+			continue
+		}
+		if len(arcs) == 0 {
+			first := arc / 40
+			if first > 2 {
+				first = 2
+			}
+			arcs = append(arcs, strconv.FormatUint(first, 10), strconv.FormatUint(arc-first*40, 10))
+		} else {
+			arcs = append(arcs, strconv.FormatUint(arc, 10))
+		}
+		arc = 0
+	}
+	if len(arcs) == 0 {
+		return "", ErrInvalidBER
+	}
+	return strings.Join(arcs, "."), nil
+}
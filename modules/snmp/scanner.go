@@ -0,0 +1,337 @@
+// Package snmp provides a zgrab2 module that probes for SNMP agents.
+// NOTE: unlike most modules, this scans on UDP.
+//
+// For v1 and v2c, the scan sends a GetRequest for sysDescr, sysObjectID,
+// sysUpTime, sysContact, sysName and sysLocation with each of --communities
+// in turn, until one is answered (agents silently drop the requests with an
+// unknown community).
+//
+// For v3, it sends an unauthenticated discovery request, which agents answer
+// with a Report carrying their engine ID, boots and time, as described in
+// RFC 3414, section 4.
+//
+// The messages are encoded by hand in BER.
+package snmp
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2"
+)
+
+// Flags holds the command-line flags for the scanner.
+type Flags struct {
+	zgrab2.BaseFlags
+	zgrab2.UDPFlags
+
+	Communities string `long:"communities" default:"public" description:"Comma-separated communities tried in turn with v1 and v2c, until one is answered"`
+	Versions    string `long:"versions" default:"1,2c,3" description:"Comma-separated SNMP versions to probe: 1, 2c and 3"`
+}
+
+// Results is the output of the scan.
+type Results struct {
+	// V1 and V2c are the responses to the GetRequests, if any community
+	// was answered.
+	V1  *CommunityResponse `json:"v1,omitempty"`
+	V2c *CommunityResponse `json:"v2c,omitempty"`
+
+	// V3 holds the engine parameters returned to the discovery request.
+	V3 *Engine `json:"v3,omitempty"`
+}
+
+// CommunityResponse is the response to a v1 or v2c GetRequest.
+type CommunityResponse struct {
+	// Community is the community that was answered.
+	Community string `json:"community"`
+
+	// ErrorStatus and ErrorIndex are set if the agent returned an error,
+	// such as noSuchName in v1 for an object it does not have.
+	ErrorStatus string `json:"error_status,omitempty"`
+	ErrorIndex  int64  `json:"error_index,omitempty"`
+
+	// System holds the values of the system group.
+	System *System `json:"system,omitempty"`
+
+	// Bindings are the variable bindings of the response. Debug only.
+	Bindings []VarBind `json:"bindings,omitempty" zgrab:"debug"`
+}
+
+// System holds the objects of the system group (RFC 3418).
+type System struct {
+	Descr    string `json:"sys_descr,omitempty"`
+	ObjectID string `json:"sys_object_id,omitempty"`
+
+	// UpTime is the time since the agent started, in hundredths of a
+	// second.
+	UpTime uint64 `json:"sys_up_time,omitempty"`
+
+	Contact  string `json:"sys_contact,omitempty"`
+	Name     string `json:"sys_name,omitempty"`
+	Location string `json:"sys_location,omitempty"`
+}
+
+// Module is the zgrab2 module implementation
+type Module struct {
+}
+
+// Scanner holds the state for a single scan
+type Scanner struct {
+	config      *Flags
+	communities []string
+	versions    []string
+}
+
+// RegisterModule registers the module with zgrab2
+func RegisterModule() {
+	var module Module
+	_, err := zgrab2.AddCommand("snmp", "SNMP", module.Description(), 161, &module)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// NewFlags returns a flags instant to be populated with the command line args
+func (module *Module) NewFlags() interface{} {
+	return new(Flags)
+}
+
+// NewScanner returns a new SNMP scanner instance
+func (module *Module) NewScanner() zgrab2.Scanner {
+	return new(Scanner)
+}
+
+// Description returns an overview of this module.
+func (module *Module) Description() string {
+	return "Probe for SNMP agents, with v1/v2c communities and v3 engine discovery"
+}
+
+// Validate checks that the flags are valid
+func (cfg *Flags) Validate(args []string) error {
+	versions := splitList(cfg.Versions)
+	if len(versions) == 0 {
+		log.Errorln("No SNMP version given")
+		return zgrab2.ErrInvalidArguments
+	}
+	communityBased := false
+	for _, version := range versions {
+		if version != "1" && version != "2c" && version != "3" {
+			log.Errorf("Invalid SNMP version %q (must be 1, 2c or 3)", version)
+			return zgrab2.ErrInvalidArguments
+		}
+		if version != "3" {
+			communityBased = true
+		}
+	}
+	if len(splitList(cfg.Communities)) == 0 && communityBased {
+		log.Errorln("No community given")
+		return zgrab2.ErrInvalidArguments
+	}
+	return nil
+}
+
+// Help returns the module's help string
+func (cfg *Flags) Help() string {
+	return ""
+}
+
+// splitList returns the non-empty elements of a comma-separated list.
+func splitList(s string) []string {
+	var ret []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// Init initialized the scanner
+func (scanner *Scanner) Init(flags zgrab2.ScanFlags) error {
+	f, _ := flags.(*Flags)
+	scanner.config = f
+	scanner.communities = splitList(f.Communities)
+	scanner.versions = splitList(f.Versions)
+	return nil
+}
+
+// InitPerSender initializes the scanner for a given sender
+func (scanner *Scanner) InitPerSender(senderID int) error {
+	return nil
+}
+
+// Protocol returns the protocol identifer for the scanner.
+func (scanner *Scanner) Protocol() string {
+	return "snmp"
+}
+
+// GetName returns the module's name
+func (scanner *Scanner) GetName() string {
+	return scanner.config.Name
+}
+
+// GetTrigger returns the Trigger defined in the Flags.
+func (scanner *Scanner) GetTrigger() string {
+	return scanner.config.Trigger
+}
+
+// exchange sends request over a new socket, and returns the first response
+// accepted by decode, which returns false for the responses to other
+// requests.
+func (scanner *Scanner) exchange(target *zgrab2.ScanTarget, request []byte, decode func([]byte) (bool, error)) error {
+	conn, err := target.OpenUDP(&scanner.config.BaseFlags, &scanner.config.UDPFlags)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		if ok, err := decode(buf[:n]); ok || err != nil {
+			return err
+		}
+	}
+}
+
+// get sends a GetRequest for the system group with community.
+func (scanner *Scanner) get(target *zgrab2.ScanTarget, version int64, community string) (*CommunityResponse, error) {
+	requestID := rand.Int63n(1 << 31)
+	request, err := encodeGetRequest(version, community, requestID, systemOIDs)
+	if err != nil {
+		return nil, err
+	}
+	var response *pdu
+	err = scanner.exchange(target, request, func(b []byte) (bool, error) {
+		gotVersion, _, p, err := decodeCommunityResponse(b)
+		if err != nil {
+			return false, err
+		}
+		if gotVersion != version || p.requestID != requestID {
+			return false, nil
+		}
+		response = p
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ret := &CommunityResponse{
+		Community:   community,
+		ErrorStatus: response.errorName(),
+		ErrorIndex:  response.errorIndex,
+		Bindings:    response.bindings,
+	}
+	if response.errorStatus == 0 {
+		ret.System = newSystem(response.bindings)
+	}
+	return ret, nil
+}
+
+// newSystem returns the objects of the system group among bindings.
+func newSystem(bindings []VarBind) *System {
+	system := &System{}
+	for _, vb := range bindings {
+		if vb.Type != "OCTET STRING" && vb.Type != "OBJECT IDENTIFIER" && vb.Type != "TimeTicks" {
+			// exceptions
+			continue
+		}
+		switch vb.OID {
+		case oidSysDescr:
+			system.Descr = vb.Value
+		case oidSysObjectID:
+			system.ObjectID = vb.Value
+		case oidSysUpTime:
+			system.UpTime, _ = strconv.ParseUint(vb.Value, 10, 64)
+		case oidSysContact:
+			system.Contact = vb.Value
+		case oidSysName:
+			system.Name = vb.Value
+		case oidSysLocation:
+			system.Location = vb.Value
+		}
+	}
+	return system
+}
+
+// discover sends a v3 discovery request.
+func (scanner *Scanner) discover(target *zgrab2.ScanTarget) (*Engine, error) {
+	msgID, requestID := rand.Int63n(1<<31), rand.Int63n(1<<31)
+	var engine *Engine
+	err := scanner.exchange(target, encodeDiscovery(msgID, requestID), func(b []byte) (bool, error) {
+		gotID, e, p, err := decodeDiscoveryResponse(b)
+		if e == nil || gotID != msgID {
+			return false, err
+		}
+		engine = e
+		if p != nil {
+			engine.Report = p.bindings
+		}
+		return true, nil
+	})
+	return engine, err
+}
+
+// Scan probes the target with each of the configured versions. It succeeds
+// if any of them is answered; otherwise, it fails with the first error.
+func (scanner *Scanner) Scan(target zgrab2.ScanTarget) (zgrab2.ScanStatus, interface{}, error) {
+	result := &Results{}
+	var firstErr error
+	answered := false
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, version := range scanner.versions {
+		if version == "3" {
+			engine, err := scanner.discover(&target)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			result.V3 = engine
+			answered = true
+			continue
+		}
+		number := int64(versionV1)
+		if version == "2c" {
+			number = versionV2c
+		}
+		for _, community := range scanner.communities {
+			res, err := scanner.get(&target, number, community)
+			if err != nil {
+				fail(err)
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					continue
+				}
+				// refused, or not an SNMP agent
+				break
+			}
+			if number == versionV1 {
+				result.V1 = res
+			} else {
+				result.V2c = res
+			}
+			answered = true
+			break
+		}
+	}
+	if !answered {
+		if errors.Is(firstErr, ErrInvalidBER) || errors.Is(firstErr, ErrUnexpectedResponse) {
+			return zgrab2.SCAN_PROTOCOL_ERROR, nil, firstErr
+		}
+		return zgrab2.TryGetScanStatus(firstErr), nil, firstErr
+	}
+	return zgrab2.SCAN_SUCCESS, result, nil
+}
@@ -0,0 +1,178 @@
+package snmp
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zmap/zgrab2"
+)
+
+// binding returns an encoded variable binding.
+func binding(t *testing.T, oid string, tag byte, value []byte) []byte {
+	b, err := appendOID(nil, oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return appendTLV(nil, tagSequence, appendTLV(b, tag, value))
+}
+
+// response returns an encoded response or report PDU.
+func response(tag byte, requestID int64, bindings ...[]byte) []byte {
+	pdu := appendInteger(nil, tagInteger, requestID)
+	pdu = appendInteger(pdu, tagInteger, 0)
+	pdu = appendInteger(pdu, tagInteger, 0)
+	var list []byte
+	for _, b := range bindings {
+		list = append(list, b...)
+	}
+	pdu = appendTLV(pdu, tagSequence, list)
+	return appendTLV(nil, tag, pdu)
+}
+
+// agent answers v1 and v2c requests with the community "private", and v3
+// discovery requests, on a local UDP port.
+func agent(t *testing.T) uint {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	engineID, _ := hex.DecodeString("80001f8803525400123456")
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			msg, _, err := parseExpected(buf[:n], tagSequence)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			version, msg, _ := parseInteger(msg, tagInteger)
+			reply := appendInteger(nil, tagInteger, version)
+			if version == versionV3 {
+				global, _, _ := parseExpected(msg, tagSequence)
+				msgID, _, _ := parseInteger(global, tagInteger)
+				reply = appendTLV(reply, tagSequence, appendInteger(nil, tagInteger, msgID))
+				usm := appendTLV(nil, tagOctetString, engineID)
+				usm = appendInteger(usm, tagInteger, 5)
+				usm = appendInteger(usm, tagInteger, 1234)
+				reply = appendTLV(reply, tagOctetString, appendTLV(nil, tagSequence, usm))
+				scoped := appendTLV(nil, tagOctetString, engineID)
+				scoped = appendTLV(scoped, tagOctetString, nil)
+				scoped = append(scoped, response(tagReport, 0, binding(t, "1.3.6.1.6.3.15.1.1.4.0", tagCounter32, []byte{1}))...)
+				reply = appendTLV(reply, tagSequence, scoped)
+			} else {
+				community, msg, _ := parseExpected(msg, tagOctetString)
+				if string(community) != "private" {
+					continue
+				}
+				pdu, _, _ := parseExpected(msg, tagGetRequest)
+				requestID, _, _ := parseInteger(pdu, tagInteger)
+				reply = appendTLV(reply, tagOctetString, community)
+				reply = append(reply, response(tagGetResponse, requestID,
+					binding(t, oidSysDescr, tagOctetString, []byte("Linux router 5.10\r\n")),
+					binding(t, oidSysObjectID, tagOID, []byte{0x2b, 6, 1, 4, 1, 0x8f, 0x65, 1}),
+					binding(t, oidSysUpTime, tagTimeTicks, []byte{0, 0x98, 0x96, 0x80}),
+					binding(t, oidSysContact, tagNoSuchObject, nil),
+					binding(t, oidSysName, tagOctetString, []byte("router")),
+				)...)
+			}
+			conn.WriteTo(appendTLV(nil, tagSequence, reply), addr)
+		}
+	}()
+	return uint(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestScan(t *testing.T) {
+	flags := &Flags{
+		BaseFlags:   zgrab2.BaseFlags{Port: agent(t), Timeout: 500 * time.Millisecond, BytesReadLimit: 1 << 16},
+		Communities: "public,private",
+		Versions:    "2c,3",
+	}
+	scanner := new(Scanner)
+	scanner.Init(flags)
+	status, res, err := scanner.Scan(zgrab2.ScanTarget{IP: net.ParseIP("127.0.0.1")})
+	if status != zgrab2.SCAN_SUCCESS || err != nil {
+		t.Fatalf("unexpected status %s, %v", status, err)
+	}
+	results := res.(*Results)
+	if results.V1 != nil || results.V2c == nil || results.V2c.Community != "private" {
+		t.Fatalf("expected a v2c response to private, got %+v", results)
+	}
+	expected := System{Descr: "Linux router 5.10\r\n", ObjectID: "1.3.6.1.4.1.2021.1", UpTime: 10000000, Name: "router"}
+	if *results.V2c.System != expected {
+		t.Errorf("expected %+v, got %+v", expected, *results.V2c.System)
+	}
+	if contact := results.V2c.Bindings[3]; contact.Type != "noSuchObject" {
+		t.Errorf("expected noSuchObject for sysContact, got %+v", contact)
+	}
+	engine := results.V3
+	if engine == nil || engine.Boots != 5 || engine.Time != 1234 || len(engine.Report) != 1 || engine.Report[0].Value != "1" {
+		t.Fatalf("unexpected v3 engine %+v", engine)
+	}
+	if id := *engine.ID; id.Enterprise != 8072 || id.Format != "mac" || id.Data != "52:54:00:12:34:56" {
+		t.Errorf("unexpected engine ID %+v", id)
+	}
+}
+
+func TestDecodeEngineID(t *testing.T) {
+	for raw, expected := range map[string]EngineID{
+		"8000000901c0a80101":         {Enterprise: 9, Format: "ipv4", Data: "192.168.1.1"},
+		"80001f88046e65742d736e6d70": {Enterprise: 8072, Format: "text", Data: "net-snmp"},
+		"80001f888059dc486145a26314": {Enterprise: 8072, Format: "enterprise", Data: "59dc486145a26314"},
+		"0000000901020304050607ff":   {Enterprise: 9, Format: "enterprise", Data: "01020304050607ff"},
+	} {
+		b, _ := hex.DecodeString(raw)
+		expected.Raw = raw
+		if got := DecodeEngineID(b); *got != expected {
+			t.Errorf("%s: expected %+v, got %+v", raw, expected, *got)
+		}
+	}
+}
+
+func TestBER(t *testing.T) {
+	for _, v := range []int64{0, 127, 128, 255, 256, -1, -128, -129, 1 << 31} {
+		b := appendInteger(nil, tagInteger, v)
+		got, rest, err := parseInteger(b, tagInteger)
+		if err != nil || got != v || len(rest) != 0 {
+			t.Errorf("%d: got %d (%x), %v", v, got, b, err)
+		}
+	}
+	for _, oid := range []string{oidSysDescr, "1.3.6.1.4.1.2021.1", "2.999.3"} {
+		b, err := appendOID(nil, oid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _, _ := parseExpected(b, tagOID)
+		if got, err := decodeOID(content); got != oid || err != nil {
+			t.Errorf("%s: got %s, %v", oid, got, err)
+		}
+	}
+	if _, _, _, err := parseTLV([]byte{tagSequence, 0x82, 0x01}); err != ErrInvalidBER {
+		t.Errorf("expected ErrInvalidBER for a truncated length, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		versions, communities string
+		valid                 bool
+	}{
+		{"1,2c,3", "public", true},
+		{"3", "", true},
+		{" 3, ", "", true},
+		{"3,2c", "", false},
+		{"1", ",", false},
+		{"4", "public", false},
+	} {
+		flags := &Flags{Versions: test.versions, Communities: test.communities}
+		if err := flags.Validate(nil); (err == nil) != test.valid {
+			t.Errorf("versions %q, communities %q: unexpected %v", test.versions, test.communities, err)
+		}
+	}
+}
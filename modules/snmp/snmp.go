@@ -0,0 +1,383 @@
+package snmp
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrUnexpectedResponse is returned when the response is not the one
+// expected for the request.
+var ErrUnexpectedResponse = errors.New("unexpected response")
+
+// SNMP versions, as encoded in messages.
+const (
+	versionV1  = 0
+	versionV2c = 1
+	versionV3  = 3
+)
+
+// OIDs of the system group (RFC 3418).
+const (
+	oidSysDescr    = "1.3.6.1.2.1.1.1.0"
+	oidSysObjectID = "1.3.6.1.2.1.1.2.0"
+	oidSysUpTime   = "1.3.6.1.2.1.1.3.0"
+	oidSysContact  = "1.3.6.1.2.1.1.4.0"
+	oidSysName     = "1.3.6.1.2.1.1.5.0"
+	oidSysLocation = "1.3.6.1.2.1.1.6.0"
+)
+
+// systemOIDs are the objects requested with a community.
+var systemOIDs = []string{oidSysDescr, oidSysObjectID, oidSysUpTime, oidSysContact, oidSysName, oidSysLocation}
+
+// maxMessageSize is the msgMaxSize of the v3 requests: the largest UDP
+// payload.
+const maxMessageSize = 65507
+
+// msgFlagReportable asks for a Report PDU on errors (RFC 3412).
+const msgFlagReportable = 0x04
+
+// securityModelUSM is the user-based security model (RFC 3414).
+const securityModelUSM = 3
+
+// errorStatuses are the names of the error-status values of a PDU.
+var errorStatuses = []string{
+	"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr",
+	"noAccess", "wrongType", "wrongLength", "wrongEncoding", "wrongValue",
+	"noCreation", "inconsistentValue", "resourceUnavailable", "commitFailed",
+	"undoFailed", "authorizationError", "notWritable", "inconsistentName",
+}
+
+// VarBind is a variable binding of a response.
+type VarBind struct {
+	OID string `json:"oid"`
+
+	// Type is the type of the value, such as OCTET STRING or TimeTicks, or
+	// the exception returned instead, such as noSuchObject.
+	Type string `json:"type"`
+
+	// Value is the value as text: decimal for the integer types, dotted
+	// for the OIDs and IP addresses, and hex for the strings that are not
+	// printable.
+	Value string `json:"value,omitempty"`
+}
+
+// pdu is a decoded PDU.
+type pdu struct {
+	tag         byte
+	requestID   int64
+	errorStatus int64
+	errorIndex  int64
+	bindings    []VarBind
+}
+
+// encodeGetRequest returns a v1 or v2c GetRequest message for oids.
+func encodeGetRequest(version int64, community string, requestID int64, oids []string) ([]byte, error) {
+	pdu, err := encodePDU(tagGetRequest, requestID, oids)
+	if err != nil {
+		return nil, err
+	}
+	msg := appendInteger(nil, tagInteger, version)
+	msg = appendTLV(msg, tagOctetString, []byte(community))
+	msg = append(msg, pdu...)
+	return appendTLV(nil, tagSequence, msg), nil
+}
+
+// encodeDiscovery returns an unauthenticated v3 GetRequest without bindings,
+// with an empty engine ID, which agents answer with a Report PDU carrying
+// their engine ID, boots and time (RFC 3414, section 4).
+func encodeDiscovery(msgID, requestID int64) []byte {
+	global := appendInteger(nil, tagInteger, msgID)
+	global = appendInteger(global, tagInteger, maxMessageSize)
+	global = appendTLV(global, tagOctetString, []byte{msgFlagReportable})
+	global = appendInteger(global, tagInteger, securityModelUSM)
+
+	usm := appendTLV(nil, tagOctetString, nil) // msgAuthoritativeEngineID
+	usm = appendInteger(usm, tagInteger, 0)    // msgAuthoritativeEngineBoots
+	usm = appendInteger(usm, tagInteger, 0)    // msgAuthoritativeEngineTime
+	usm = appendTLV(usm, tagOctetString, nil)  // msgUserName
+	usm = appendTLV(usm, tagOctetString, nil)  // msgAuthenticationParameters
+	usm = appendTLV(usm, tagOctetString, nil)  // msgPrivacyParameters
+
+	pdu, _ := encodePDU(tagGetRequest, requestID, nil)
+	scoped := appendTLV(nil, tagOctetString, nil) // contextEngineID
+	scoped = appendTLV(scoped, tagOctetString, nil)
+	scoped = append(scoped, pdu...)
+
+	msg := appendInteger(nil, tagInteger, versionV3)
+	msg = appendTLV(msg, tagSequence, global)
+	msg = appendTLV(msg, tagOctetString, appendTLV(nil, tagSequence, usm))
+	msg = appendTLV(msg, tagSequence, scoped)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+// encodePDU returns a PDU requesting oids.
+func encodePDU(tag byte, requestID int64, oids []string) ([]byte, error) {
+	var bindings []byte
+	for _, oid := range oids {
+		binding, err := appendOID(nil, oid)
+		if err != nil {
+			return nil, err
+		}
+		binding = appendTLV(binding, tagNull, nil)
+		bindings = appendTLV(bindings, tagSequence, binding)
+	}
+	pdu := appendInteger(nil, tagInteger, requestID)
+	pdu = appendInteger(pdu, tagInteger, 0) // error-status
+	pdu = appendInteger(pdu, tagInteger, 0) // error-index
+	pdu = appendTLV(pdu, tagSequence, bindings)
+	return appendTLV(nil, tag, pdu), nil
+}
+
+// decodeCommunityResponse decodes a v1 or v2c message, returning its
+// community and PDU.
+func decodeCommunityResponse(b []byte) (version int64, community string, p *pdu, err error) {
+	msg, _, err := parseExpected(b, tagSequence)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	if version, msg, err = parseInteger(msg, tagInteger); err != nil {
+		return 0, "", nil, err
+	}
+	content, msg, err := parseExpected(msg, tagOctetString)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	p, err = decodePDU(msg)
+	return version, string(content), p, err
+}
+
+// Engine holds the USM security parameters of a v3 agent.
+type Engine struct {
+	// ID is the msgAuthoritativeEngineID.
+	ID *EngineID `json:"engine_id,omitempty"`
+
+	// Boots and Time are the msgAuthoritativeEngineBoots and
+	// msgAuthoritativeEngineTime: the number of times the engine was
+	// restarted, and the seconds since.
+	Boots int64 `json:"engine_boots"`
+	Time  int64 `json:"engine_time"`
+
+	// Report holds the bindings of the Report PDU, such as the
+	// usmStatsUnknownEngineIDs counter.
+	Report []VarBind `json:"report,omitempty"`
+}
+
+// decodeDiscoveryResponse decodes a v3 message, returning its msgID, the
+// USM parameters of the agent and its PDU, if it is not encrypted.
+func decodeDiscoveryResponse(b []byte) (msgID int64, engine *Engine, p *pdu, err error) {
+	msg, _, err := parseExpected(b, tagSequence)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	version, msg, err := parseInteger(msg, tagInteger)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if version != versionV3 {
+		return 0, nil, nil, fmt.Errorf("%w: version %d", ErrUnexpectedResponse, version)
+	}
+	global, msg, err := parseExpected(msg, tagSequence)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if msgID, _, err = parseInteger(global, tagInteger); err != nil {
+		return 0, nil, nil, err
+	}
+	params, msg, err := parseExpected(msg, tagOctetString)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	usm, _, err := parseExpected(params, tagSequence)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	engineID, usm, err := parseExpected(usm, tagOctetString)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	engine = &Engine{}
+	if len(engineID) > 0 {
+		engine.ID = DecodeEngineID(engineID)
+	}
+	if engine.Boots, usm, err = parseInteger(usm, tagInteger); err != nil {
+		return msgID, engine, nil, err
+	}
+	if engine.Time, _, err = parseInteger(usm, tagInteger); err != nil {
+		return msgID, engine, nil, err
+	}
+	scoped, _, err := parseExpected(msg, tagSequence)
+	if err != nil {
+		// an encrypted scoped PDU is an OCTET STRING
+		return msgID, engine, nil, nil
+	}
+	for i := 0; i < 2; i++ { // contextEngineID, contextName
+		if _, scoped, err = parseExpected(scoped, tagOctetString); err != nil {
+			return msgID, engine, nil, err
+		}
+	}
+	p, err = decodePDU(scoped)
+	return msgID, engine, p, err
+}
+
+// decodePDU decodes a response or report PDU.
+func decodePDU(b []byte) (*pdu, error) {
+	tag, content, _, err := parseTLV(b)
+	if err != nil {
+		return nil, err
+	}
+	if tag != tagGetResponse && tag != tagReport {
+		return nil, fmt.Errorf("%w: PDU type 0x%02x", ErrUnexpectedResponse, tag)
+	}
+	p := &pdu{tag: tag}
+	if p.requestID, content, err = parseInteger(content, tagInteger); err != nil {
+		return nil, err
+	}
+	if p.errorStatus, content, err = parseInteger(content, tagInteger); err != nil {
+		return nil, err
+	}
+	if p.errorIndex, content, err = parseInteger(content, tagInteger); err != nil {
+		return nil, err
+	}
+	bindings, _, err := parseExpected(content, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	for len(bindings) > 0 {
+		var binding []byte
+		if binding, bindings, err = parseExpected(bindings, tagSequence); err != nil {
+			return nil, err
+		}
+		oid, value, err := parseExpected(binding, tagOID)
+		if err != nil {
+			return nil, err
+		}
+		vb := VarBind{}
+		if vb.OID, err = decodeOID(oid); err != nil {
+			return nil, err
+		}
+		tag, content, _, err := parseTLV(value)
+		if err != nil {
+			return nil, err
+		}
+		vb.Type, vb.Value = decodeValue(tag, content)
+		p.bindings = append(p.bindings, vb)
+	}
+	return p, nil
+}
+
+// errorName returns the name of the error-status of a PDU, or "" if there
+// is no error.
+func (p *pdu) errorName() string {
+	switch {
+	case p.errorStatus == 0:
+		return ""
+	case p.errorStatus > 0 && p.errorStatus < int64(len(errorStatuses)):
+		return errorStatuses[p.errorStatus]
+	}
+	return strconv.FormatInt(p.errorStatus, 10)
+}
+
+// decodeValue returns the type name and the text of a value.
+func decodeValue(tag byte, content []byte) (string, string) {
+	switch tag {
+	case tagInteger:
+		if v, err := decodeInteger(content); err == nil {
+			return "INTEGER", strconv.FormatInt(v, 10)
+		}
+	case tagOctetString:
+		return "OCTET STRING", text(content)
+	case tagNull:
+		return "NULL", ""
+	case tagOID:
+		if oid, err := decodeOID(content); err == nil {
+			return "OBJECT IDENTIFIER", oid
+		}
+	case tagIPAddress:
+		if len(content) == 4 {
+			return "IpAddress", net.IP(content).String()
+		}
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		names := map[byte]string{tagCounter32: "Counter32", tagGauge32: "Gauge32", tagTimeTicks: "TimeTicks", tagCounter64: "Counter64"}
+		if v, err := decodeUnsigned(content); err == nil {
+			return names[tag], strconv.FormatUint(v, 10)
+		}
+	case tagOpaque:
+		return "Opaque", hex.EncodeToString(content)
+	case tagNoSuchObject:
+		return "noSuchObject", ""
+	case tagNoSuchInstance:
+		return "noSuchInstance", ""
+	case tagEndOfMibView:
+		return "endOfMibView", ""
+	}
+	return fmt.Sprintf("0x%02x", tag), hex.EncodeToString(content)
+}
+
+// text returns b as text if it is printable, or in hex otherwise.
+func text(b []byte) string {
+	if !utf8.Valid(b) {
+		return hex.EncodeToString(b)
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return hex.EncodeToString(b)
+		}
+	}
+	return string(b)
+}
+
+// EngineID is a decoded SnmpEngineID (RFC 3411, section 5).
+type EngineID struct {
+	// Raw is the engine ID, in hex.
+	Raw string `json:"raw"`
+
+	// Enterprise is the private enterprise number of the vendor.
+	Enterprise uint32 `json:"enterprise"`
+
+	// Format is the format of the rest of the ID: ipv4, ipv6, mac, text,
+	// octets, or enterprise for the vendor formats and the IDs predating
+	// RFC 3411.
+	Format string `json:"format"`
+
+	// Data is the rest of the ID: the IP or MAC address, the text, or the
+	// octets in hex.
+	Data string `json:"data,omitempty"`
+}
+
+// DecodeEngineID decodes an SnmpEngineID.
+func DecodeEngineID(id []byte) *EngineID {
+	ret := &EngineID{Raw: hex.EncodeToString(id)}
+	if len(id) < 5 {
+		ret.Format = "octets"
+		ret.Data = ret.Raw
+		return ret
+	}
+	ret.Enterprise = uint32(id[0]&0x7f)<<24 | uint32(id[1])<<16 | uint32(id[2])<<8 | uint32(id[3])
+	if id[0]&0x80 == 0 {
+		// the format of SNMPv1 and SNMPv2, with 8 bytes defined by the vendor
+		ret.Format = "enterprise"
+		ret.Data = hex.EncodeToString(id[4:])
+		return ret
+	}
+	data := id[5:]
+	switch format := id[4]; {
+	case format == 1 && len(data) == 4:
+		ret.Format, ret.Data = "ipv4", net.IP(data).String()
+	case format == 2 && len(data) == 16:
+		ret.Format, ret.Data = "ipv6", net.IP(data).String()
+	case format == 3 && len(data) == 6:
+		ret.Format, ret.Data = "mac", net.HardwareAddr(data).String()
+	case format == 4:
+		ret.Format, ret.Data = "text", text(data)
+	case format >= 128:
+		ret.Format, ret.Data = "enterprise", hex.EncodeToString(data)
+	default:
+		ret.Format, ret.Data = "octets", hex.EncodeToString(data)
+	}
+	return ret
+}
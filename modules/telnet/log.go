@@ -47,3 +47,11 @@ func (log *TelnetLog) getResult() *TelnetLog {
 	}
 	return nil
 }
+
+// ServiceBanner implements zgrab2.ServiceBanner.
+func (log *TelnetLog) ServiceBanner() ([]byte, string) {
+	if log == nil {
+		return nil, ""
+	}
+	return []byte(log.Banner), "NULL"
+}
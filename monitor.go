@@ -9,6 +9,10 @@ import (
 type Monitor struct {
 	states       map[string]*State
 	statusesChan chan moduleStatus
+	// stopped is set by Stop; the statuses of scans abandoned by an
+	// interrupted Process, which finish later, are then ignored.
+	mu      sync.RWMutex
+	stopped bool
 	// Callback is invoked after each scan.
 	Callback func(string)
 }
@@ -42,9 +46,25 @@ func (m *Monitor) GetStatuses() map[string]*State {
 // This function does not block, but will allow a call to Wait() on the
 // WaitGroup passed to MakeMonitor to return.
 func (m *Monitor) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
 	close(m.statusesChan)
 }
 
+// report sends the status of a scan to the monitor, unless it is nil or was
+// stopped.
+func (m *Monitor) report(s moduleStatus) {
+	if m == nil {
+		return
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.stopped {
+		m.statusesChan <- s
+	}
+}
+
 // MakeMonitor returns a Monitor object that can be used to collect and send
 // the status of a running scan
 func MakeMonitor(statusChanSize int, wg *sync.WaitGroup) *Monitor {
@@ -4,9 +4,10 @@ import "errors"
 
 // MultipleCommand contains the command line options for running
 type MultipleCommand struct {
-	ConfigFileName  string `short:"c" long:"config-file" default:"-" description:"Config filename, use - for stdin"`
-	ContinueOnError bool   `long:"continue-on-error" description:"If proceeding protocols error, do not run following protocols (default: true)"`
-	BreakOnSuccess  bool   `long:"break-on-success" description:"If proceeding protocols succeed, do not run following protocols (default: false)"`
+	ConfigFileName    string `short:"c" long:"config-file" default:"-" description:"Config filename, use - for stdin"`
+	ContinueOnError   bool   `long:"continue-on-error" description:"If proceeding protocols error, do not run following protocols (default: true)"`
+	BreakOnSuccess    bool   `long:"break-on-success" description:"If proceeding protocols succeed, do not run following protocols (default: false)"`
+	ScannersPerTarget int    `long:"scanners-per-target" default:"1" description:"Number of protocols run concurrently on each target; a protocol still waits for those in its if-success and if-match conditions, and the results after a protocol that stops the target are dropped"`
 }
 
 // Validate the options sent to MultipleCommand
@@ -14,6 +15,9 @@ func (x *MultipleCommand) Validate(args []string) error {
 	if x.ConfigFileName == config.InputFileName {
 		return errors.New("cannot receive config file and input file from same source")
 	}
+	if x.ScannersPerTarget < 0 {
+		return errors.New("scanners-per-target must not be negative")
+	}
 
 	return nil
 }
@@ -0,0 +1,54 @@
+package zgrab2
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zgrab2/lib/nmap"
+)
+
+// ServiceBanner is implemented by scan results that carry the raw data sent
+// by the server, so that the framework can fingerprint it against the
+// nmap-service-probes database given with --nmap-service-probes.
+type ServiceBanner interface {
+	// ServiceBanner returns the data received from the server, along with
+	// the name of the nmap probe that best describes what was sent to elicit
+	// it: "NULL" if nothing was sent, or "" if there is no good equivalent.
+	ServiceBanner() ([]byte, string)
+}
+
+var serviceProbes *nmap.ServiceProbes
+
+// GetServiceProbes returns the nmap-service-probes database loaded from
+// --nmap-service-probes, or nil if version scanning is disabled.
+func GetServiceProbes() *nmap.ServiceProbes {
+	return serviceProbes
+}
+
+func loadServiceProbes() {
+	if config.NmapServiceProbes == "" {
+		return
+	}
+	probes, err := nmap.LoadServiceProbes(config.NmapServiceProbes)
+	if err != nil {
+		log.Fatalf("could not load nmap-service-probes from %s: %s", config.NmapServiceProbes, err)
+	}
+	if probes.Skipped > 0 {
+		log.Warnf("skipped %d unsupported match expressions in %s", probes.Skipped, config.NmapServiceProbes)
+	}
+	serviceProbes = probes
+}
+
+// MatchServices fingerprints each response in grab whose result implements
+// ServiceBanner, and sets its Service to the best match, if any.
+func MatchServices(grab *Grab, probes *nmap.ServiceProbes) {
+	for name, res := range grab.Data {
+		banner, ok := res.Result.(ServiceBanner)
+		if !ok {
+			continue
+		}
+		data, probe := banner.ServiceBanner()
+		if info := probes.MatchBanner(nmap.TCP, probe, data); info != nil {
+			res.Service = info
+			grab.Data[name] = res
+		}
+	}
+}
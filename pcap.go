@@ -0,0 +1,330 @@
+package zgrab2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PCAP-NG block types and options.
+const (
+	pcapngSectionHeader        = 0x0A0D0D0A
+	pcapngInterfaceDescription = 0x00000001
+	pcapngEnhancedPacket       = 0x00000006
+	pcapngByteOrderMagic       = 0x1A2B3C4D
+	pcapngOptionEnd            = 0
+	pcapngOptionComment        = 1
+	pcapngLinkTypeEthernet     = 1
+)
+
+// pcapMSS is the maximum size of the synthesized TCP segments.
+const pcapMSS = 1460
+
+// pcapOutput receives the packets of the scans, with --pcap-file.
+var pcapOutput *PcapWriter
+
+// PcapWriter writes the connections of scans to a PCAP-NG file, as packets
+// synthesized from the data sent and received: the Ethernet, IP and TCP or
+// UDP headers are made up, with consistent addresses, lengths, checksums and
+// sequence numbers, so that the sessions can be followed in Wireshark. It is
+// safe for concurrent use.
+type PcapWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewPcapWriter writes the PCAP-NG section and interface headers to w, and
+// returns a PcapWriter adding the packets to it.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	var buf bytes.Buffer
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1) // version 1.0
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0))
+	writePcapngBlock(&buf, pcapngSectionHeader, shb)
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], pcapngLinkTypeEthernet)
+	writePcapngBlock(&buf, pcapngInterfaceDescription, idb)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w}, nil
+}
+
+// Close closes the underlying writer, if it is an io.Closer.
+func (p *PcapWriter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// WriteTranscript writes the packets of the connections of a transcript,
+// which started at start. The first packet of each connection carries
+// comment. The TCP connections are closed with a FIN exchange at their last
+// event, started by the server if that event is a read that hit EOF, or by
+// the client otherwise. The connections whose addresses are unknown are
+// skipped.
+func (p *PcapWriter) WriteTranscript(comment string, start time.Time, t *Transcript) error {
+	var buf bytes.Buffer
+	for i, c := range t.Connections {
+		flow, ok := newPcapFlow(c)
+		if !ok {
+			continue
+		}
+		flow.comment = comment
+		at := func(seconds float64) time.Time {
+			return start.Add(time.Duration(seconds * float64(time.Second)))
+		}
+		if flow.tcp {
+			opened := at(c.Time)
+			flow.write(&buf, opened, true, tcpSYN, nil)
+			flow.write(&buf, opened, false, tcpSYN|tcpACK, nil)
+			flow.write(&buf, opened, true, tcpACK, nil)
+		}
+		last, serverClosed := c.Time, false
+		for _, event := range t.Events {
+			if event.Connection != i {
+				continue
+			}
+			last = event.Time
+			serverClosed = event.Direction == "read" && event.Error == "EOF"
+			if len(event.Data) == 0 {
+				continue
+			}
+			fromClient := event.Direction == "write"
+			if !flow.tcp {
+				flow.write(&buf, at(event.Time), fromClient, 0, event.Data)
+				continue
+			}
+			for data := event.Data; len(data) > 0; {
+				n := len(data)
+				if n > pcapMSS {
+					n = pcapMSS
+				}
+				flow.write(&buf, at(event.Time), fromClient, tcpPSH|tcpACK, data[:n])
+				data = data[n:]
+			}
+		}
+		if flow.tcp {
+			closed := at(last)
+			flow.write(&buf, closed, !serverClosed, tcpFIN|tcpACK, nil)
+			flow.write(&buf, closed, serverClosed, tcpFIN|tcpACK, nil)
+			flow.write(&buf, closed, !serverClosed, tcpACK, nil)
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.w.Write(buf.Bytes())
+	return err
+}
+
+// TCP flags.
+const (
+	tcpFIN = 1 << iota
+	tcpSYN
+	tcpRST
+	tcpPSH
+	tcpACK
+)
+
+// pcapFlow synthesizes the packets of a connection.
+type pcapFlow struct {
+	tcp            bool
+	client, server netip.AddrPort
+	clientSeq      uint32
+	serverSeq      uint32
+	ipID           uint16
+	comment        string
+}
+
+var (
+	pcapClientMAC = []byte{0x02, 0, 0, 0, 0, 0x01}
+	pcapServerMAC = []byte{0x02, 0, 0, 0, 0, 0x02}
+)
+
+// newPcapFlow returns the flow of a recorded connection, if its network and
+// remote address are known. An unknown local address is left unspecified.
+func newPcapFlow(c TranscriptConnection) (*pcapFlow, bool) {
+	server, err := netip.ParseAddrPort(c.Remote)
+	if err != nil {
+		return nil, false
+	}
+	server = netip.AddrPortFrom(server.Addr().Unmap(), server.Port())
+	client, err := netip.ParseAddrPort(c.Local)
+	if err != nil || client.Addr().Unmap().Is4() != server.Addr().Is4() {
+		client = netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+		if server.Addr().Is6() {
+			client = netip.AddrPortFrom(netip.IPv6Unspecified(), 0)
+		}
+	}
+	client = netip.AddrPortFrom(client.Addr().Unmap(), client.Port())
+	flow := &pcapFlow{client: client, server: server, clientSeq: 0x10000000, serverSeq: 0x20000000}
+	switch {
+	case strings.HasPrefix(c.Network, "tcp"):
+		flow.tcp = true
+	case strings.HasPrefix(c.Network, "udp"):
+	default:
+		return nil, false
+	}
+	return flow, true
+}
+
+// write appends a packet with payload, sent at t by the client or the
+// server, to buf.
+func (f *pcapFlow) write(buf *bytes.Buffer, t time.Time, fromClient bool, flags byte, payload []byte) {
+	src, dst := f.client, f.server
+	srcMAC, dstMAC := pcapClientMAC, pcapServerMAC
+	seq, ack := &f.clientSeq, &f.serverSeq
+	if !fromClient {
+		src, dst = dst, src
+		srcMAC, dstMAC = dstMAC, srcMAC
+		seq, ack = ack, seq
+	}
+
+	// transport header and payload
+	var segment []byte
+	var proto byte
+	if f.tcp {
+		proto = 6
+		segment = make([]byte, 20+len(payload))
+		binary.BigEndian.PutUint16(segment[0:], src.Port())
+		binary.BigEndian.PutUint16(segment[2:], dst.Port())
+		binary.BigEndian.PutUint32(segment[4:], *seq)
+		if flags&tcpACK != 0 {
+			binary.BigEndian.PutUint32(segment[8:], *ack)
+		}
+		segment[12] = 5 << 4
+		segment[13] = flags
+		binary.BigEndian.PutUint16(segment[14:], 65535)
+		copy(segment[20:], payload)
+		*seq += uint32(len(payload))
+		if flags&(tcpSYN|tcpFIN) != 0 {
+			*seq++
+		}
+	} else {
+		proto = 17
+		segment = make([]byte, 8+len(payload))
+		binary.BigEndian.PutUint16(segment[0:], src.Port())
+		binary.BigEndian.PutUint16(segment[2:], dst.Port())
+		binary.BigEndian.PutUint16(segment[4:], uint16(len(segment)))
+		copy(segment[8:], payload)
+	}
+
+	// network header, with the pseudo-header for the transport checksum
+	var header []byte
+	var pseudo uint32
+	srcIP, dstIP := src.Addr().AsSlice(), dst.Addr().AsSlice()
+	etherType := uint16(0x0800)
+	if src.Addr().Is4() {
+		header = make([]byte, 20)
+		header[0] = 0x45
+		binary.BigEndian.PutUint16(header[2:], uint16(20+len(segment)))
+		binary.BigEndian.PutUint16(header[4:], f.ipID)
+		f.ipID++
+		binary.BigEndian.PutUint16(header[6:], 0x4000) // don't fragment
+		header[8] = 64
+		header[9] = proto
+		copy(header[12:], srcIP)
+		copy(header[16:], dstIP)
+		binary.BigEndian.PutUint16(header[10:], foldChecksum(checksum(0, header)))
+		pseudo = checksum(0, header[12:20])
+	} else {
+		etherType = 0x86DD
+		header = make([]byte, 40)
+		header[0] = 0x60
+		binary.BigEndian.PutUint16(header[4:], uint16(len(segment)))
+		header[6] = proto
+		header[7] = 64
+		copy(header[8:], srcIP)
+		copy(header[24:], dstIP)
+		pseudo = checksum(0, header[8:40])
+	}
+	pseudo += uint32(proto) + uint32(len(segment))
+	sum := foldChecksum(checksum(pseudo, segment))
+	if f.tcp {
+		binary.BigEndian.PutUint16(segment[16:], sum)
+	} else {
+		if sum == 0 {
+			sum = 0xffff
+		}
+		binary.BigEndian.PutUint16(segment[6:], sum)
+	}
+
+	packet := make([]byte, 0, 14+len(header)+len(segment))
+	packet = append(packet, dstMAC...)
+	packet = append(packet, srcMAC...)
+	packet = binary.BigEndian.AppendUint16(packet, etherType)
+	packet = append(packet, header...)
+	packet = append(packet, segment...)
+	writePcapngPacket(buf, t, packet, f.comment)
+	f.comment = ""
+}
+
+// checksum adds the 16-bit words of b to sum.
+func checksum(sum uint32, b []byte) uint32 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
+
+// foldChecksum returns the Internet checksum of the words added in sum.
+func foldChecksum(sum uint32) uint16 {
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// writePcapngPacket appends an Enhanced Packet Block to buf, with an optional
+// comment.
+func writePcapngPacket(buf *bytes.Buffer, t time.Time, packet []byte, comment string) {
+	body := make([]byte, 20, 20+len(packet)+len(comment)+16)
+	micros := uint64(t.UnixMicro())
+	binary.LittleEndian.PutUint32(body[4:], uint32(micros>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(micros))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(packet)))
+	body = appendPadded(body, packet)
+	if comment != "" {
+		body = binary.LittleEndian.AppendUint16(body, pcapngOptionComment)
+		body = binary.LittleEndian.AppendUint16(body, uint16(len(comment)))
+		body = appendPadded(body, []byte(comment))
+		body = binary.LittleEndian.AppendUint32(body, pcapngOptionEnd)
+	}
+	writePcapngBlock(buf, pcapngEnhancedPacket, body)
+}
+
+// writePcapngBlock appends a block to buf. The body must be padded to 32 bits.
+func writePcapngBlock(buf *bytes.Buffer, blockType uint32, body []byte) {
+	length := uint32(12 + len(body))
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], blockType)
+	buf.Write(b[:])
+	binary.LittleEndian.PutUint32(b[:], length)
+	buf.Write(b[:])
+	buf.Write(body)
+	buf.Write(b[:])
+}
+
+// appendPadded appends data to b, padded with zeros to 32 bits.
+func appendPadded(b, data []byte) []byte {
+	b = append(b, data...)
+	for n := len(data); n%4 != 0; n++ {
+		b = append(b, 0)
+	}
+	return b
+}
@@ -0,0 +1,125 @@
+package zgrab2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pcapngPacket is an Enhanced Packet Block read back from a PCAP-NG file.
+type pcapngPacket struct {
+	time    time.Time
+	data    []byte
+	comment string
+}
+
+// readPcapng returns the packets of a PCAP-NG file, after checking its
+// headers.
+func readPcapng(t *testing.T, b []byte) []pcapngPacket {
+	var packets []pcapngPacket
+	var types []uint32
+	for len(b) > 0 {
+		blockType := binary.LittleEndian.Uint32(b)
+		length := binary.LittleEndian.Uint32(b[4:])
+		if length%4 != 0 || int(length) > len(b) || binary.LittleEndian.Uint32(b[length-4:]) != length {
+			t.Fatalf("invalid block length %d", length)
+		}
+		body := b[8 : length-4]
+		b = b[length:]
+		types = append(types, blockType)
+		if blockType != pcapngEnhancedPacket {
+			continue
+		}
+		micros := uint64(binary.LittleEndian.Uint32(body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:]))
+		size := binary.LittleEndian.Uint32(body[12:])
+		packet := pcapngPacket{time: time.UnixMicro(int64(micros)), data: body[20 : 20+size]}
+		options := body[20+(size+3)/4*4:]
+		if len(options) > 0 && binary.LittleEndian.Uint16(options) == pcapngOptionComment {
+			packet.comment = string(options[4 : 4+binary.LittleEndian.Uint16(options[2:])])
+		}
+		packets = append(packets, packet)
+	}
+	if len(types) < 2 || types[0] != pcapngSectionHeader || types[1] != pcapngInterfaceDescription {
+		t.Fatalf("unexpected blocks %x", types)
+	}
+	return packets
+}
+
+func TestPcapWriter(t *testing.T) {
+	var out bytes.Buffer
+	w, err := NewPcapWriter(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	transcript := &Transcript{
+		Connections: []TranscriptConnection{
+			{Network: "tcp", Local: "192.0.2.1:40000", Remote: "198.51.100.1:25", Time: 0.5},
+			{Network: "udp", Local: "[2001:db8::1]:40001", Remote: "[2001:db8::2]:53", Time: 1},
+		},
+		Events: []TranscriptEvent{
+			{Connection: 0, Direction: "read", Time: 1, Data: []byte("220 ready\r\n")},
+			{Connection: 0, Direction: "write", Time: 2, Data: bytes.Repeat([]byte("x"), pcapMSS+1)},
+			{Connection: 0, Direction: "read", Time: 3, Error: "EOF"},
+			{Connection: 1, Direction: "write", Time: 4, Data: []byte("query")},
+		},
+	}
+	if err := w.WriteTranscript("target: example.com, scanner: smtp", start, transcript); err != nil {
+		t.Fatal(err)
+	}
+	packets := readPcapng(t, out.Bytes())
+	// SYN, SYN-ACK, ACK, the banner, the two segments of the write, the FIN
+	// exchange, and the UDP datagram
+	if len(packets) != 10 {
+		t.Fatalf("expected 10 packets, got %d", len(packets))
+	}
+	if !strings.Contains(packets[0].comment, "scanner: smtp") || packets[1].comment != "" {
+		t.Errorf("expected the comment on the first packet, got %q", packets[0].comment)
+	}
+	if !packets[0].time.Equal(start.Add(500*time.Millisecond)) || !packets[3].time.Equal(start.Add(time.Second)) {
+		t.Errorf("unexpected times %s, %s", packets[0].time, packets[3].time)
+	}
+
+	for i, p := range packets[:9] {
+		ip := p.data[14:34]
+		if binary.BigEndian.Uint16(p.data[12:]) != 0x0800 || foldChecksum(checksum(0, ip)) != 0 {
+			t.Errorf("packet %d: invalid IPv4 header", i)
+		}
+		segment := p.data[34:]
+		pseudo := checksum(0, ip[12:20]) + 6 + uint32(len(segment))
+		if foldChecksum(checksum(pseudo, segment)) != 0 {
+			t.Errorf("packet %d: invalid TCP checksum", i)
+		}
+	}
+	tcp := func(i int) (seq, ack uint32, flags byte, payload []byte) {
+		segment := packets[i].data[34:]
+		return binary.BigEndian.Uint32(segment[4:]), binary.BigEndian.Uint32(segment[8:]), segment[13], segment[20:]
+	}
+	bannerSeq, bannerAck, flags, payload := tcp(3)
+	if flags != tcpPSH|tcpACK || string(payload) != "220 ready\r\n" || bannerSeq != 0x20000001 || bannerAck != 0x10000001 {
+		t.Errorf("unexpected banner segment: seq %x ack %x flags %x payload %q", bannerSeq, bannerAck, flags, payload)
+	}
+	seq, ack, _, payload := tcp(5)
+	if seq != 0x10000001+pcapMSS || ack != bannerSeq+uint32(len("220 ready\r\n")) || len(payload) != 1 {
+		t.Errorf("unexpected second segment: seq %x ack %x, %d bytes", seq, ack, len(payload))
+	}
+
+	// the server closed the connection, as the last read hit EOF
+	finSeq, finAck, flags, _ := tcp(6)
+	if flags != tcpFIN|tcpACK || finSeq != bannerSeq+uint32(len("220 ready\r\n")) || finAck != seq+1 || !packets[6].time.Equal(start.Add(3*time.Second)) {
+		t.Errorf("unexpected server FIN: seq %x ack %x flags %x at %s", finSeq, finAck, flags, packets[6].time)
+	}
+	if seq, ack, flags, _ := tcp(7); flags != tcpFIN|tcpACK || seq != finAck || ack != finSeq+1 {
+		t.Errorf("unexpected client FIN: seq %x ack %x flags %x", seq, ack, flags)
+	}
+	if seq, ack, flags, _ := tcp(8); flags != tcpACK || seq != finSeq+1 || ack != finAck+1 {
+		t.Errorf("unexpected last ACK: seq %x ack %x flags %x", seq, ack, flags)
+	}
+
+	udp := packets[9].data
+	if binary.BigEndian.Uint16(udp[12:]) != 0x86DD || udp[14+6] != 17 || string(udp[14+40+8:]) != "query" {
+		t.Errorf("unexpected UDP packet %x", udp)
+	}
+}
@@ -1,6 +1,7 @@
 package zgrab2
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -15,8 +16,19 @@ import (
 type Grab struct {
 	IP     string                  `json:"ip,omitempty"`
 	Domain string                  `json:"domain,omitempty"`
+	Port   uint                    `json:"port,omitempty"`
 	Tag    string                  `json:"-"`
 	Data   map[string]ScanResponse `json:"data,omitempty"`
+
+	// ResolveError is the error resolving the domain of the target, which
+	// was then not scanned.
+	ResolveError string `json:"resolve_error,omitempty"`
+
+	seq uint64
+
+	// outputs is the number of grabs output for the input target: one per
+	// address of its domain and connection.
+	outputs int
 }
 
 // ScanTarget is the host that will be scanned
@@ -25,6 +37,32 @@ type ScanTarget struct {
 	Domain string
 	Tag    string
 	Port   *uint
+
+	// seq is the number of the target in the input, if checkpointing.
+	seq uint64
+
+	// stats accumulates the traffic of the connections opened by Open and
+	// OpenUDP, for the metrics.
+	stats *scanStats
+
+	// ctx is the context of the running scan, set by RunScannerContext and
+	// Engine.Run. The connections opened by Open, OpenTLS and OpenUDP are
+	// bound to it.
+	ctx context.Context
+
+	// responses holds the responses of the scanners that already ran on the
+	// target, during a scan.
+	responses map[string]ScanResponse
+
+	// replay, if set, holds the responses recorded by a previous scan, which
+	// the scanners replay instead of connecting to the target.
+	replay map[string]ScanResponse
+
+	// ServerName, if set, overrides the --server-name of the TLS flags.
+	ServerName string
+
+	// Endpoint, if set, overrides the --endpoint of the http module.
+	Endpoint string
 }
 
 func (target ScanTarget) String() string {
@@ -39,6 +77,9 @@ func (target ScanTarget) String() string {
 	} else {
 		res = target.Domain
 	}
+	if target.Port != nil {
+		res += fmt.Sprintf(":%d", *target.Port)
+	}
 	if target.Tag != "" {
 		res += " tag:" + target.Tag
 	}
@@ -57,6 +98,15 @@ func (target *ScanTarget) Host() string {
 	panic("unreachable")
 }
 
+// Context returns the context of the running scan: it is done when the scan
+// is interrupted or runs out of --target-timeout.
+func (target *ScanTarget) Context() context.Context {
+	if target.ctx == nil {
+		return context.Background()
+	}
+	return target.ctx
+}
+
 // Open connects to the ScanTarget using the configured flags, and returns a net.Conn that uses the configured timeouts for Read/Write operations.
 func (target *ScanTarget) Open(flags *BaseFlags) (net.Conn, error) {
 	var port uint
@@ -68,7 +118,21 @@ func (target *ScanTarget) Open(flags *BaseFlags) (net.Conn, error) {
 	}
 
 	address := net.JoinHostPort(target.Host(), fmt.Sprintf("%d", port))
-	return DialTimeoutConnection("tcp", address, flags.Timeout, flags.BytesReadLimit)
+	timeout := flags.Timeout
+	conn, err := dialTimeoutConnectionContext(target.Context(), "tcp", address, timeout, timeout, timeout, timeout, flags.BytesReadLimit)
+	if err != nil {
+		return nil, err
+	}
+	target.trackStats(conn)
+	return conn, nil
+}
+
+// trackStats makes a TimeoutConnection report its traffic to the stats of
+// the target.
+func (target *ScanTarget) trackStats(conn net.Conn) {
+	if c, ok := conn.(*TimeoutConnection); ok && target.stats != nil {
+		c.stats.Store(target.stats)
+	}
 }
 
 // OpenTLS connects to the ScanTarget using the configured flags, then performs
@@ -104,30 +168,61 @@ func (target *ScanTarget) OpenUDP(flags *BaseFlags, udp *UDPFlags) (net.Conn, er
 			local.Port = int(udp.LocalPort)
 		}
 	}
+	if r := contextReplay(target.Context()); r != nil {
+		conn, err := r.dial("udp")
+		if err != nil {
+			return nil, err
+		}
+		ret := NewTimeoutConnection(target.Context(), conn, flags.Timeout, 0, 0, flags.BytesReadLimit)
+		target.trackStats(ret)
+		return ret, nil
+	}
+	if err := waitToConnect(target.Context(), address); err != nil {
+		return nil, err
+	}
 	remote, err := net.ResolveUDPAddr("udp", address)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialUDP("udp", local, remote)
+	if !IsAddressAllowedContext(target.Context(), remote.IP) {
+		return nil, ErrBlocklisted
+	}
+	var conn net.Conn
+	if local != nil {
+		conn, err = net.DialUDP("udp", local, remote)
+	} else {
+		conn, err = contextDialPolicy(target.Context()).source.dial("udp", remote.String(), func(pooled net.Addr) (net.Conn, error) {
+			laddr, _ := pooled.(*net.UDPAddr)
+			return net.DialUDP("udp", laddr, remote)
+		})
+	}
 	if err != nil {
 		return nil, err
 	}
-	return NewTimeoutConnection(nil, conn, flags.Timeout, 0, 0, flags.BytesReadLimit), nil
+	ret := NewTimeoutConnection(target.Context(), conn, flags.Timeout, 0, 0, flags.BytesReadLimit)
+	target.trackStats(ret)
+	return ret, nil
 }
 
 // BuildGrabFromInputResponse constructs a Grab object for a target, given the
 // scan responses.
 func BuildGrabFromInputResponse(t *ScanTarget, responses map[string]ScanResponse) *Grab {
 	var ipstr string
+	var port uint
 
 	if t.IP != nil {
 		ipstr = t.IP.String()
 	}
+	if t.Port != nil {
+		port = *t.Port
+	}
 	return &Grab{
 		IP:     ipstr,
 		Domain: t.Domain,
+		Port:   port,
 		Tag:    t.Tag,
 		Data:   responses,
+		seq:    t.seq,
 	}
 }
 
@@ -151,85 +246,113 @@ func EncodeGrab(raw *Grab, includeDebug bool) ([]byte, error) {
 	return json.Marshal(outputData)
 }
 
-// grabTarget calls handler for each action
-func grabTarget(input ScanTarget, m *Monitor) *Grab {
-	moduleResult := make(map[string]ScanResponse)
-	for _, scannerName := range orderedScanners {
-		//t1 := time.Now().UTC()
-		scanner := scanners[scannerName]
-		trigger := (*scanner).GetTrigger()
-		if input.Tag != trigger {
-			continue
-		}
-		defer func(name string) {
-			if e := recover(); e != nil {
-				log.Errorf("Panic on scanner %s when scanning target %s: %#v", scannerName, input.String(), e)
-				// Bubble out original error (with original stack) in lieu of explicitly logging the stack / error
-				panic(e)
-			}
-		}(scannerName)
-
-		name, res := RunScanner(*scanner, m, input)
-		moduleResult[name] = res
-		if res.Error != nil && !config.Multiple.ContinueOnError {
-			break
-		}
-		if res.Status == SCAN_SUCCESS && config.Multiple.BreakOnSuccess {
-			break
-		}
-	}
-	return BuildGrabFromInputResponse(&input, moduleResult)
-}
-
 // Process sets up an output encoder, input reader, and starts grab workers.
+// If an nmap-service-probes database was loaded, the grabs are passed through
+// a pool of config.NmapMatchers matchers before being encoded.
 func Process(mon *Monitor) {
+	ProcessContext(context.Background(), mon)
+}
+
+// ProcessContext is Process, stopping early when ctx is cancelled: it stops
+// reading the input, waits up to config.ShutdownTimeout for the scans in
+// progress, cancels the context of those still running, and returns once the
+// results of the completed scans are written.
+func ProcessContext(ctx context.Context, mon *Monitor) {
+	engine, err := configEngine(mon)
+	if err != nil {
+		log.Fatal(err)
+	}
 	workers := config.Senders
-	processQueue := make(chan ScanTarget, workers*4)
 	outputQueue := make(chan []byte, workers*4)
 
 	//Create wait groups
-	var workerDone sync.WaitGroup
+	var matcherDone sync.WaitGroup
 	var outputDone sync.WaitGroup
-	workerDone.Add(int(workers))
 	outputDone.Add(1)
 
-	log.Info("all matchers runned")
+	// With a checkpoint, the results are written along with their target
+	// number, so that the checkpoint knows which targets are complete.
+	var checkpointQueue chan checkpointRecord
+	if config.checkpoint != nil {
+		checkpointQueue = make(chan checkpointRecord, workers*4)
+	}
+
+	encode := func(result *Grab) {
+		data, err := EncodeGrab(result, includeDebugOutput())
+		if err != nil {
+			log.Errorf("unable to marshal data: %s", err)
+		}
+		if checkpointQueue != nil {
+			checkpointQueue <- checkpointRecord{seq: result.seq, outputs: result.outputs, data: data}
+		} else {
+			outputQueue <- data
+		}
+	}
 
 	// Start the output encoder
 	go func() {
 		defer outputDone.Done()
-		if err := config.outputResults(outputQueue); err != nil {
+		var err error
+		if config.checkpoint != nil {
+			err = config.checkpoint.output(checkpointQueue)
+		} else {
+			err = config.outputResults(outputQueue)
+		}
+		if err != nil {
 			log.Fatal(err)
 		}
 	}()
 
-	//Start all the workers
-	for i := 0; i < workers; i++ {
-		go func(i int) {
-			for _, scannerName := range orderedScanners {
-				scanner := *scanners[scannerName]
-				scanner.InitPerSender(i)
-			}
-			for obj := range processQueue {
-				for run := uint(0); run < uint(config.ConnectionsPerHost); run++ {
-					result := grabTarget(obj, mon)
-					data, err := EncodeGrab(result, includeDebugOutput())
-					if err != nil {
-						log.Errorf("unable to marshal data: %s", err)
-					}
-					outputQueue <- data
+	if checkpointQueue != nil {
+		defer addQueueLength(queueOutput, func() int { return len(checkpointQueue) })()
+	} else {
+		defer addQueueLength(queueOutput, func() int { return len(outputQueue) })()
+	}
+
+	// Start the service matchers
+	var matchQueue chan *Grab
+	if probes := GetServiceProbes(); probes != nil {
+		matchers := config.NmapMatchers
+		matchQueue = make(chan *Grab, matchers*4)
+		matcherDone.Add(matchers)
+		for i := 0; i < matchers; i++ {
+			go func() {
+				defer matcherDone.Done()
+				for result := range matchQueue {
+					MatchServices(result, probes)
+					encode(result)
 				}
-			}
-			workerDone.Done()
-		}(i)
+			}()
+		}
+		defer addQueueLength(queueMatch, func() int { return len(matchQueue) })()
+		log.Infof("started %d nmap matchers", matchers)
 	}
-	if err := config.inputTargets(processQueue); err != nil {
-		log.Fatal(err)
+
+	err = engine.Run(ctx, IterateInput(config.inputTargets), func(result *Grab) error {
+		if matchQueue != nil {
+			matchQueue <- result
+		} else {
+			encode(result)
+		}
+		return nil
+	})
+	if matchQueue != nil {
+		close(matchQueue)
+		matcherDone.Wait()
 	}
-	close(processQueue)
-	workerDone.Wait()
 	close(outputQueue)
+	if checkpointQueue != nil {
+		close(checkpointQueue)
+	}
 	outputDone.Wait()
+	if pcapOutput != nil {
+		if err := pcapOutput.Close(); err != nil {
+			log.Errorf("could not close %s: %s", config.PcapFile, err)
+		}
+	}
+	if err != nil && err != ctx.Err() {
+		log.Fatal(err)
+	}
 }
 
 func readBanners(ch <-chan *Grab) error {
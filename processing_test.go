@@ -0,0 +1,94 @@
+package zgrab2
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stallScanner blocks forever on targets named "stall", after closing
+// stalled.
+type stallScanner struct {
+	echoScanner
+	stalled chan struct{}
+}
+
+func (s *stallScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	if t.Domain == "stall" {
+		close(s.stalled)
+		select {}
+	}
+	return SCAN_SUCCESS, t.Domain, nil
+}
+
+// withScanner registers s as the only scanner for the duration of the test.
+func withScanner(t *testing.T, s Scanner) {
+	savedScanners, savedOrder := scanners, orderedScanners
+	t.Cleanup(func() { scanners, orderedScanners = savedScanners, savedOrder })
+	scanners, orderedScanners = make(map[string]*Scanner), nil
+	RegisterScan(s.GetName(), s)
+}
+
+func TestProcessContextInterrupted(t *testing.T) {
+	scanner := &stallScanner{echoScanner: echoScanner{name: "stall-test"}, stalled: make(chan struct{})}
+	withScanner(t, scanner)
+
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.Senders = 1
+	config.ConnectionsPerHost = 1
+	config.ShutdownTimeout = 50 * time.Millisecond
+	config.checkpoint = nil
+
+	// interrupt the scan once the worker is stalled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-scanner.stalled
+		cancel()
+	}()
+	stopInput := make(chan struct{})
+	defer close(stopInput)
+	// the 11th target stalls, the input never ends
+	config.inputTargets = func(ch chan<- ScanTarget) error {
+		for i := 0; ; i++ {
+			target := ScanTarget{Domain: "fast"}
+			if i == 10 {
+				target.Domain = "stall"
+			}
+			select {
+			case ch <- target:
+			case <-stopInput:
+				return nil
+			}
+		}
+	}
+	var results []string
+	config.outputResults = func(ch <-chan []byte) error {
+		for result := range ch {
+			results = append(results, string(result))
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	mon := MakeMonitor(10, &wg)
+	start := time.Now()
+	ProcessContext(ctx, mon)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("interrupted Process took %s", d)
+	}
+	mon.Stop()
+	wg.Wait()
+
+	if len(results) != 10 {
+		t.Errorf("expected the 10 completed results to be written, got %d", len(results))
+	}
+	for _, result := range results {
+		if strings.Contains(result, `"domain":"stall"`) {
+			t.Errorf("unexpected result of the abandoned scan: %s", result)
+		}
+	}
+}
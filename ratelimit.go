@@ -0,0 +1,163 @@
+package zgrab2
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows rate events per second, in bursts of up to burst events.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(1, math.Ceil(rate))
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// refill adds the tokens accumulated since the last call. The caller must hold
+// the lock.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// reserve takes a token, and returns how long to wait before using it.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel gives back a token that was reserved but not used.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	b.tokens = math.Min(b.burst, b.tokens+1)
+	b.mu.Unlock()
+}
+
+// idle returns true if the bucket has been full for a while, and can be
+// dropped.
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// Wait blocks until a token is available, or until ctx is done.
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	delay := b.reserve()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
+
+// subnetKey returns the /24 network of an IPv4 address, or the /48 network
+// of an IPv6 address.
+func subnetKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return string(ip4[:3])
+	}
+	return string(ip.To16()[:6])
+}
+
+// connectLimiter bounds the rate of connection attempts, globally and to
+// each destination network.
+type connectLimiter struct {
+	global *tokenBucket
+
+	subnetRate float64
+	mu         sync.Mutex
+	subnets    map[string]*tokenBucket
+	lastSweep  time.Time
+}
+
+// newConnectLimiter returns a limiter allowing rate connections per second
+// overall, and subnetRate per /24 (or /48) network. Zero means no limit.
+func newConnectLimiter(rate, subnetRate float64) *connectLimiter {
+	l := &connectLimiter{subnetRate: subnetRate, subnets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+	if rate > 0 {
+		l.global = newTokenBucket(rate)
+	}
+	return l
+}
+
+// subnet returns the bucket for the network of ip, dropping the buckets
+// that have not been used in a while.
+func (l *connectLimiter) subnet(ip net.IP) *tokenBucket {
+	key := subnetKey(ip)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := time.Now(); now.Sub(l.lastSweep) > time.Minute {
+		for k, b := range l.subnets {
+			if b.idle(now) {
+				delete(l.subnets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.subnets[key]
+	if !ok {
+		b = newTokenBucket(l.subnetRate)
+		l.subnets[key] = b
+	}
+	return b
+}
+
+// Wait blocks until a connection to address (a host:port) is allowed, or
+// until ctx is done. The per-network limit only applies to IP addresses.
+func (l *connectLimiter) Wait(ctx context.Context, address string) error {
+	if l.subnetRate > 0 {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			if err := l.subnet(ip).Wait(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	if l.global != nil {
+		return l.global.Wait(ctx)
+	}
+	return nil
+}
+
+// connectLimit is configured from --rate and --subnet-rate.
+var connectLimit *connectLimiter
+
+// waitToConnect blocks until the rate limits of ctx (those of its Engine, or
+// the configured ones) allow a connection to address, or until ctx is done.
+func waitToConnect(ctx context.Context, address string) error {
+	limiter := contextDialPolicy(ctx).limiter
+	if limiter == nil {
+		return nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return limiter.Wait(ctx, address)
+}
@@ -0,0 +1,74 @@
+package zgrab2
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSubnetKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"10.0.0.1", "10.0.0.254", true},
+		{"10.0.0.1", "10.0.1.1", false},
+		{"10.0.0.1", "::ffff:10.0.0.2", true},
+		{"2001:db8:1::1", "2001:db8:1:ffff::1", true},
+		{"2001:db8:1::1", "2001:db8:2::1", false},
+	}
+	for _, test := range tests {
+		same := subnetKey(net.ParseIP(test.a)) == subnetKey(net.ParseIP(test.b))
+		if same != test.same {
+			t.Errorf("%s and %s: expected same network = %v", test.a, test.b, test.same)
+		}
+	}
+}
+
+// timeConnects returns how long it takes to get through n waits on address.
+func timeConnects(t *testing.T, l *connectLimiter, address string, n int) time.Duration {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if err := l.Wait(context.Background(), address); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return time.Since(start)
+}
+
+func TestConnectLimiterGlobal(t *testing.T) {
+	l := newConnectLimiter(100, 0)
+	// the first 100 are the initial burst, the next 20 take 200ms
+	if d := timeConnects(t, l, "10.0.0.1:80", 120); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("120 connects at 100/s took %s", d)
+	}
+}
+
+func TestConnectLimiterSubnet(t *testing.T) {
+	l := newConnectLimiter(0, 10)
+	if d := timeConnects(t, l, "10.0.0.1:80", 10); d > 50*time.Millisecond {
+		t.Errorf("burst took %s", d)
+	}
+	// another network is not limited by the first one
+	if d := timeConnects(t, l, "10.0.1.1:80", 10); d > 50*time.Millisecond {
+		t.Errorf("burst on another network took %s", d)
+	}
+	// domain names are not limited per network
+	if d := timeConnects(t, l, "example.com:80", 100); d > 50*time.Millisecond {
+		t.Errorf("domain names were limited: %s", d)
+	}
+	if d := timeConnects(t, l, "10.0.0.2:80", 2); d < 150*time.Millisecond {
+		t.Errorf("expected the network to be limited, took %s", d)
+	}
+}
+
+func TestConnectLimiterCancel(t *testing.T) {
+	l := newConnectLimiter(1, 0)
+	timeConnects(t, l, "10.0.0.1:80", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, "10.0.0.1:80"); err != context.DeadlineExceeded {
+		t.Errorf("expected the wait to be cancelled, got %v", err)
+	}
+}
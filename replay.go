@@ -0,0 +1,181 @@
+package zgrab2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayCommand contains the command line options for replaying the
+// transcripts recorded by a previous scan.
+type ReplayCommand struct {
+	ConfigFileName string `short:"c" long:"config-file" default:"-" description:"Config filename of the modules to replay, in the format of multiple, use - for stdin"`
+
+	// active is set when the replay command is the one run.
+	active bool
+}
+
+// Validate the options sent to ReplayCommand
+func (x *ReplayCommand) Validate(args []string) error {
+	if x.ConfigFileName == config.InputFileName {
+		return errors.New("cannot receive config file and input file from same source")
+	}
+	x.active = true
+	return nil
+}
+
+// Help returns a usage string that will be output at the command line
+func (x *ReplayCommand) Help() string {
+	return "Reads the output of a scan run with --transcript --debug, and runs the modules of the config file " +
+		"again on the recorded data, instead of connecting to the targets. Each module replays the response " +
+		"recorded under its name."
+}
+
+// ErrNotRecorded is returned when replaying a scan that opens more
+// connections than were recorded.
+var ErrNotRecorded = errors.New("connection not recorded")
+
+// InputReplayTargets is an InputTargetsFunc reading the output of a previous
+// scan from the input file.
+func InputReplayTargets(ch chan<- ScanTarget) error {
+	return GetReplayTargets(config.inputFile, ch)
+}
+
+// GetReplayTargets reads the JSON lines output by a previous scan, and sends
+// their targets: the scanners that have a response recorded under their name
+// replay it, and the others are skipped.
+func GetReplayTargets(source io.Reader, ch chan<- ScanTarget) error {
+	grabs := make(chan Grab)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- GetBanners(source, grabs)
+		close(grabs)
+	}()
+	for grab := range grabs {
+		target := ScanTarget{
+			IP:     net.ParseIP(grab.IP),
+			Domain: grab.Domain,
+			replay: grab.Data,
+		}
+		if grab.Port != 0 {
+			port := grab.Port
+			target.Port = &port
+		}
+		if target.replay == nil {
+			target.replay = make(map[string]ScanResponse)
+		}
+		ch <- target
+	}
+	return <-errc
+}
+
+// replayKey is the context key of the replayer of a scan.
+type replayKey struct{}
+
+// replayer hands out the recorded connections of a Transcript, in order.
+type replayer struct {
+	mu         sync.Mutex
+	transcript *Transcript
+	next       int
+}
+
+// withReplay returns a context whose connections replay the transcript.
+func withReplay(ctx context.Context, transcript *Transcript) context.Context {
+	return context.WithValue(ctx, replayKey{}, &replayer{transcript: transcript})
+}
+
+// contextReplay returns the replayer of ctx, if any.
+func contextReplay(ctx context.Context) *replayer {
+	r, _ := ctx.Value(replayKey{}).(*replayer)
+	return r
+}
+
+// dial returns the next recorded connection, which must be on network.
+func (r *replayer) dial(network string) (net.Conn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next == len(r.transcript.Connections) {
+		return nil, ErrNotRecorded
+	}
+	id := r.next
+	r.next++
+	recorded := r.transcript.Connections[id]
+	if !strings.HasPrefix(network, recorded.Network) {
+		return nil, ErrNotRecorded
+	}
+	conn := &replayConn{
+		local:  replayAddr{network: recorded.Network, address: recorded.Local},
+		remote: replayAddr{network: recorded.Network, address: recorded.Remote},
+	}
+	for _, event := range r.transcript.Events {
+		if event.Connection == id && event.Direction == "read" {
+			conn.reads = append(conn.reads, event)
+		}
+	}
+	return conn, nil
+}
+
+// replayConn is a net.Conn returning the data recorded on a connection, in
+// the same chunks, and the same errors. The writes are discarded.
+type replayConn struct {
+	reads      []TranscriptEvent
+	pending    []byte
+	pendingErr error
+	local      replayAddr
+	remote     replayAddr
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		if c.pendingErr != nil {
+			err := c.pendingErr
+			c.pendingErr = nil
+			return 0, err
+		}
+		if len(c.reads) == 0 {
+			// nothing more was recorded: the original scan stopped reading
+			return 0, os.ErrDeadlineExceeded
+		}
+		c.pending, c.pendingErr = c.reads[0].Data, replayError(c.reads[0].Error)
+		c.reads = c.reads[1:]
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *replayConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (c *replayConn) Close() error                       { return nil }
+func (c *replayConn) LocalAddr() net.Addr                { return c.local }
+func (c *replayConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// replayError returns an error like the one recorded with the message msg.
+func replayError(msg string) error {
+	switch {
+	case msg == "":
+		return nil
+	case msg == io.EOF.Error():
+		return io.EOF
+	case strings.HasSuffix(msg, "i/o timeout") || msg == ErrTotalTimeout.Error():
+		return os.ErrDeadlineExceeded
+	default:
+		return errors.New(msg)
+	}
+}
+
+// replayAddr is a recorded address.
+type replayAddr struct {
+	network string
+	address string
+}
+
+func (a replayAddr) Network() string { return a.network }
+func (a replayAddr) String() string  { return a.address }
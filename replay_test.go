@@ -0,0 +1,101 @@
+package zgrab2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	port := echoListener(t)
+	scanner := &pingScanner{echoScanner: echoScanner{name: "ping"}, ping: "hello"}
+	refused := &fixedScanner{echoScanner: echoScanner{name: "refused"}, status: SCAN_CONNECTION_REFUSED}
+	target := ScanTarget{IP: net.ParseIP("127.0.0.1"), Port: &port}
+
+	// record the scan, as output with --transcript --debug
+	engine, err := NewEngine(EngineConfig{ContinueOnError: true, TranscriptLimit: 1024}, scanner, refused)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var grabs []*Grab
+	if err := engine.Run(context.Background(), IterateTargets([]ScanTarget{target}), collect(&grabs)); err != nil {
+		t.Fatal(err)
+	}
+	output, err := EncodeGrab(grabs[0], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replay it without the listener, and with a scanner that did not run
+	scanner.ping = "olleh"
+	other := &fixedScanner{echoScanner: echoScanner{name: "other"}, status: SCAN_SUCCESS}
+	ch := make(chan ScanTarget, 1)
+	if err := GetReplayTargets(bytes.NewReader(output), ch); err != nil {
+		t.Fatal(err)
+	}
+	close(ch)
+	var targets []ScanTarget
+	for target := range ch {
+		targets = append(targets, target)
+	}
+	if len(targets) != 1 || *targets[0].Port != port {
+		t.Fatalf("unexpected targets %v", targets)
+	}
+	engine, err = NewEngine(EngineConfig{ContinueOnError: true}, scanner, refused, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grabs = nil
+	if err := engine.Run(context.Background(), IterateTargets(targets), collect(&grabs)); err != nil {
+		t.Fatal(err)
+	}
+	data := grabs[0].Data
+	if res := data["ping"]; res.Status != SCAN_SUCCESS || res.Result != "hello" {
+		t.Errorf("expected the recorded reply, got %+v", res)
+	}
+	if res := data["refused"]; res.Status != SCAN_CONNECTION_REFUSED {
+		t.Errorf("expected the recorded response, got %+v", res)
+	}
+	if _, ok := data["other"]; ok {
+		t.Error("expected the scanner without a recorded response to be skipped")
+	}
+}
+
+func TestReplayConn(t *testing.T) {
+	r := &replayer{transcript: &Transcript{
+		Connections: []TranscriptConnection{{Network: "tcp", Remote: "192.0.2.1:25"}},
+		Events: []TranscriptEvent{
+			{Connection: 0, Direction: "read", Data: []byte("220 ")},
+			{Connection: 0, Direction: "write", Data: []byte("QUIT\r\n")},
+			{Connection: 0, Direction: "read", Data: []byte("ready\r\n"), Error: "EOF"},
+		},
+	}}
+	if _, err := r.dial("udp"); err != ErrNotRecorded {
+		t.Errorf("expected ErrNotRecorded for another network, got %v", err)
+	}
+	r.next = 0
+	conn, err := r.dial("tcp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.RemoteAddr().String() != "192.0.2.1:25" {
+		t.Errorf("unexpected remote address %s", conn.RemoteAddr())
+	}
+	buf := make([]byte, 8)
+	for _, expected := range []struct {
+		data string
+		err  error
+	}{{"220 ", nil}, {"ready\r\n", nil}, {"", io.EOF}, {"", os.ErrDeadlineExceeded}} {
+		n, err := conn.Read(buf)
+		if string(buf[:n]) != expected.data || !errors.Is(err, expected.err) {
+			t.Errorf("expected %q, %v; got %q, %v", expected.data, expected.err, buf[:n], err)
+		}
+	}
+	if _, err := r.dial("tcp"); err != ErrNotRecorded {
+		t.Errorf("expected ErrNotRecorded past the recorded connections, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package zgrab2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResolverConfig holds the options of a Resolver.
+type ResolverConfig struct {
+	// Servers, if set, are the DNS servers queried, in turn, as IP addresses
+	// with an optional port (53 by default). Otherwise the system resolver
+	// is used.
+	Servers []string
+
+	// CacheTTL, if positive, is how long the addresses of a domain, or the
+	// absence of any, are remembered.
+	CacheTTL time.Duration
+
+	// PreferIPv6 scans the first IPv6 address of a domain rather than the
+	// first IPv4 one, if it has both, and IPv4Only ignores its IPv6
+	// addresses.
+	PreferIPv6 bool
+	IPv4Only   bool
+
+	// AllAddresses scans each address of a domain, rather than only the
+	// first one.
+	AllAddresses bool
+}
+
+// Resolver resolves the domains of the targets to the addresses to scan. It
+// is safe for concurrent use.
+type Resolver struct {
+	config   ResolverConfig
+	resolver *net.Resolver
+
+	mu    sync.Mutex
+	cache map[string]*resolverEntry
+
+	// nextSweep is when the expired entries are next removed from cache.
+	nextSweep time.Time
+}
+
+// resolverEntry is the result of a lookup, available once done is closed.
+type resolverEntry struct {
+	done    chan struct{}
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// dnsResolver resolves the domain-only targets of the command line, and the
+// domains dialed during their scans.
+var dnsResolver *Resolver
+
+// NewResolver returns a Resolver with the given options.
+func NewResolver(config ResolverConfig) (*Resolver, error) {
+	if config.PreferIPv6 && config.IPv4Only {
+		return nil, errors.New("cannot both prefer IPv6 and only use IPv4")
+	}
+	if config.CacheTTL < 0 {
+		return nil, errors.New("cache TTL must not be negative")
+	}
+	r := &Resolver{config: config, resolver: net.DefaultResolver, cache: make(map[string]*resolverEntry)}
+	if len(config.Servers) > 0 {
+		servers := make([]string, len(config.Servers))
+		for i, server := range config.Servers {
+			address, err := resolverAddress(server)
+			if err != nil {
+				return nil, err
+			}
+			servers[i] = address
+		}
+		var next atomic.Uint32
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, servers[(next.Add(1)-1)%uint32(len(servers))])
+			},
+		}
+	}
+	return r, nil
+}
+
+// ParseResolvers parses a comma-separated list of DNS servers.
+func ParseResolvers(s string) ([]string, error) {
+	var servers []string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if _, err := resolverAddress(field); err != nil {
+			return nil, err
+		}
+		servers = append(servers, field)
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no DNS server")
+	}
+	return servers, nil
+}
+
+// resolverAddress returns the host:port address of a DNS server given as an
+// IP address with an optional port.
+func resolverAddress(server string) (string, error) {
+	if ip := net.ParseIP(strings.Trim(server, "[]")); ip != nil {
+		return net.JoinHostPort(ip.String(), "53"), nil
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid DNS server %q", server)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Resolve returns the addresses of domain to scan: the first one in the
+// preferred family, or all of them, with those of the preferred family
+// first.
+func (r *Resolver) Resolve(ctx context.Context, domain string) ([]net.IP, error) {
+	ips, err := r.lookup(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			v4 = append(v4, ip4)
+		} else if !r.config.IPv4Only {
+			v6 = append(v6, ip)
+		}
+	}
+	ips = append(v4, v6...)
+	if r.config.PreferIPv6 {
+		ips = append(v6, v4...)
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no suitable address", Name: domain, IsNotFound: true}
+	}
+	if !r.config.AllAddresses {
+		ips = ips[:1]
+	}
+	return ips, nil
+}
+
+// lookup returns the addresses of domain, from the cache if they are fresh.
+// Concurrent lookups of the same domain share the same query.
+func (r *Resolver) lookup(ctx context.Context, domain string) ([]net.IP, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	network := "ip"
+	if r.config.IPv4Only {
+		network = "ip4"
+	}
+	if r.config.CacheTTL <= 0 {
+		return r.resolver.LookupIP(ctx, network, domain)
+	}
+	r.mu.Lock()
+	entry, ok := r.cache[domain]
+	if ok {
+		select {
+		case <-entry.done:
+			if time.Now().After(entry.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		if now := time.Now(); now.After(r.nextSweep) {
+			r.sweep(now)
+			r.nextSweep = now.Add(r.config.CacheTTL)
+		}
+		entry = &resolverEntry{done: make(chan struct{})}
+		r.cache[domain] = entry
+		r.mu.Unlock()
+		entry.ips, entry.err = r.resolver.LookupIP(ctx, network, domain)
+		entry.expires = time.Now().Add(r.config.CacheTTL)
+		var dnsErr *net.DNSError
+		if entry.err != nil && !(errors.As(entry.err, &dnsErr) && dnsErr.IsNotFound) {
+			// only remember that the domain does not exist, not the failures
+			entry.expires = time.Time{}
+		}
+		close(entry.done)
+		return entry.ips, entry.err
+	}
+	r.mu.Unlock()
+	select {
+	case <-entry.done:
+		return entry.ips, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// sweep removes the expired entries from the cache, so that it holds the
+// domains of at most two TTLs. It must be called with r.mu held.
+func (r *Resolver) sweep(now time.Time) {
+	for domain, entry := range r.cache {
+		select {
+		case <-entry.done:
+			if now.After(entry.expires) {
+				delete(r.cache, domain)
+			}
+		default:
+			// lookup in progress
+		}
+	}
+}
+
+// netResolver returns the net.Resolver querying the configured servers, or
+// nil for the system resolver.
+func (r *Resolver) netResolver() *net.Resolver {
+	if r == nil || r.resolver == net.DefaultResolver {
+		return nil
+	}
+	return r.resolver
+}
+
+// resolveTargets returns the targets to scan for input: itself if it has an
+// IP address or no domain, otherwise one for each address of its domain to
+// scan.
+func resolveTargets(ctx context.Context, r *Resolver, input ScanTarget) ([]ScanTarget, error) {
+	if r == nil || input.IP != nil || input.Domain == "" || input.replay != nil {
+		return []ScanTarget{input}, nil
+	}
+	ips, err := r.Resolve(ctx, input.Domain)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]ScanTarget, len(ips))
+	for i, ip := range ips {
+		targets[i] = input
+		targets[i].IP = ip
+	}
+	return targets, nil
+}
@@ -0,0 +1,231 @@
+package zgrab2
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsListener serves example.com with two IPv4 addresses and one IPv6
+// address, and no other domain, on a local UDP port. It returns the address
+// of the server and the number of queries received.
+func dnsListener(t *testing.T) (string, *atomic.Int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var queries atomic.Int32
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if query.Unpack(buf[:n]) != nil || len(query.Questions) != 1 {
+				continue
+			}
+			queries.Add(1)
+			q := query.Questions[0]
+			reply := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+				Questions: query.Questions,
+			}
+			header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60}
+			switch {
+			case q.Name.String() != "example.com.":
+				reply.RCode = dnsmessage.RCodeNameError
+			case q.Type == dnsmessage.TypeA:
+				reply.Answers = []dnsmessage.Resource{
+					{Header: header, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+					{Header: header, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}}},
+				}
+			case q.Type == dnsmessage.TypeAAAA:
+				ip := [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}
+				reply.Answers = []dnsmessage.Resource{{Header: header, Body: &dnsmessage.AAAAResource{AAAA: ip}}}
+			}
+			packed, err := reply.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(packed, addr)
+		}
+	}()
+	return conn.LocalAddr().String(), &queries
+}
+
+func TestResolver(t *testing.T) {
+	server, queries := dnsListener(t)
+	for _, test := range []struct {
+		config   ResolverConfig
+		expected []string
+	}{
+		{ResolverConfig{}, []string{"192.0.2.1"}},
+		{ResolverConfig{PreferIPv6: true}, []string{"2001:db8::1"}},
+		{ResolverConfig{AllAddresses: true}, []string{"192.0.2.1", "192.0.2.2", "2001:db8::1"}},
+		{ResolverConfig{AllAddresses: true, PreferIPv6: true}, []string{"2001:db8::1", "192.0.2.1", "192.0.2.2"}},
+		{ResolverConfig{AllAddresses: true, IPv4Only: true}, []string{"192.0.2.1", "192.0.2.2"}},
+	} {
+		test.config.Servers = []string{server}
+		r, err := NewResolver(test.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ips, err := r.Resolve(context.Background(), "example.com")
+		if err != nil {
+			t.Fatalf("%+v: %v", test.config, err)
+		}
+		var got []string
+		for _, ip := range ips {
+			got = append(got, ip.String())
+		}
+		if len(got) != len(test.expected) {
+			t.Errorf("%+v: expected %v, got %v", test.config, test.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%+v: expected %v, got %v", test.config, test.expected, got)
+				break
+			}
+		}
+	}
+
+	// the second lookup, and the second failure, come from the cache
+	r, err := NewResolver(ResolverConfig{Servers: []string{server}, CacheTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, domain := range []string{"example.com", "missing.example.com"} {
+		_, first := r.Resolve(context.Background(), domain)
+		before := queries.Load()
+		_, second := r.Resolve(context.Background(), domain)
+		if queries.Load() != before || (first == nil) != (second == nil) {
+			t.Errorf("%s: expected the cached result, got %v after %v", domain, second, first)
+		}
+	}
+	var dnsErr *net.DNSError
+	if _, err := r.Resolve(context.Background(), "missing.example.com"); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	if _, err := NewResolver(ResolverConfig{PreferIPv6: true, IPv4Only: true}); err == nil {
+		t.Error("expected an error for conflicting families")
+	}
+	if _, err := ParseResolvers("8.8.8.8, [2001:db8::53]:5353"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseResolvers("dns.example.com"); err == nil {
+		t.Error("expected an error for a server given by name")
+	}
+}
+
+func TestResolverEviction(t *testing.T) {
+	server, _ := dnsListener(t)
+	r, err := NewResolver(ResolverConfig{Servers: []string{server}, CacheTTL: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, domain := range []string{"a.example.com", "b.example.com"} {
+		r.Resolve(context.Background(), domain)
+	}
+	time.Sleep(100 * time.Millisecond)
+	// the expired entries are swept when the next domain is added
+	r.Resolve(context.Background(), "example.com")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.cache["example.com"]; len(r.cache) != 1 || !ok {
+		t.Errorf("expected only the last domain to be cached, got %v", r.cache)
+	}
+}
+
+func TestEngineResolve(t *testing.T) {
+	server, _ := dnsListener(t)
+	r, err := NewResolver(ResolverConfig{Servers: []string{server}, AllAddresses: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := &fixedScanner{echoScanner: echoScanner{name: "echo"}, status: SCAN_SUCCESS}
+	engine, err := NewEngine(EngineConfig{Resolver: r}, scanner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := []ScanTarget{{Domain: "example.com"}, {Domain: "missing.example.com"}, {IP: net.ParseIP("192.0.2.9")}}
+	var grabs []*Grab
+	if err := engine.Run(context.Background(), IterateTargets(targets), collect(&grabs)); err != nil {
+		t.Fatal(err)
+	}
+	ips := make(map[string]bool)
+	for _, grab := range grabs {
+		switch grab.Domain {
+		case "example.com":
+			if grab.outputs != 3 || grab.Data["echo"].Status != SCAN_SUCCESS {
+				t.Errorf("unexpected grab %+v", grab)
+			}
+			ips[grab.IP] = true
+		case "missing.example.com":
+			if grab.ResolveError == "" || grab.IP != "" || grab.Data != nil {
+				t.Errorf("expected a resolution error, got %+v", grab)
+			}
+		default:
+			if grab.IP != "192.0.2.9" || grab.ResolveError != "" {
+				t.Errorf("unexpected grab %+v", grab)
+			}
+		}
+	}
+	if len(grabs) != 5 || len(ips) != 3 || !ips["2001:db8::1"] {
+		t.Errorf("expected one grab per address, got %d grabs of %v", len(grabs), ips)
+	}
+}
+
+func TestEngineResolveOutputSplit(t *testing.T) {
+	server, _ := dnsListener(t)
+	r, err := NewResolver(ResolverConfig{Servers: []string{server}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := &fixedScanner{echoScanner: echoScanner{name: "echo"}, status: SCAN_SUCCESS}
+	engine, err := NewEngine(EngineConfig{Resolver: r}, scanner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	sink, err := NewSink(filepath.Join(dir, "out.json"), SinkOptions{SplitByModule: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	write := func(grab *Grab) error {
+		record, err := EncodeGrab(grab, false)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return sink.WriteRecord(record)
+	}
+	targets := []ScanTarget{{Domain: "missing.example.com"}, {IP: net.ParseIP("192.0.2.9")}}
+	if err := engine.Run(context.Background(), IterateTargets(targets), write); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	failed := readLines(t, filepath.Join(dir, "out.json"))
+	if len(failed) != 1 || !strings.Contains(failed[0], `"domain":"missing.example.com"`) || !strings.Contains(failed[0], `"resolve_error"`) {
+		t.Errorf("expected the resolution error in out.json, got %v", failed)
+	}
+	if scanned := readLines(t, filepath.Join(dir, "out.echo.json")); len(scanned) != 1 || !strings.Contains(scanned[0], "192.0.2.9") {
+		t.Errorf("expected the scanned target in out.echo.json, got %v", scanned)
+	}
+}
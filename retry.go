@@ -0,0 +1,79 @@
+package zgrab2
+
+import (
+	"context"
+	"time"
+)
+
+// RetryOptOut is implemented by scanners that retry failed connections
+// themselves. When NoRetry returns true, the framework does not apply
+// --retries to the scanner.
+type RetryOptOut interface {
+	NoRetry() bool
+}
+
+// isRetryable returns true if a scan that ended with status may succeed if
+// tried again.
+func isRetryable(status ScanStatus) bool {
+	switch status {
+	case SCAN_CONNECTION_TIMEOUT, SCAN_IO_TIMEOUT, SCAN_CONNECTION_CLOSED:
+		return true
+	}
+	return false
+}
+
+// retryPolicy is the number of retries of a scan with a transient failure,
+// and the delay before the first one.
+type retryPolicy struct {
+	retries int
+	backoff time.Duration
+}
+
+// configRetryPolicy returns the policy of --retries and --retry-backoff.
+func configRetryPolicy() retryPolicy {
+	return retryPolicy{retries: config.Retries, backoff: config.RetryBackoff}
+}
+
+// maxRetries returns the number of times a failed scan of s may be retried.
+func (p retryPolicy) maxRetries(s Scanner) int {
+	if opt, ok := s.(RetryOptOut); ok && opt.NoRetry() {
+		return 0
+	}
+	return p.retries
+}
+
+// delay returns the time to wait before the given retry (starting at 1),
+// doubling the backoff each time.
+func (p retryPolicy) delay(retry int) time.Duration {
+	delay := p.backoff
+	for i := 1; i < retry && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// scan runs the scan, retrying it on transient failures until ctx is done.
+// It returns the outcome of the last attempt and the number of attempts.
+func (p retryPolicy) scan(ctx context.Context, s Scanner, target ScanTarget) (ScanStatus, interface{}, int, error) {
+	retries := p.maxRetries(s)
+	for attempt := 1; ; attempt++ {
+		var status ScanStatus
+		var res interface{}
+		var err error
+		if cs, ok := s.(ContextScanner); ok {
+			status, res, err = cs.ScanContext(ctx, target)
+		} else {
+			status, res, err = s.Scan(target)
+		}
+		if attempt > retries || !isRetryable(status) {
+			return status, res, attempt, err
+		}
+		timer := time.NewTimer(p.delay(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return status, res, attempt, err
+		}
+	}
+}
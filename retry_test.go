@@ -0,0 +1,72 @@
+package zgrab2
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyScanner fails with status until it has been called failures times.
+type flakyScanner struct {
+	echoScanner
+	status   ScanStatus
+	failures int
+	calls    int
+	noRetry  bool
+}
+
+func (s *flakyScanner) NoRetry() bool { return s.noRetry }
+func (s *flakyScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return s.status, nil, errors.New("failed")
+	}
+	return SCAN_SUCCESS, "ok", nil
+}
+
+// withRetries sets --retries and --retry-backoff for the duration of the test.
+func withRetries(t *testing.T, retries int, backoff time.Duration) {
+	savedRetries, savedBackoff := config.Retries, config.RetryBackoff
+	t.Cleanup(func() { config.Retries, config.RetryBackoff = savedRetries, savedBackoff })
+	config.Retries, config.RetryBackoff = retries, backoff
+}
+
+func TestRunScannerRetries(t *testing.T) {
+	withRetries(t, 2, time.Millisecond)
+	var wg sync.WaitGroup
+	mon := MakeMonitor(10, &wg)
+	defer func() {
+		mon.Stop()
+		wg.Wait()
+	}()
+
+	tests := []struct {
+		scanner  *flakyScanner
+		status   ScanStatus
+		attempts int
+		calls    int
+	}{
+		{&flakyScanner{status: SCAN_IO_TIMEOUT, failures: 2}, SCAN_SUCCESS, 3, 3},
+		{&flakyScanner{status: SCAN_CONNECTION_CLOSED, failures: 5}, SCAN_CONNECTION_CLOSED, 3, 3},
+		{&flakyScanner{status: SCAN_CONNECTION_REFUSED, failures: 5}, SCAN_CONNECTION_REFUSED, 1, 1},
+		{&flakyScanner{status: SCAN_CONNECTION_TIMEOUT, failures: 5, noRetry: true}, SCAN_CONNECTION_TIMEOUT, 0, 1},
+	}
+	for i, test := range tests {
+		test.scanner.name = "retry-test"
+		_, resp := RunScanner(test.scanner, mon, ScanTarget{})
+		if resp.Status != test.status || resp.Attempts != test.attempts || test.scanner.calls != test.calls {
+			t.Errorf("%d: expected %s after %d attempts (%d calls), got %s after %d (%d calls)",
+				i, test.status, test.attempts, test.calls, resp.Status, resp.Attempts, test.scanner.calls)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := retryPolicy{retries: 3, backoff: time.Second}
+	for retry, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if d := p.delay(retry + 1); d != expected {
+			t.Errorf("retry %d: expected %s, got %s", retry+1, expected, d)
+		}
+	}
+}
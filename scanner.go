@@ -1,6 +1,7 @@
 package zgrab2
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -29,19 +30,109 @@ func PrintScanners() {
 
 // RunScanner runs a single scan on a target and returns the resulting data
 func RunScanner(s Scanner, mon *Monitor, target ScanTarget) (string, ScanResponse) {
+	return RunScannerContext(context.Background(), s, mon, target)
+}
+
+// RunScannerContext is RunScanner, cancelling the scan when ctx is done.
+// Scanners implementing ContextScanner get ctx; for the others, it only
+// applies to the connections opened through the target.
+func RunScannerContext(ctx context.Context, s Scanner, mon *Monitor, target ScanTarget) (string, ScanResponse) {
+	return s.GetName(), runScanner(ctx, s, mon, target, configScanOptions())
+}
+
+// scanOptions are the framework options applied to each scan.
+type scanOptions struct {
+	retry retryPolicy
+
+	// transcriptLimit, if positive, adds the transcript of the connections
+	// to the response, up to transcriptLimit bytes of data.
+	transcriptLimit int
+
+	// pcap, if set, receives the packets of the connections.
+	pcap *PcapWriter
+}
+
+// configScanOptions returns the scanOptions of the command line.
+func configScanOptions() scanOptions {
+	return scanOptions{retry: configRetryPolicy(), transcriptLimit: configTranscriptLimit(), pcap: pcapOutput}
+}
+
+// runScanner runs a single scan on a target, with the given options.
+func runScanner(ctx context.Context, s Scanner, mon *Monitor, target ScanTarget, opts scanOptions) ScanResponse {
+	resp, report := runScannerDeferred(ctx, s, mon, target, opts)
+	report()
+	return resp
+}
+
+// runScannerDeferred is runScanner, except that the scan is only reported to
+// the metrics, the Monitor and the pcap output when report is called, so
+// that the scans whose response is dropped can be left out.
+func runScannerDeferred(ctx context.Context, s Scanner, mon *Monitor, target ScanTarget, opts scanOptions) (resp ScanResponse, report func()) {
+	if target.replay != nil {
+		recorded := target.replay[s.GetName()]
+		if recorded.Transcript == nil {
+			// the scan did not connect, there is nothing to replay
+			return recorded, func() {
+				if recorded.Error == nil {
+					mon.report(moduleStatus{name: s.GetName(), st: statusSuccess})
+				} else {
+					mon.report(moduleStatus{name: s.GetName(), st: statusFailure})
+				}
+			}
+		}
+		ctx = withReplay(ctx, recorded.Transcript)
+	}
+	var transcript *transcriptRecorder
+	if opts.pcap != nil {
+		// keep all the data for the packets
+		ctx, transcript = withTranscript(ctx, 0)
+	} else if opts.transcriptLimit > 0 {
+		ctx, transcript = withTranscript(ctx, opts.transcriptLimit)
+	}
+	target.stats = new(scanStats)
+	target.ctx = ctx
 	t := time.Now()
-	status, res, e := s.Scan(target)
-	var err *string
-	if e == nil {
-		mon.statusesChan <- moduleStatus{name: s.GetName(), st: statusSuccess}
-		err = nil
+	var status ScanStatus
+	var res interface{}
+	var e error
+	var attempts int
+	if target.IP != nil && !IsAddressAllowedContext(ctx, target.IP) {
+		status, e = SCAN_BLOCKLISTED, ErrBlocklisted
+	} else if err := ctx.Err(); err != nil {
+		// out of --target-timeout after the previous scanners
+		status, e = TryGetScanStatus(err), err
 	} else {
-		mon.statusesChan <- moduleStatus{name: s.GetName(), st: statusFailure}
+		status, res, attempts, e = opts.retry.scan(ctx, s, target)
+	}
+	elapsed := time.Since(t)
+	var err *string
+	if e != nil {
 		errString := e.Error()
 		err = &errString
 	}
-	resp := ScanResponse{Result: res, Protocol: s.Protocol(), Error: err, Timestamp: t.Format(time.RFC3339), Status: status}
-	return s.GetName(), resp
+	resp = ScanResponse{Result: res, Protocol: s.Protocol(), Error: err, Timestamp: t.Format(time.RFC3339), Status: status}
+	if opts.retry.maxRetries(s) > 0 {
+		resp.Attempts = attempts
+	}
+	if opts.transcriptLimit > 0 {
+		resp.Transcript = transcript.result(opts.transcriptLimit)
+	}
+	return resp, func() {
+		observeScan(s.GetName(), status, elapsed, target.stats)
+		if e == nil {
+			mon.report(moduleStatus{name: s.GetName(), st: statusSuccess})
+		} else {
+			mon.report(moduleStatus{name: s.GetName(), st: statusFailure})
+		}
+		if opts.pcap != nil {
+			if recorded := transcript.result(0); recorded != nil {
+				comment := fmt.Sprintf("target: %s, scanner: %s", target.String(), s.GetName())
+				if err := opts.pcap.WriteTranscript(comment, transcript.start, recorded); err != nil {
+					log.Errorf("could not write the packets of %s: %s", s.GetName(), err)
+				}
+			}
+		}
+	}
 }
 
 func init() {
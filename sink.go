@@ -0,0 +1,447 @@
+package zgrab2
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms supported by the output sinks.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// ErrSinkStdout is returned when an option that needs several output files
+// is used when writing to stdout.
+var ErrSinkStdout = errors.New("output rotation and per-module split need an --output-file")
+
+// SinkOptions configure how a Sink lays out its output files.
+type SinkOptions struct {
+	// RotateSize is the number of bytes after which a new file is started,
+	// measured after compression. Compressors emit data in blocks, so
+	// compressed files may exceed it by up to a block. 0 disables size-based
+	// rotation.
+	RotateSize int64
+
+	// RotateInterval is the time after which a new file is started. It is
+	// checked when a record is written. 0 disables time-based rotation.
+	RotateInterval time.Duration
+
+	// Compression is one of CompressionNone (or ""), CompressionGzip or
+	// CompressionZstd.
+	Compression string
+
+	// SplitByModule writes the results of each scanner to its own file.
+	SplitByModule bool
+
+	// Resume, if not nil, gives the size of the output files at the last
+	// checkpoint of an interrupted run. The files are truncated to that size
+	// and appended to, and the files started after the checkpoint are
+	// removed.
+	Resume map[string]int64
+}
+
+func (opts *SinkOptions) rotating() bool {
+	return opts.RotateSize > 0 || opts.RotateInterval > 0
+}
+
+// Sink is a destination for encoded results, one record per line.
+type Sink interface {
+	// WriteRecord writes a single JSON-encoded Grab, without the trailing
+	// newline.
+	WriteRecord(record []byte) error
+
+	// Flush writes any buffered data to the underlying files.
+	Flush() error
+
+	// Close flushes the sink and closes its files. Compressed streams are
+	// not complete until Close has been called.
+	Close() error
+}
+
+// NewSink returns a Sink writing to path (or stdout if path is "-") as
+// configured by opts.
+//
+// With rotation, a sequence number is inserted before the extension of path,
+// so results.json is written as results.00000.json, results.00001.json, ...
+// With SplitByModule, the scanner name is inserted in the same way, as in
+// results.http.json, and the grabs without any scan response, such as those
+// of the domains that failed to resolve, are written to path itself. The
+// compression suffix (.gz or .zst) is appended unless
+// path already ends with it.
+func NewSink(path string, opts SinkOptions) (Sink, error) {
+	switch opts.Compression {
+	case "", CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return nil, fmt.Errorf("unknown output compression %q", opts.Compression)
+	}
+	if path == "-" {
+		if opts.rotating() || opts.SplitByModule {
+			return nil, ErrSinkStdout
+		}
+		s := &fileSink{opts: opts}
+		if err := s.wrap(os.Stdout); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if opts.SplitByModule {
+		return &splitSink{path: path, opts: opts, sinks: make(map[string]*fileSink)}, nil
+	}
+	return newFileSink(path, "", opts)
+}
+
+// sinkFileName returns the name of the seq-th file written for module
+// (which may be empty) when the output file is path.
+func sinkFileName(path string, module string, seq int, opts *SinkOptions) string {
+	suffix := ""
+	switch opts.Compression {
+	case CompressionGzip:
+		suffix = ".gz"
+	case CompressionZstd:
+		suffix = ".zst"
+	}
+	base := strings.TrimSuffix(path, suffix)
+	ext := filepath.Ext(base)
+	base = strings.TrimSuffix(base, ext)
+	if module != "" {
+		base += "." + strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(module)
+	}
+	if opts.rotating() {
+		base += fmt.Sprintf(".%05d", seq)
+	}
+	return base + ext + suffix
+}
+
+// countingWriter counts the bytes written to the underlying file.
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// fileSink writes records to a single (possibly rotated) file, through an
+// optional compressor.
+type fileSink struct {
+	path   string
+	module string
+	opts   SinkOptions
+
+	seq     int
+	name    string
+	opened  time.Time
+	file    *os.File
+	counter *countingWriter
+	comp    io.WriteCloser
+	buf     *bufio.Writer
+}
+
+func newFileSink(path string, module string, opts SinkOptions) (*fileSink, error) {
+	s := &fileSink{path: path, module: module, opts: opts}
+	open := s.open
+	if opts.Resume != nil {
+		open = s.resume
+	}
+	if err := open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open creates the current file and the writers on top of it.
+func (s *fileSink) open() error {
+	s.name = sinkFileName(s.path, s.module, s.seq, &s.opts)
+	f, err := os.Create(s.name)
+	if err != nil {
+		return err
+	}
+	if err := s.wrap(f); err != nil {
+		f.Close()
+		return err
+	}
+	s.opened = time.Now()
+	return nil
+}
+
+// resume reopens the file of this sink recorded in opts.Resume, truncated to
+// its recorded size, or starts from scratch if there is none. The later files
+// of a rotated sink are removed, since their contents will be written again.
+func (s *fileSink) resume() error {
+	var size int64
+	found := false
+	for seq := 0; ; seq++ {
+		// the last file of a rotated sink is the current one
+		name := sinkFileName(s.path, s.module, seq, &s.opts)
+		if sz, ok := s.opts.Resume[name]; ok {
+			s.seq, size, found = seq, sz, true
+		}
+		if _, err := os.Stat(name); err != nil || !s.opts.rotating() {
+			break
+		}
+	}
+	if !found {
+		s.seq = 0
+	}
+	for seq := s.seq + 1; s.opts.rotating(); seq++ {
+		if err := os.Remove(sinkFileName(s.path, s.module, seq, &s.opts)); err != nil {
+			break
+		}
+	}
+	if !found {
+		return s.open()
+	}
+
+	s.name = sinkFileName(s.path, s.module, s.seq, &s.opts)
+	f, err := os.OpenFile(s.name, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err == nil {
+		err = s.wrap(f)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.counter.count = size
+	s.opened = time.Now()
+	return nil
+}
+
+// wrap sets up the compressor and buffer writing to f.
+func (s *fileSink) wrap(f *os.File) error {
+	s.file = f
+	s.counter = &countingWriter{w: f}
+	var w io.Writer = s.counter
+	s.comp = nil
+	switch s.opts.Compression {
+	case CompressionGzip:
+		s.comp = gzip.NewWriter(s.counter)
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(s.counter)
+		if err != nil {
+			return err
+		}
+		s.comp = enc
+	}
+	if s.comp != nil {
+		w = s.comp
+	}
+	s.buf = bufio.NewWriter(w)
+	return nil
+}
+
+// finish flushes and terminates the compressed stream of the current file,
+// and closes it unless it is stdout.
+func (s *fileSink) finish() error {
+	err := s.buf.Flush()
+	if s.comp != nil {
+		if cerr := s.comp.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if s.file != os.Stdout {
+		if cerr := s.file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *fileSink) shouldRotate() bool {
+	if s.opts.RotateSize > 0 && s.counter.count >= s.opts.RotateSize {
+		return true
+	}
+	return s.opts.RotateInterval > 0 && time.Since(s.opened) >= s.opts.RotateInterval
+}
+
+// WriteRecord implements Sink.
+func (s *fileSink) WriteRecord(record []byte) error {
+	if s.path != "" && s.shouldRotate() {
+		if err := s.finish(); err != nil {
+			return err
+		}
+		s.seq++
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.buf.Write(record); err != nil {
+		return err
+	}
+	if err := s.buf.WriteByte('\n'); err != nil {
+		return err
+	}
+	// Without compression, the counter only sees the data once the buffer
+	// is flushed, so account for the buffered data as well.
+	if s.opts.RotateSize > 0 && s.comp == nil && s.counter.count+int64(s.buf.Buffered()) >= s.opts.RotateSize {
+		return s.buf.Flush()
+	}
+	return nil
+}
+
+// Flush implements Sink.
+func (s *fileSink) Flush() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if f, ok := s.comp.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (s *fileSink) Close() error {
+	return s.finish()
+}
+
+// Checkpoint writes all the records received so far to the current file, and
+// returns its name and size. A compressed stream is terminated and a new one
+// is started on the same file, so that the file is complete at this size:
+// concatenated gzip members and zstd frames are valid streams.
+func (s *fileSink) Checkpoint() (map[string]int64, error) {
+	if err := s.buf.Flush(); err != nil {
+		return nil, err
+	}
+	if s.comp != nil {
+		if err := s.comp.Close(); err != nil {
+			return nil, err
+		}
+		s.comp.(interface{ Reset(io.Writer) }).Reset(s.counter)
+	}
+	return map[string]int64{s.name: s.counter.count}, nil
+}
+
+// splitGrab is a Grab whose scan responses are kept encoded, so that they
+// can be split without being decoded.
+type splitGrab struct {
+	Grab
+	Data map[string]json.RawMessage `json:"data,omitempty"`
+}
+
+// splitSink writes the response of each scanner to a separate fileSink,
+// along with the target fields of the Grab. The grabs without a response are
+// written as is to the fileSink of the unsplit path, under the empty name.
+type splitSink struct {
+	path  string
+	opts  SinkOptions
+	sinks map[string]*fileSink
+}
+
+// WriteRecord implements Sink.
+func (s *splitSink) WriteRecord(record []byte) error {
+	var grab splitGrab
+	if err := json.Unmarshal(record, &grab); err != nil {
+		return err
+	}
+	if len(grab.Data) == 0 {
+		sink, err := s.sink("")
+		if err != nil {
+			return err
+		}
+		return sink.WriteRecord(record)
+	}
+	for name, data := range grab.Data {
+		sink, err := s.sink(name)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(splitGrab{Grab: grab.Grab, Data: map[string]json.RawMessage{name: data}})
+		if err != nil {
+			return err
+		}
+		if err := sink.WriteRecord(encoded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sink returns the fileSink of the scanner name, opening it on first use.
+func (s *splitSink) sink(name string) (*fileSink, error) {
+	if sink, ok := s.sinks[name]; ok {
+		return sink, nil
+	}
+	sink, err := newFileSink(s.path, name, s.opts)
+	if err != nil {
+		return nil, err
+	}
+	s.sinks[name] = sink
+	return sink, nil
+}
+
+// Flush implements Sink.
+func (s *splitSink) Flush() error {
+	for _, sink := range s.sinks {
+		if err := sink.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (s *splitSink) Close() error {
+	var ret error
+	for _, sink := range s.sinks {
+		if err := sink.Close(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+// Checkpoint calls Checkpoint on the sink of each scanner.
+func (s *splitSink) Checkpoint() (map[string]int64, error) {
+	ret := make(map[string]int64, len(s.sinks))
+	for _, sink := range s.sinks {
+		files, err := sink.Checkpoint()
+		if err != nil {
+			return nil, err
+		}
+		for name, size := range files {
+			ret[name] = size
+		}
+	}
+	return ret, nil
+}
+
+// OutputResultsSinkFunc returns an OutputResultsFunc that writes the results
+// to sink, and closes it once the results channel is closed.
+func OutputResultsSinkFunc(sink Sink) OutputResultsFunc {
+	return func(results <-chan []byte) error {
+		for result := range results {
+			if err := sink.WriteRecord(result); err != nil {
+				sink.Close()
+				return err
+			}
+			if config.Flush {
+				if err := sink.Flush(); err != nil {
+					sink.Close()
+					return err
+				}
+			}
+		}
+		return sink.Close()
+	}
+}
@@ -0,0 +1,212 @@
+package zgrab2
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// readLines reads the records of an output file, decompressing it according
+// to its extension.
+func readLines(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	switch filepath.Ext(path) {
+	case ".gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		r = gz
+	case ".zst":
+		dec, err := zstd.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		defer dec.Close()
+		r = dec
+	}
+	var ret []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		ret = append(ret, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return ret
+}
+
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ret []string
+	for _, e := range entries {
+		ret = append(ret, e.Name())
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func writeRecords(t *testing.T, sink Sink, records ...string) {
+	for _, r := range records {
+		if err := sink.WriteRecord([]byte(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSinkFileName(t *testing.T) {
+	tests := []struct {
+		path   string
+		module string
+		seq    int
+		opts   SinkOptions
+		name   string
+	}{
+		{"out.json", "", 0, SinkOptions{}, "out.json"},
+		{"out.json", "", 3, SinkOptions{RotateSize: 1}, "out.00003.json"},
+		{"out.json", "http", 0, SinkOptions{Compression: CompressionGzip}, "out.http.json.gz"},
+		{"out.json.gz", "", 0, SinkOptions{Compression: CompressionGzip}, "out.json.gz"},
+		{"dir/out", "a/b", 1, SinkOptions{RotateInterval: time.Hour, Compression: CompressionZstd}, "dir/out.a_b.00001.zst"},
+	}
+	for _, test := range tests {
+		if name := sinkFileName(test.path, test.module, test.seq, &test.opts); name != test.name {
+			t.Errorf("sinkFileName(%q, %q, %d): got %q, expected %q", test.path, test.module, test.seq, name, test.name)
+		}
+	}
+}
+
+func TestSinkRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewSink(filepath.Join(dir, "out.json"), SinkOptions{RotateSize: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []string
+	for i := 0; i < 10; i++ {
+		records = append(records, fmt.Sprintf(`{"ip":"10.0.0.%d"}`, i))
+	}
+	writeRecords(t, sink, records...)
+
+	// each file gets two 18-byte lines before it reaches 20 bytes
+	files := listDir(t, dir)
+	if len(files) != 5 {
+		t.Errorf("expected 5 files, got %v", files)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, readLines(t, filepath.Join(dir, f))...)
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Errorf("wrong records: got %v", got)
+	}
+}
+
+func TestSinkRotateCompressed(t *testing.T) {
+	// Compressors emit data in blocks, so use enough incompressible data for
+	// several of them.
+	rng := rand.New(rand.NewSource(1))
+	var records []string
+	for i := 0; i < 2000; i++ {
+		data := make([]byte, 512)
+		rng.Read(data)
+		records = append(records, fmt.Sprintf(`{"banner":"%x"}`, data))
+	}
+	for _, compression := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			dir := t.TempDir()
+			sink, err := NewSink(filepath.Join(dir, "out.json"), SinkOptions{RotateSize: 256 * 1024, Compression: compression})
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeRecords(t, sink, records...)
+			files := listDir(t, dir)
+			if len(files) < 2 {
+				t.Fatalf("expected the output to be rotated, got %v", files)
+			}
+			var got []string
+			for _, f := range files {
+				got = append(got, readLines(t, filepath.Join(dir, f))...)
+			}
+			if !reflect.DeepEqual(got, records) {
+				t.Errorf("wrong records: got %d, expected %d", len(got), len(records))
+			}
+		})
+	}
+}
+
+func TestSinkRotateByTime(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewSink(filepath.Join(dir, "out.json"), SinkOptions{RotateInterval: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteRecord([]byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	writeRecords(t, sink, "{}")
+	if files := listDir(t, dir); !reflect.DeepEqual(files, []string{"out.00000.json", "out.00001.json"}) {
+		t.Errorf("wrong files: %v", files)
+	}
+}
+
+func TestSinkSplitByModule(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewSink(filepath.Join(dir, "out.json"), SinkOptions{SplitByModule: true, Compression: CompressionGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, sink,
+		`{"ip":"10.0.0.1","data":{"http":{"status":"success"},"ssh":{"status":"io-timeout"}}}`,
+		`{"ip":"10.0.0.2","port":8080,"data":{"http":{"status":"connection-refused"}}}`,
+		`{"domain":"nx.example.com","resolve_error":"no such host"}`,
+	)
+	if files := listDir(t, dir); !reflect.DeepEqual(files, []string{"out.http.json.gz", "out.json.gz", "out.ssh.json.gz"}) {
+		t.Fatalf("wrong files: %v", files)
+	}
+	expected := []string{`{"domain":"nx.example.com","resolve_error":"no such host"}`}
+	if got := readLines(t, filepath.Join(dir, "out.json.gz")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong records without data: %v", got)
+	}
+	expected = []string{
+		`{"ip":"10.0.0.1","data":{"http":{"status":"success"}}}`,
+		`{"ip":"10.0.0.2","port":8080,"data":{"http":{"status":"connection-refused"}}}`,
+	}
+	if got := readLines(t, filepath.Join(dir, "out.http.json.gz")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong http records: %v", got)
+	}
+	expected = []string{`{"ip":"10.0.0.1","data":{"ssh":{"status":"io-timeout"}}}`}
+	if got := readLines(t, filepath.Join(dir, "out.ssh.json.gz")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong ssh records: %v", got)
+	}
+}
+
+func TestSinkStdout(t *testing.T) {
+	if _, err := NewSink("-", SinkOptions{SplitByModule: true}); err != ErrSinkStdout {
+		t.Errorf("expected ErrSinkStdout, got %v", err)
+	}
+	if _, err := NewSink("-", SinkOptions{Compression: "lz4"}); err == nil {
+		t.Error("expected an error for an unknown compression")
+	}
+}
@@ -0,0 +1,163 @@
+package zgrab2
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// maxSourceIPs bounds the number of addresses given to --source-ip.
+const maxSourceIPs = 1 << 16
+
+// sourcePortTries is the number of source ports tried, in turn, when they
+// are already in use.
+const sourcePortTries = 8
+
+// sourcePool chooses the local addresses of the outbound connections, with
+// --source-ip and --source-port-range.
+type sourcePool struct {
+	v4, v6 []net.IP
+	hash   bool
+
+	// minPort and maxPort bound the source ports, if maxPort is not 0.
+	minPort, maxPort uint16
+
+	next, nextPort atomic.Uint32
+}
+
+// sourceAddresses is the pool of the configured --source-ip and
+// --source-port-range, if any.
+var sourceAddresses *sourcePool
+
+// newSourcePool returns a pool of the given addresses and ports, used in turn,
+// or chosen by a hash of the destination for the addresses if hash is set. It
+// returns nil if there are neither addresses nor ports.
+func newSourcePool(ips []net.IP, hash bool, minPort, maxPort uint16) *sourcePool {
+	if len(ips) == 0 && maxPort == 0 {
+		return nil
+	}
+	p := &sourcePool{hash: hash, minPort: minPort, maxPort: maxPort}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			p.v4 = append(p.v4, ip4)
+		} else {
+			p.v6 = append(p.v6, ip)
+		}
+	}
+	return p
+}
+
+// ParseSourceIPs parses a comma-separated list of IP addresses and CIDR
+// blocks. The network and broadcast addresses of IPv4 blocks are left out.
+func ParseSourceIPs(s string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", field)
+			}
+			ips = append(ips, ip)
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(field)
+		if err != nil {
+			return nil, err
+		}
+		ones, bits := ipnet.Mask.Size()
+		if bits-ones > 16 || len(ips)+1<<(bits-ones) > maxSourceIPs {
+			return nil, fmt.Errorf("too many source addresses in %s (at most %d)", field, maxSourceIPs)
+		}
+		n := 1 << (bits - ones)
+		skipEnds := bits == 32 && n > 2
+		ip := append(net.IP(nil), ipnet.IP...)
+		for i := 0; i < n; i++ {
+			if !skipEnds || (i != 0 && i != n-1) {
+				ips = append(ips, append(net.IP(nil), ip...))
+			}
+			incrementIP(ip)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no source address")
+	}
+	return ips, nil
+}
+
+// ParsePortRange parses a range of ports given as FIRST-LAST, or a single
+// port.
+func ParsePortRange(s string) (uint16, uint16, error) {
+	first, last, ok := strings.Cut(s, "-")
+	if !ok {
+		last = first
+	}
+	lo, err := strconv.ParseUint(strings.TrimSpace(first), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	hi, err := strconv.ParseUint(strings.TrimSpace(last), 10, 16)
+	if err != nil || lo == 0 || hi < lo {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return uint16(lo), uint16(hi), nil
+}
+
+// localAddr returns the local address to connect from to address on network,
+// or nil to leave the choice to the system. The source address has the family
+// of the destination; for a domain, IPv4 is preferred.
+func (p *sourcePool) localAddr(network, address string) net.Addr {
+	if p == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ips := p.v4
+	if ip := net.ParseIP(host); (ip != nil && ip.To4() == nil) || (ip == nil && len(p.v4) == 0) {
+		ips = p.v6
+	}
+	var ip net.IP
+	if len(ips) > 0 {
+		var i uint32
+		if p.hash {
+			h := fnv.New32a()
+			h.Write([]byte(host))
+			i = h.Sum32()
+		} else {
+			i = p.next.Add(1) - 1
+		}
+		ip = ips[i%uint32(len(ips))]
+	}
+	port := 0
+	if p.maxPort != 0 {
+		port = int(p.minPort) + int((p.nextPort.Add(1)-1)%(uint32(p.maxPort-p.minPort)+1))
+	}
+	if ip == nil && port == 0 {
+		return nil
+	}
+	if strings.HasPrefix(network, "udp") {
+		return &net.UDPAddr{IP: ip, Port: port}
+	}
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
+// dial calls dial with a local address to connect to address on network,
+// trying the next source ports while they are in use.
+func (p *sourcePool) dial(network, address string, dial func(local net.Addr) (net.Conn, error)) (net.Conn, error) {
+	for try := 1; ; try++ {
+		conn, err := dial(p.localAddr(network, address))
+		if err == nil || p == nil || p.maxPort == 0 || try == sourcePortTries || !errors.Is(err, syscall.EADDRINUSE) {
+			return conn, err
+		}
+	}
+}
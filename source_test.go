@@ -0,0 +1,88 @@
+package zgrab2
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestParseSourceIPs(t *testing.T) {
+	ips, err := ParseSourceIPs("192.0.2.8/30, 2001:db8::1,198.51.100.7/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"192.0.2.9", "192.0.2.10", "2001:db8::1", "198.51.100.7"}
+	if fmt.Sprint(ips) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, ips)
+	}
+	for _, s := range []string{"", "192.0.2.256", "10.0.0.0/8", "192.0.2.0/33"} {
+		if _, err := ParseSourceIPs(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+	if lo, hi, err := ParsePortRange("40000-40009"); err != nil || lo != 40000 || hi != 40009 {
+		t.Errorf("unexpected range %d-%d, %v", lo, hi, err)
+	}
+	for _, s := range []string{"0-10", "10-1", "1-65536", "a"} {
+		if _, _, err := ParsePortRange(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestSourcePool(t *testing.T) {
+	ips, _ := ParseSourceIPs("192.0.2.1,192.0.2.2,2001:db8::1")
+	pool := newSourcePool(ips, false, 40000, 40001)
+	var sources []string
+	for _, address := range []string{"198.51.100.1:80", "198.51.100.1:80", "198.51.100.1:80", "[2001:db8::2]:80"} {
+		sources = append(sources, pool.localAddr("tcp", address).String())
+	}
+	expected := []string{"192.0.2.1:40000", "192.0.2.2:40001", "192.0.2.1:40000", "[2001:db8::1]:40001"}
+	if fmt.Sprint(sources) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, sources)
+	}
+
+	hashed := newSourcePool(ips, true, 0, 0)
+	first := hashed.localAddr("udp", "198.51.100.1:53")
+	for i := 0; i < 10; i++ {
+		if addr := hashed.localAddr("udp", "198.51.100.1:53"); addr.String() != first.String() {
+			t.Errorf("expected the source to stay %s, got %s", first, addr)
+		}
+	}
+	if _, ok := first.(*net.UDPAddr); !ok {
+		t.Errorf("expected a UDP address, got %T", first)
+	}
+	if newSourcePool(nil, true, 0, 0).localAddr("tcp", "198.51.100.1:80") != nil {
+		t.Error("expected no source address from an empty pool")
+	}
+}
+
+func TestEngineSourceIPs(t *testing.T) {
+	port := echoListener(t)
+	scanner := &pingScanner{echoScanner: echoScanner{name: "ping"}, ping: "hello"}
+	engine, err := NewEngine(EngineConfig{
+		SourceIPs:       []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.3")},
+		TranscriptLimit: 1024,
+	}, scanner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := ScanTarget{IP: net.ParseIP("127.0.0.1"), Port: &port}
+	var grabs []*Grab
+	if err := engine.Run(context.Background(), IterateTargets([]ScanTarget{target, target}), collect(&grabs)); err != nil {
+		t.Fatal(err)
+	}
+	sources := make(map[string]bool)
+	for _, grab := range grabs {
+		res := grab.Data["ping"]
+		if res.Status != SCAN_SUCCESS {
+			t.Fatalf("scan failed: %s", *res.Error)
+		}
+		host, _, _ := net.SplitHostPort(res.Transcript.Connections[0].Local)
+		sources[host] = true
+	}
+	if !sources["127.0.0.2"] || !sources["127.0.0.3"] {
+		t.Errorf("expected connections from both source addresses, got %v", sources)
+	}
+}
@@ -1,6 +1,8 @@
 package zgrab2
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net"
 	"runtime/debug"
@@ -24,6 +26,7 @@ const (
 	SCAN_PROTOCOL_ERROR       = ScanStatus("protocol-error")     // Received data incompatible with the target protocol
 	SCAN_APPLICATION_ERROR    = ScanStatus("application-error")  // The application reported an error
 	SCAN_ENEXPECTED_EOF_ERROR = ScanStatus("unexpected-eof")     // The application reported an error
+	SCAN_BLOCKLISTED          = ScanStatus("blocklisted")        // The target address is excluded by the blocklist or allowlist
 	SCAN_UNKNOWN_ERROR        = ScanStatus("unknown-error")      // Catch-all for unrecognized errors
 )
 
@@ -62,6 +65,10 @@ func TryGetScanStatus(err error) ScanStatus {
 	if err == nil {
 		return SCAN_SUCCESS
 	}
+	if errors.Is(err, ErrBlocklisted) {
+		// Refused by the dialer, possibly wrapped in a net.OpError
+		return SCAN_BLOCKLISTED
+	}
 	if err == io.EOF {
 		// Presumably the caller did not call TryGetScanStatus if the EOF was expected
 		return SCAN_IO_TIMEOUT
@@ -90,6 +97,10 @@ func TryGetScanStatus(err error) ScanStatus {
 		}
 	// TODO: More error types
 	default:
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrTotalTimeout) {
+			// The session or --target-timeout ran out
+			return SCAN_IO_TIMEOUT
+		}
 		log.Debugf("Failed to detect error from %v at %s", e, string(debug.Stack()))
 		return SCAN_UNKNOWN_ERROR
 	}
@@ -153,7 +153,10 @@ func (t *TLSFlags) GetTLSConfigForTarget(target *ScanTarget) (*tls.Config, error
 		// TODO: Different format?
 		ret.NextProtos = getCSV(t.NextProtos)
 	}
-	if t.ServerName != "" {
+	if target != nil && target.ServerName != "" {
+		// A per-target server name overrides everything else.
+		ret.ServerName = target.ServerName
+	} else if t.ServerName != "" {
 		// TODO: In the original zgrab, this was only set of NoSNI was not set (though in that case, it set it to the scanning host name)
 		// Here, if an explicit ServerName is given, set that, ignoring NoSNI.
 		ret.ServerName = t.ServerName
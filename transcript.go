@@ -0,0 +1,155 @@
+package zgrab2
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Transcript records the traffic of the connections opened during a scan,
+// with --transcript.
+type Transcript struct {
+	// Connections lists the connections, in the order they were opened.
+	Connections []TranscriptConnection `json:"connections"`
+
+	// Events lists the reads and writes on the connections, in order.
+	Events []TranscriptEvent `json:"events"`
+
+	// Truncated is true if some data was left out, past --transcript-limit.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// TranscriptConnection identifies a connection of a Transcript.
+type TranscriptConnection struct {
+	Network string `json:"network"`
+	Local   string `json:"local,omitempty"`
+	Remote  string `json:"remote"`
+
+	// Time is the number of seconds since the start of the scan.
+	Time float64 `json:"time"`
+}
+
+// TranscriptEvent is a read or a write on a connection.
+type TranscriptEvent struct {
+	// Connection is the index of the connection in the Transcript.
+	Connection int `json:"connection"`
+
+	// Direction is "read" or "write".
+	Direction string `json:"direction"`
+
+	// Time is the number of seconds since the start of the scan.
+	Time float64 `json:"time"`
+
+	Data []byte `json:"data,omitempty"`
+
+	// Error is the error returned by the read or write, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// configTranscriptLimit returns the maximum size of the transcripts in bytes,
+// with --transcript, or 0.
+func configTranscriptLimit() int {
+	if !config.Transcript {
+		return 0
+	}
+	return config.TranscriptLimit * 1024
+}
+
+// transcriptKey is the context key of the transcriptRecorder of a scan.
+type transcriptKey struct{}
+
+// transcriptRecorder builds the Transcript of a scan. It is shared by the
+// connections opened with the context of the scan, and keeps up to limit
+// bytes of data, or all of it if limit is 0.
+type transcriptRecorder struct {
+	mu         sync.Mutex
+	start      time.Time
+	limit      int
+	size       int
+	transcript Transcript
+}
+
+// withTranscript returns a context whose connections are recorded, up to
+// limit bytes of data if positive.
+func withTranscript(ctx context.Context, limit int) (context.Context, *transcriptRecorder) {
+	r := &transcriptRecorder{start: time.Now(), limit: limit}
+	return context.WithValue(ctx, transcriptKey{}, r), r
+}
+
+// contextTranscript returns the transcriptRecorder of ctx, if any.
+func contextTranscript(ctx context.Context) *transcriptRecorder {
+	r, _ := ctx.Value(transcriptKey{}).(*transcriptRecorder)
+	return r
+}
+
+// open adds a connection to the transcript, and returns its index.
+func (r *transcriptRecorder) open(conn net.Conn) int {
+	tc := TranscriptConnection{Time: time.Since(r.start).Seconds()}
+	if addr := conn.RemoteAddr(); addr != nil {
+		tc.Network, tc.Remote = addr.Network(), addr.String()
+	}
+	if addr := conn.LocalAddr(); addr != nil {
+		tc.Local = addr.String()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transcript.Connections = append(r.transcript.Connections, tc)
+	return len(r.transcript.Connections) - 1
+}
+
+// record adds a read or a write on a connection to the transcript. Past the
+// limit, the events with an error are still recorded, without their data.
+func (r *transcriptRecorder) record(conn int, direction string, data []byte, err error) {
+	if len(data) == 0 && err == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.limit > 0 && r.size+len(data) > r.limit {
+		r.transcript.Truncated = true
+		data = data[:r.limit-r.size]
+		if len(data) == 0 && err == nil {
+			return
+		}
+	}
+	r.size += len(data)
+	event := TranscriptEvent{
+		Connection: conn,
+		Direction:  direction,
+		Time:       time.Since(r.start).Seconds(),
+		Data:       append([]byte(nil), data...),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	r.transcript.Events = append(r.transcript.Events, event)
+}
+
+// result returns the transcript, with up to limit bytes of data if positive,
+// or nil if no connection was opened. As in record, the events with an error
+// are kept past the limit.
+func (r *transcriptRecorder) result(limit int) *Transcript {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.transcript.Connections) == 0 {
+		return nil
+	}
+	t := &Transcript{
+		Connections: append([]TranscriptConnection(nil), r.transcript.Connections...),
+		Truncated:   r.transcript.Truncated,
+	}
+	size := 0
+	for _, event := range r.transcript.Events {
+		if limit > 0 && size+len(event.Data) > limit {
+			t.Truncated = true
+			event.Data = event.Data[:limit-size]
+			if len(event.Data) == 0 && event.Error == "" {
+				continue
+			}
+		}
+		size += len(event.Data)
+		t.Events = append(t.Events, event)
+	}
+	return t
+}
@@ -0,0 +1,125 @@
+package zgrab2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// echoListener echoes the data it receives.
+func echoListener(t *testing.T) uint {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return uint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// pingScanner writes ping to the target and reads the reply.
+type pingScanner struct {
+	echoScanner
+	ping string
+}
+
+func (s *pingScanner) Scan(t ScanTarget) (ScanStatus, interface{}, error) {
+	conn, err := t.Open(&BaseFlags{Timeout: time.Second})
+	if err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(s.ping)); err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	buf := make([]byte, len(s.ping))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return TryGetScanStatus(err), nil, err
+	}
+	return SCAN_SUCCESS, string(buf), nil
+}
+
+func TestTranscript(t *testing.T) {
+	port := echoListener(t)
+	scanner := &pingScanner{echoScanner: echoScanner{name: "ping"}, ping: "hello"}
+	target := ScanTarget{IP: net.ParseIP("127.0.0.1"), Port: &port}
+
+	for _, test := range []struct {
+		limit     int
+		read      string
+		truncated bool
+	}{
+		{0, "", false},
+		{1024, "hello", false},
+		{7, "he", true},
+	} {
+		engine, err := NewEngine(EngineConfig{TranscriptLimit: test.limit}, scanner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var grabs []*Grab
+		if err := engine.Run(context.Background(), IterateTargets([]ScanTarget{target}), collect(&grabs)); err != nil {
+			t.Fatal(err)
+		}
+		res := grabs[0].Data["ping"]
+		if res.Status != SCAN_SUCCESS {
+			t.Fatalf("limit %d: scan failed: %s", test.limit, *res.Error)
+		}
+		transcript := res.Transcript
+		if test.limit == 0 {
+			if transcript != nil {
+				t.Errorf("unexpected transcript %+v", transcript)
+			}
+			continue
+		}
+		if transcript == nil || len(transcript.Connections) != 1 || transcript.Truncated != test.truncated {
+			t.Fatalf("limit %d: unexpected transcript %+v", test.limit, transcript)
+		}
+		if c := transcript.Connections[0]; c.Network != "tcp" || c.Remote != fmt.Sprintf("127.0.0.1:%d", port) {
+			t.Errorf("limit %d: unexpected connection %+v", test.limit, c)
+		}
+		var written, read []byte
+		for _, event := range transcript.Events {
+			switch event.Direction {
+			case "write":
+				written = append(written, event.Data...)
+			case "read":
+				read = append(read, event.Data...)
+			}
+		}
+		if string(written) != "hello" || string(read) != test.read {
+			t.Errorf("limit %d: recorded %q written, %q read", test.limit, written, read)
+		}
+	}
+}
+
+func TestTranscriptErrorPastLimit(t *testing.T) {
+	_, r := withTranscript(context.Background(), 4)
+	conn := r.open(&net.TCPConn{})
+	r.record(conn, "write", []byte("hello"), nil)
+	r.record(conn, "read", []byte("hi"), nil)
+	r.record(conn, "read", nil, io.EOF)
+	for _, limit := range []int{0, 2} {
+		transcript := r.result(limit)
+		if len(transcript.Events) != 2 || !transcript.Truncated {
+			t.Fatalf("limit %d: unexpected transcript %+v", limit, transcript)
+		}
+		if event := transcript.Events[1]; event.Direction != "read" || event.Error != "EOF" || len(event.Data) != 0 {
+			t.Errorf("limit %d: unexpected event %+v", limit, event)
+		}
+	}
+}
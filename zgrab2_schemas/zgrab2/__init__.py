@@ -1,11 +1,13 @@
 # Ensure that all of the modules get executed so that they are registered
 from . import bacnet
 from . import dnp3
+from . import dns
 from . import fox
 from . import ftp
 from . import http
 from . import modbus
 from . import mongodb
+from . import mqtt
 from . import mssql
 from . import mysql
 from . import mysql_errors
@@ -14,10 +16,14 @@ from . import oracle
 from . import pop3
 from . import postgres
 from . import redis
+from . import rtsp
 from . import siemens
+from . import sip
 from . import smb
 from . import smtp
+from . import snmp
 from . import ssh
 from . import telnet
 from . import ipp
 from . import banner
+from . import nmap
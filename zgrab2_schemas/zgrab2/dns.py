@@ -0,0 +1,70 @@
+# zschema sub-schema for zgrab2's dns module
+# Registers zgrab2-dns globally, and dns with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+import zcrypto_schemas.zcrypto as zcrypto
+from . import zgrab2
+
+# modules/dns/dns.go - Record
+dns_record = SubRecord({
+    "name": String(),
+    "type": String(),
+    "class": String(),
+    "ttl": Unsigned32BitInteger(),
+    "address": String(),
+    "target": String(),
+    "preference": Unsigned16BitInteger(),
+    "txt": ListOf(String()),
+    "soa": SubRecord({
+        "ns": String(),
+        "mbox": String(),
+        "serial": Unsigned32BitInteger(),
+        "refresh": Unsigned32BitInteger(),
+        "retry": Unsigned32BitInteger(),
+        "expire": Unsigned32BitInteger(),
+        "min_ttl": Unsigned32BitInteger(),
+    }),
+    "data": Binary(),
+})
+
+# modules/dns/dns.go - Response
+dns_response = SubRecord({
+    "name": String(),
+    "type": String(),
+    "class": String(),
+    "rcode": String(),
+    "authoritative": Boolean(),
+    "truncated": Boolean(),
+    "recursion_available": Boolean(),
+    "authentic_data": Boolean(),
+    "answers": ListOf(dns_record),
+    "authorities": ListOf(dns_record),
+    "additionals": ListOf(dns_record),
+    "error": String(),
+})
+
+# modules/dns/scanner.go - Results
+dns_scan_response = SubRecord({
+    "result": SubRecord({
+        "recursion": dns_response,
+        "open_resolver": Boolean(),
+        "edns": SubRecord({
+            "response": dns_response,
+            "supported": Boolean(),
+            "version": Unsigned8BitInteger(),
+            "udp_size": Unsigned16BitInteger(),
+            "do": Boolean(),
+            "nsid": String(),
+            "dnssec": Boolean(),
+        }),
+        "chaos": ListOf(dns_response),
+        "version": String(),
+        "hostname": String(),
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-dns", dns_scan_response)
+
+zgrab2.register_scan_response_type("dns", dns_scan_response)
@@ -0,0 +1,56 @@
+# zschema sub-schema for zgrab2's mqtt module
+# Registers zgrab2-mqtt globally, and mqtt with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+import zcrypto_schemas.zcrypto as zcrypto
+from . import zgrab2
+
+# modules/mqtt/mqtt.go - Properties
+mqtt_properties = SubRecord({
+    "session_expiry_interval": Unsigned32BitInteger(),
+    "receive_maximum": Unsigned16BitInteger(),
+    "maximum_qos": Unsigned8BitInteger(),
+    "retain_available": Boolean(),
+    "maximum_packet_size": Unsigned32BitInteger(),
+    "assigned_client_id": String(),
+    "topic_alias_maximum": Unsigned16BitInteger(),
+    "reason_string": String(),
+    "user_properties": ListOf(SubRecord({
+        "name": String(),
+        "value": String(),
+    })),
+    "wildcard_subscription_available": Boolean(),
+    "subscription_identifiers_available": Boolean(),
+    "shared_subscription_available": Boolean(),
+    "server_keep_alive": Unsigned16BitInteger(),
+    "response_information": String(),
+    "server_reference": String(),
+    "authentication_method": String(),
+})
+
+# modules/mqtt/scanner.go - Results
+mqtt_scan_response = SubRecord({
+    "result": SubRecord({
+        "protocol_version": String(),
+        "session_present": Boolean(),
+        "return_code": Unsigned8BitInteger(),
+        "reason": String(),
+        "accepted": Boolean(),
+        "anonymous": Boolean(),
+        "properties": mqtt_properties,
+        "subscriptions": ListOf(SubRecord({
+            "filter": String(),
+            "return_code": Unsigned8BitInteger(),
+            "granted": Boolean(),
+        })),
+        "topics": ListOf(String()),
+        "broker_version": String(),
+        "tls": zgrab2.tls_log,
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-mqtt", mqtt_scan_response)
+
+zgrab2.register_scan_response_type("mqtt", mqtt_scan_response)
@@ -0,0 +1,23 @@
+# zschema sub-schema for zgrab2's nmap module
+# Registers zgrab2-nmap globally, and nmap with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+import zcrypto_schemas.zcrypto as zcrypto
+from . import zgrab2
+
+# modules/nmap/scanner.go - Results
+nmap_scan_response = SubRecord({
+    "result": SubRecord({
+        "service": zgrab2.service_info,
+        "tcpwrapped": Boolean(),
+        "probes_sent": ListOf(String()),
+        "banner": String(),
+        "tls": zgrab2.tls_log,
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-nmap", nmap_scan_response)
+
+zgrab2.register_scan_response_type("nmap", nmap_scan_response)
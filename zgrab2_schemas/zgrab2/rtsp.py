@@ -0,0 +1,59 @@
+# zschema sub-schema for zgrab2's rtsp module
+# Registers zgrab2-rtsp globally, and rtsp with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+import zcrypto_schemas.zcrypto as zcrypto
+from . import zgrab2
+
+# modules/rtsp/sdp.go - Media
+rtsp_media = SubRecord({
+    "type": String(),
+    "port": Unsigned16BitInteger(),
+    "protocol": String(),
+    "formats": ListOf(String()),
+    "codecs": ListOf(String()),
+    "control": String(),
+})
+
+# modules/rtsp/rtsp.go - Response
+rtsp_response = SubRecord({
+    "method": String(),
+    "url": String(),
+    "status_line": String(),
+    "version": String(),
+    "status_code": Signed32BitInteger(),
+    "reason_phrase": String(),
+    "server": String(),
+    "public": ListOf(String()),
+    "authenticate": ListOf(SubRecord({
+        "scheme": String(),
+        "realm": String(),
+    })),
+    "content_base": String(),
+    "content_type": String(),
+    "body": zgrab2.DebugOnly(String()),
+    "sdp": SubRecord({
+        "origin": String(),
+        "name": String(),
+        "information": String(),
+        "control": String(),
+        "media": ListOf(rtsp_media),
+    }),
+    "error": String(),
+})
+
+# modules/rtsp/scanner.go - Results
+rtsp_scan_response = SubRecord({
+    "result": SubRecord({
+        "options": rtsp_response,
+        "describe": ListOf(rtsp_response),
+        "stream": String(),
+        "tls": zgrab2.tls_log,
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-rtsp", rtsp_scan_response)
+
+zgrab2.register_scan_response_type("rtsp", rtsp_scan_response)
@@ -0,0 +1,32 @@
+# zschema sub-schema for zgrab2's sip module
+# Registers zgrab2-sip globally, and sip with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+import zcrypto_schemas.zcrypto as zcrypto
+from . import zgrab2
+
+# modules/sip/scanner.go - Results
+sip_scan_response = SubRecord({
+    "result": SubRecord({
+        "transport": String(),
+        "reflected": Boolean(),
+        "status_line": String(),
+        "version": String(),
+        "status_code": Signed32BitInteger(),
+        "reason_phrase": String(),
+        "server": String(),
+        "user_agent": String(),
+        "allow": ListOf(String()),
+        "supported": ListOf(String()),
+        "accept": ListOf(String()),
+        "received": String(),
+        "rport": Unsigned16BitInteger(),
+        "tls": zgrab2.tls_log,
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-sip", sip_scan_response)
+
+zgrab2.register_scan_response_type("sip", sip_scan_response)
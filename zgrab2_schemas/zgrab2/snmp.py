@@ -0,0 +1,54 @@
+# zschema sub-schema for zgrab2's snmp module
+# Registers zgrab2-snmp globally, and snmp with the main zgrab2 schema.
+from zschema.leaves import *
+from zschema.compounds import *
+import zschema.registry
+
+import zcrypto_schemas.zcrypto as zcrypto
+from . import zgrab2
+
+# modules/snmp/snmp.go - VarBind
+snmp_var_bind = SubRecord({
+    "oid": String(),
+    "type": String(),
+    "value": String(),
+})
+
+# modules/snmp/scanner.go - CommunityResponse
+snmp_community_response = SubRecord({
+    "community": String(),
+    "error_status": String(),
+    "error_index": Signed32BitInteger(),
+    "system": SubRecord({
+        "sys_descr": String(),
+        "sys_object_id": String(),
+        "sys_up_time": Unsigned32BitInteger(),
+        "sys_contact": String(),
+        "sys_name": String(),
+        "sys_location": String(),
+    }),
+    "bindings": zgrab2.DebugOnly(ListOf(snmp_var_bind)),
+})
+
+# modules/snmp/scanner.go - Results
+snmp_scan_response = SubRecord({
+    "result": SubRecord({
+        "v1": snmp_community_response,
+        "v2c": snmp_community_response,
+        "v3": SubRecord({
+            "engine_id": SubRecord({
+                "raw": String(),
+                "enterprise": Unsigned32BitInteger(),
+                "format": String(),
+                "data": String(),
+            }),
+            "engine_boots": Signed32BitInteger(),
+            "engine_time": Signed32BitInteger(),
+            "report": ListOf(snmp_var_bind),
+        }),
+    })
+}, extends=zgrab2.base_scan_response)
+
+zschema.registry.register_schema("zgrab2-snmp", snmp_scan_response)
+
+zgrab2.register_scan_response_type("snmp", snmp_scan_response)
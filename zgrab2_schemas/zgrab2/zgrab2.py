@@ -32,7 +32,9 @@ grab_result = Record({
     # TODO: ip may be required; see https://github.com/zmap/zgrab2/issues/104
     "ip": IPv4Address(required=False, doc="The IP address of the target."),
     "domain": String(required=False, doc="The domain name of the target, if available."),
+    "port": Unsigned16BitInteger(required=False, doc="The port of the target, if given in the input."),
     "data": SubRecord(scan_response_types, doc="The scan data for this host."),
+    "resolve_error": String(required=False, doc="The error resolving the domain of the target, which was then not scanned."),
 })
 
 # zgrab2/module.go: const SCAN_*
@@ -44,16 +46,49 @@ STATUS_VALUES = [
   "io-timeout",
   "protocol-error",
   "application-error",
+  "blocklisted",
   "unknown-error",
 ]
 
+# zgrab2/lib/nmap/match.go: ServiceInfo
+service_info = SubRecord({
+    "service": String(doc="The nmap service name."),
+    "product": String(doc="The product name."),
+    "version": String(doc="The product version."),
+    "info": String(doc="Miscellaneous further information."),
+    "hostname": String(doc="The hostname reported by the service."),
+    "os": String(doc="The operating system the service runs on."),
+    "device_type": String(doc="The type of device the service runs on."),
+    "cpe": ListOf(String(), doc="CPE names for the service, application or OS."),
+    "probe": String(doc="The name of the probe whose match directive matched."),
+    "softmatch": Boolean(doc="True if only a softmatch matched, in which case only service is reliable."),
+}, required=False)
+
 # zgrab2/module.go: ScanResponse
 base_scan_response = SubRecord({
     "status": Enum(values=STATUS_VALUES, doc="The status of the request."),
     "protocol": String(doc="The identifier of the protocol being scanned."),
     "timestamp": DateTime(doc="The time the scan was started."),
     "result": SubRecord({}, required=False),  # This is overridden by the protocols' implementations
-    "error": String(required=False, doc="If the status was not success, error may contain information about the failure.")
+    "error": String(required=False, doc="If the status was not success, error may contain information about the failure."),
+    "service": service_info,
+    "attempts": Unsigned32BitInteger(required=False, doc="The number of times the scan was tried, if --retries is enabled."),
+    "transcript": DebugOnly(SubRecord({
+        "connections": ListOf(SubRecord({
+            "network": String(doc="The network of the connection (tcp or udp)."),
+            "local": String(doc="The local address of the connection."),
+            "remote": String(doc="The remote address of the connection."),
+            "time": Double(doc="The number of seconds between the start of the scan and the connection."),
+        }), doc="The connections opened by the scan, in order."),
+        "events": ListOf(SubRecord({
+            "connection": Unsigned32BitInteger(doc="The index of the connection in connections."),
+            "direction": Enum(values=["read", "write"], doc="Whether the data was read or written."),
+            "time": Double(doc="The number of seconds since the start of the scan."),
+            "data": Binary(doc="The data read or written."),
+            "error": String(doc="The error returned by the read or write, if any."),
+        }), doc="The reads and writes on the connections, in order."),
+        "truncated": Boolean(doc="True if some data was left out, past --transcript-limit."),
+    }, required=False, doc="The data read and written by the scan, with --transcript.")),
     # TODO: error_component? domain?
 })
 